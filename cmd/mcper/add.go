@@ -28,13 +28,14 @@ Plugin sources can be:
 Examples:
   mcper add linkedin
   mcper add github@2.0.0 --env TOKEN=GITHUB_TOKEN
+  mcper add azdo --env AZDO_PAT=keyring:mcper/azdo
   mcper add ./local-plugin.wasm`,
 	Args: cobra.ExactArgs(1),
 	RunE: runAdd,
 }
 
 func init() {
-	addCmd.Flags().StringArrayVar(&addEnvVars, "env", nil, "Environment variable mapping (PLUGIN_VAR=ENV_VAR)")
+	addCmd.Flags().StringArrayVar(&addEnvVars, "env", nil, "Environment variable mapping (PLUGIN_VAR=ENV_VAR, or PLUGIN_VAR=<file:|cmd:|keyring:|env:>ref)")
 }
 
 // resolvePluginSource resolves a simple plugin name to a full GitHub releases URL
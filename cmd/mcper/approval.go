@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/google/jsonschema-go/jsonschema"
+	"github.com/joshcarp/mcper/pkg/mcper"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// manifestApprovals returns the approval modes a plugin's manifest
+// declares, keyed by tool name.
+func manifestApprovals(manifest *mcper.PluginInfoV2) map[string]string {
+	if manifest == nil {
+		return nil
+	}
+	modes := make(map[string]string)
+	for _, decl := range manifest.Tools {
+		if decl.ApprovalMode != "" {
+			modes[decl.Name] = decl.ApprovalMode
+		}
+	}
+	return modes
+}
+
+// approvals remembers the tools each client session approved for the rest
+// of the session.
+type approvals struct {
+	mu      sync.Mutex
+	granted map[*mcp.ServerSession]map[string]bool
+}
+
+func (a *approvals) approved(session *mcp.ServerSession, tool string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.granted[session][tool]
+}
+
+// remember records that `session` approved all calls to `tool`, until the
+// session ends.
+func (a *approvals) remember(session *mcp.ServerSession, tool string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.granted == nil {
+		a.granted = make(map[*mcp.ServerSession]map[string]bool)
+	}
+	if a.granted[session] == nil {
+		a.granted[session] = make(map[string]bool)
+		go func() {
+			session.Wait()
+			a.mu.Lock()
+			defer a.mu.Unlock()
+			delete(a.granted, session)
+		}()
+	}
+	a.granted[session][tool] = true
+}
+
+// approvalSchema is the form shown with an approval prompt. Accepting it
+// approves the call; the user may also approve the tool for the session.
+var approvalSchema = &jsonschema.Schema{
+	Type: "object",
+	Properties: map[string]*jsonschema.Schema{
+		"remember": {
+			Type:        "boolean",
+			Title:       "Don't ask again",
+			Description: "Allow further calls to this tool without asking until the session ends",
+		},
+	},
+}
+
+// Approval outcomes recorded in the audit log. A declined or cancelled
+// prompt records the client's action instead.
+const (
+	approvalAllowed    = "allowed"    // the tool needs no approval
+	approvalApproved   = "approved"   // the user confirmed this call
+	approvalRemembered = "remembered" // the user approved the tool for the session
+	approvalFailed     = "failed"     // the user couldn't be asked
+)
+
+// approve asks the user behind `session` to confirm a call to a tool with
+// approval mode "pre", showing its arguments. It returns the outcome for
+// the audit log, and an error unless the call may go ahead. Clients that
+// can't be asked get an error, so the call is never forwarded unapproved.
+func (s *pluginScope) approve(ctx context.Context, session *mcp.ServerSession, name forwardedName, args map[string]any) (string, error) {
+	if session == nil {
+		return approvalFailed, fmt.Errorf("%s needs approval but there is no client to ask", name.Name)
+	}
+	if s.approvals.approved(session, name.Name) {
+		return approvalRemembered, nil
+	}
+	argsJSON, err := json.MarshalIndent(args, "", "  ")
+	if err != nil {
+		return approvalFailed, err
+	}
+	result, err := session.Elicit(ctx, &mcp.ElicitParams{
+		Message:         fmt.Sprintf("Allow %s (%s from %s) to run with these arguments?\n\n%s", name.Name, name.Tool, name.Source, argsJSON),
+		RequestedSchema: approvalSchema,
+	})
+	if err != nil {
+		return approvalFailed, fmt.Errorf("%s needs approval but asking failed: %w", name.Name, err)
+	}
+	if result.Action != "accept" {
+		return result.Action, fmt.Errorf("call to %s was not approved (%s)", name.Name, result.Action)
+	}
+	if remember, _ := result.Content["remember"].(bool); remember {
+		s.approvals.remember(session, name.Name)
+	}
+	return approvalApproved, nil
+}
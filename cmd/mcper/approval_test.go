@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/google/jsonschema-go/jsonschema"
+	"github.com/joshcarp/mcper/pkg/mcper"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+func TestForwardedTool_PreApproval(t *testing.T) {
+	ctx := context.Background()
+
+	var created atomic.Int32
+	plugin := mcp.NewServer(&mcp.Implementation{Name: "github", Version: "test"}, nil)
+	mcp.AddTool(plugin, &mcp.Tool{Name: "create_issue", InputSchema: &jsonschema.Schema{Type: "object"}}, func(ctx context.Context, req *mcp.CallToolRequest, input map[string]any) (*mcp.CallToolResult, any, error) {
+		created.Add(1)
+		return textResult("created"), nil, nil
+	})
+	server := mcp.NewServer(&mcp.Implementation{Name: "mcper", Version: "test"}, nil)
+	scope := &pluginScope{server: server, source: "github.wasm", progress: &progressRelay{}}
+	registerForwardedTool(scope, &pluginConn{session: connect(t, plugin)}, namespaceWASM, "github", "Tool call failed", &mcp.Tool{Name: "create_issue"}, mcper.ApprovalPre, nil)
+
+	tests := []struct {
+		name        string
+		answers     []*mcp.ElicitResult // one per prompt, in order
+		calls       int
+		wantCreated int32
+		wantAsked   int
+	}{
+		{"declined", []*mcp.ElicitResult{{Action: "decline"}}, 1, 0, 1},
+		{"cancelled", []*mcp.ElicitResult{{Action: "cancel"}}, 1, 0, 1},
+		{"approved once", []*mcp.ElicitResult{{Action: "accept"}, {Action: "decline"}}, 2, 1, 2},
+		{"approved for the session", []*mcp.ElicitResult{{Action: "accept", Content: map[string]any{"remember": true}}}, 3, 3, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			created.Store(0)
+			var prompts []string
+			client := mcp.NewClient(&mcp.Implementation{Name: "client", Version: "test"}, &mcp.ClientOptions{
+				ElicitationHandler: func(_ context.Context, req *mcp.ElicitRequest) (*mcp.ElicitResult, error) {
+					prompts = append(prompts, req.Params.Message)
+					return tt.answers[len(prompts)-1], nil
+				},
+			})
+			serverTransport, clientTransport := mcp.NewInMemoryTransports()
+			if _, err := server.Connect(ctx, serverTransport, nil); err != nil {
+				t.Fatal(err)
+			}
+			session, err := client.Connect(ctx, clientTransport, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer session.Close()
+
+			for range tt.calls {
+				if _, err := session.CallTool(ctx, &mcp.CallToolParams{Name: "wasm_github_create_issue", Arguments: map[string]any{"title": "Flaky test"}}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := created.Load(); got != tt.wantCreated {
+				t.Errorf("plugin called %d times, want %d", got, tt.wantCreated)
+			}
+			if len(prompts) != tt.wantAsked {
+				t.Fatalf("asked %d times, want %d", len(prompts), tt.wantAsked)
+			}
+			if !strings.Contains(prompts[0], "Flaky test") {
+				t.Errorf("prompt %q doesn't show the arguments", prompts[0])
+			}
+		})
+	}
+}
+
+func TestForwardedTool_PreApprovalWithoutElicitation(t *testing.T) {
+	ctx := context.Background()
+
+	var created atomic.Int32
+	plugin := mcp.NewServer(&mcp.Implementation{Name: "github", Version: "test"}, nil)
+	mcp.AddTool(plugin, &mcp.Tool{Name: "create_issue", InputSchema: &jsonschema.Schema{Type: "object"}}, func(ctx context.Context, req *mcp.CallToolRequest, input map[string]any) (*mcp.CallToolResult, any, error) {
+		created.Add(1)
+		return textResult("created"), nil, nil
+	})
+	server := mcp.NewServer(&mcp.Implementation{Name: "mcper", Version: "test"}, nil)
+	scope := &pluginScope{server: server, source: "github.wasm", progress: &progressRelay{}}
+	registerForwardedTool(scope, &pluginConn{session: connect(t, plugin)}, namespaceWASM, "github", "Tool call failed", &mcp.Tool{Name: "create_issue"}, mcper.ApprovalPre, nil)
+
+	// The client can't be asked, so the call must not go through.
+	result, err := connect(t, server).CallTool(ctx, &mcp.CallToolParams{Name: "wasm_github_create_issue", Arguments: map[string]any{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.IsError || created.Load() != 0 {
+		t.Errorf("call forwarded without approval: %+v", result.Content)
+	}
+}
+
+func TestRegisterForwardedTools_DenyHidesTool(t *testing.T) {
+	server := mcp.NewServer(&mcp.Implementation{Name: "mcper", Version: "test"}, nil)
+	session := connect(t, server)
+	scope := &pluginScope{server: server, progress: &progressRelay{}, rules: &mcper.ToolRules{
+		Approval: map[string]string{"merge_*": mcper.ApprovalDeny},
+	}}
+	tools := []*mcp.Tool{{Name: "list_pull_requests"}, {Name: "merge_pull_request"}, {Name: "delete_branch"}}
+	declared := map[string]string{"delete_branch": mcper.ApprovalDeny}
+	if err := registerForwardedTools(scope, &pluginConn{}, namespaceWASM, "azdo", "Tool call failed", tools, declared, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]bool{
+		"wasm_azdo_list_pull_requests": true,
+		"wasm_azdo_merge_pull_request": false, // denied by config
+		"wasm_azdo_delete_branch":      false, // denied by the manifest
+	} {
+		if got := hasTool(t, session, name); got != want {
+			t.Errorf("%s listed = %v, want %v", name, got, want)
+		}
+	}
+}
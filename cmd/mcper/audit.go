@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"unicode/utf8"
+
+	"github.com/joshcarp/mcper/pkg/mcper"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/spf13/cobra"
+)
+
+// Cap outcomes recorded in the audit log for plugins in cap proxy mode.
+const (
+	capMinted      = "minted"
+	capApproved    = "approved" // minted once approved in mcper-cloud
+	capMintFailed  = "mint_failed"
+	capNotApproved = "not_approved"
+)
+
+// auditErrorMax caps the length of error text kept in the audit log.
+const auditErrorMax = 512
+
+// auditFollowInterval is how often mcper audit --follow checks for new
+// entries.
+const auditFollowInterval = time.Second
+
+// record appends a tool call to the scope's audit log. A call that can't be
+// recorded still goes ahead; the failure is logged.
+func (s *pluginScope) record(entry mcper.AuditEntry) {
+	if s.audit == nil {
+		return
+	}
+	if err := s.audit.Append(entry); err != nil {
+		slog.Warn("Failed to record call in audit log", "tool", entry.Tool, "invocation_id", entry.InvocationID, "err", err)
+	}
+}
+
+// resultText returns the text content of a tool result for the audit log:
+// scrubbed of secrets and shortened, without splitting a UTF-8 character.
+func resultText(result *mcp.CallToolResult) string {
+	var parts []string
+	for _, content := range result.Content {
+		if text, ok := content.(*mcp.TextContent); ok {
+			parts = append(parts, text.Text)
+		}
+	}
+	text := mcper.Scrub(strings.Join(parts, "\n"))
+	if len(text) > auditErrorMax {
+		cut := auditErrorMax
+		for cut > 0 && !utf8.RuneStart(text[cut]) {
+			cut--
+		}
+		text = text[:cut] + "..."
+	}
+	return text
+}
+
+var (
+	auditPlugin string
+	auditTool   string
+	auditErrors bool
+	auditSince  time.Duration
+	auditLines  int
+	auditFollow bool
+	auditJSON   bool
+)
+
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Show the tool call audit log",
+	Long: `Show the most recent tool calls recorded by mcper serve in
+~/.mcper/audit.jsonl.
+
+Every forwarded tool call is logged with its invocation ID, plugin, tool,
+a digest of its arguments, the approval and cap outcome, how long it took
+and any error. Arguments themselves are never logged. Entries are hash
+chained: use 'mcper audit verify' to check none were edited or removed.
+
+Examples:
+  mcper audit                         Show the last 20 calls
+  mcper audit --tool 'wasm_github_*'  Only calls to matching tools
+  mcper audit --errors --since 1h     Failed calls in the last hour
+  mcper audit -f                      Keep printing new calls as they happen`,
+	Args: cobra.NoArgs,
+	RunE: runAudit,
+}
+
+var auditVerifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Check the audit log's hash chain",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		logPath, err := mcper.GetAuditPath()
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(logPath)
+		if err != nil {
+			return fmt.Errorf("failed to open audit log: %w", err)
+		}
+		defer f.Close()
+		count, err := mcper.VerifyAudit(f)
+		if err != nil {
+			return fmt.Errorf("audit log %s failed verification after %d entries: %w", logPath, count, err)
+		}
+		fmt.Printf("%s: %d entries, chain intact\n", logPath, count)
+		return nil
+	},
+}
+
+func init() {
+	auditCmd.Flags().StringVar(&auditPlugin, "plugin", "", "Only show calls to plugins whose source contains this")
+	auditCmd.Flags().StringVar(&auditTool, "tool", "", "Only show calls to tools matching this pattern")
+	auditCmd.Flags().BoolVar(&auditErrors, "errors", false, "Only show calls that failed")
+	auditCmd.Flags().DurationVar(&auditSince, "since", 0, "Only show calls made within this long (e.g. 1h)")
+	auditCmd.Flags().IntVarP(&auditLines, "lines", "n", 20, "Number of calls to show (0 for all)")
+	auditCmd.Flags().BoolVarP(&auditFollow, "follow", "f", false, "Keep printing new calls")
+	auditCmd.Flags().BoolVar(&auditJSON, "json", false, "Output entries as JSON lines")
+	auditCmd.AddCommand(auditVerifyCmd)
+}
+
+// auditFilter selects audit entries to show.
+type auditFilter struct {
+	plugin string
+	tool   string // path.Match pattern
+	errors bool
+	since  time.Time
+}
+
+func (f auditFilter) match(entry mcper.AuditEntry) bool {
+	if f.plugin != "" && !strings.Contains(entry.Plugin, f.plugin) {
+		return false
+	}
+	if f.tool != "" {
+		if ok, _ := path.Match(f.tool, entry.Tool); !ok {
+			return false
+		}
+	}
+	if f.errors && entry.Error == "" {
+		return false
+	}
+	return f.since.IsZero() || !entry.Time.Before(f.since)
+}
+
+func runAudit(cmd *cobra.Command, args []string) error {
+	if _, err := path.Match(auditTool, ""); err != nil {
+		return fmt.Errorf("invalid --tool pattern %q: %w", auditTool, err)
+	}
+	filter := auditFilter{plugin: auditPlugin, tool: auditTool, errors: auditErrors}
+	if auditSince > 0 {
+		filter.since = time.Now().Add(-auditSince)
+	}
+	logPath, err := mcper.GetAuditPath()
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(logPath)
+	if os.IsNotExist(err) && !auditFollow {
+		fmt.Println("No tool calls recorded yet.")
+		return nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	var entries []mcper.AuditEntry
+	var offset int64
+	if err == nil {
+		offset, err = readAuditFrom(f, 0, func(entry mcper.AuditEntry) {
+			if !filter.match(entry) {
+				return
+			}
+			entries = append(entries, entry)
+			if auditLines > 0 && len(entries) > auditLines {
+				entries = entries[1:]
+			}
+		})
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	out := os.Stdout
+	printAuditEntries(out, entries, auditJSON)
+	if !auditFollow {
+		return nil
+	}
+
+	ctx, cancel := signal.NotifyContext(cmd.Context(), os.Interrupt)
+	defer cancel()
+	return followAudit(ctx, logPath, offset, func(entry mcper.AuditEntry) {
+		if filter.match(entry) {
+			printAuditEntries(out, []mcper.AuditEntry{entry}, auditJSON)
+		}
+	})
+}
+
+// readAuditFrom calls fn with each complete entry in `f` from `offset` on,
+// returning the offset just past the last one. A partly written last line
+// is left for the next read.
+func readAuditFrom(f *os.File, offset int64, fn func(mcper.AuditEntry)) (int64, error) {
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return offset, err
+	}
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			return offset, nil
+		}
+		if err != nil {
+			return offset, err
+		}
+		offset += int64(len(line))
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var entry mcper.AuditEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return offset, fmt.Errorf("malformed audit log entry: %w", err)
+		}
+		fn(entry)
+	}
+}
+
+// followAudit polls the log at `logPath` for entries past `offset` until
+// ctx is cancelled.
+func followAudit(ctx context.Context, logPath string, offset int64, fn func(mcper.AuditEntry)) error {
+	ticker := time.NewTicker(auditFollowInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		f, err := os.Open(logPath)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		offset, err = readAuditFrom(f, offset, fn)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func printAuditEntries(out io.Writer, entries []mcper.AuditEntry, asJSON bool) {
+	if asJSON {
+		enc := json.NewEncoder(out)
+		for _, entry := range entries {
+			enc.Encode(entry)
+		}
+		return
+	}
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	for _, entry := range entries {
+		outcome := entry.Approval
+		if entry.Cap != "" {
+			outcome += "/cap " + entry.Cap
+		}
+		result := "ok"
+		if entry.Error != "" {
+			result = "error: " + strings.ReplaceAll(entry.Error, "\n", " ")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%dms\t%s\t%s\n",
+			entry.Time.Local().Format("2006-01-02 15:04:05"),
+			entry.InvocationID, entry.Tool, outcome, entry.DurationMS, entry.Plugin, result)
+	}
+	w.Flush()
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/jsonschema-go/jsonschema"
+	"github.com/joshcarp/mcper/pkg/mcper"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+func TestForwardedTool_Audited(t *testing.T) {
+	ctx := context.Background()
+
+	plugin := mcp.NewServer(&mcp.Implementation{Name: "github", Version: "test"}, nil)
+	mcp.AddTool(plugin, &mcp.Tool{Name: "create_issue", InputSchema: &jsonschema.Schema{Type: "object"}}, func(ctx context.Context, req *mcp.CallToolRequest, input map[string]any) (*mcp.CallToolResult, any, error) {
+		if input["title"] == "" {
+			return errorResult("title is required"), nil, nil
+		}
+		return textResult("created"), nil, nil
+	})
+	logPath := filepath.Join(t.TempDir(), mcper.AuditFile)
+	audit, err := mcper.OpenAuditLog(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := mcp.NewServer(&mcp.Implementation{Name: "mcper", Version: "test"}, nil)
+	scope := &pluginScope{server: server, source: "github.wasm", progress: &progressRelay{}, audit: audit}
+	registerForwardedTool(scope, &pluginConn{session: connect(t, plugin)}, namespaceWASM, "github", "Tool call failed", &mcp.Tool{Name: "create_issue"}, mcper.ApprovalAllow, nil)
+
+	session := connect(t, server)
+	for _, title := range []string{"Flaky test", ""} {
+		if _, err := session.CallTool(ctx, &mcp.CallToolParams{Name: "wasm_github_create_issue", Arguments: map[string]any{"title": title}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var entries []mcper.AuditEntry
+	if err := mcper.ReadAuditLog(logPath, func(e mcper.AuditEntry) error {
+		entries = append(entries, e)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("got %d audit entries, want 2", len(entries))
+	}
+	ok, failed := entries[0], entries[1]
+	if ok.Tool != "wasm_github_create_issue" || ok.PluginTool != "create_issue" || ok.Plugin != "github.wasm" {
+		t.Errorf("entry names the wrong tool: %+v", ok)
+	}
+	if ok.InvocationID == "" || ok.InvocationID == failed.InvocationID {
+		t.Errorf("invocation IDs not unique: %q, %q", ok.InvocationID, failed.InvocationID)
+	}
+	if ok.Approval != approvalAllowed || ok.Error != "" {
+		t.Errorf("successful call recorded as %q, error %q", ok.Approval, ok.Error)
+	}
+	if ok.ArgsDigest != mcper.ArgsDigest(map[string]any{"title": "Flaky test"}) {
+		t.Errorf("args digest = %q", ok.ArgsDigest)
+	}
+	if failed.Error != "title is required" {
+		t.Errorf("failed call recorded error %q", failed.Error)
+	}
+
+	data, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "Flaky test") {
+		t.Error("audit log contains tool arguments")
+	}
+}
+
+func TestAuditFilter(t *testing.T) {
+	now := time.Now()
+	entry := mcper.AuditEntry{Time: now.Add(-time.Minute), Plugin: "ghcr.io/joshcarp/github:1.0", Tool: "wasm_github_create_issue"}
+	failed := entry
+	failed.Error = "boom"
+
+	tests := []struct {
+		name   string
+		filter auditFilter
+		entry  mcper.AuditEntry
+		want   bool
+	}{
+		{"no filter", auditFilter{}, entry, true},
+		{"plugin", auditFilter{plugin: "joshcarp/github"}, entry, true},
+		{"other plugin", auditFilter{plugin: "azdo"}, entry, false},
+		{"tool pattern", auditFilter{tool: "wasm_github_*"}, entry, true},
+		{"other tool", auditFilter{tool: "wasm_azdo_*"}, entry, false},
+		{"errors only", auditFilter{errors: true}, entry, false},
+		{"errors only, failed", auditFilter{errors: true}, failed, true},
+		{"since", auditFilter{since: now.Add(-time.Hour)}, entry, true},
+		{"too old", auditFilter{since: now}, entry, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.match(tt.entry); got != tt.want {
+				t.Errorf("match = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResultText(t *testing.T) {
+	long := strings.Repeat("a", auditErrorMax-1) + "é and more"
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"short", "not found", "not found"},
+		{"cut on a rune boundary", long, strings.Repeat("a", auditErrorMax-1) + "..."},
+		{"secrets scrubbed", "upstream said: Authorization: Bearer abc.def.ghi", "upstream said: Authorization: <REDACTED>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := resultText(errorResult(tt.text))
+			if got != tt.want {
+				t.Errorf("resultText = %q, want %q", got, tt.want)
+			}
+			if !utf8.ValidString(got) {
+				t.Errorf("resultText = %q, not valid UTF-8", got)
+			}
+		})
+	}
+}
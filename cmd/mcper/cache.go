@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/joshcarp/mcper/pkg/mcper"
 	"github.com/spf13/cobra"
@@ -68,8 +69,73 @@ var cachePathCmd = &cobra.Command{
 	},
 }
 
+var cacheCompiledCmd = &cobra.Command{
+	Use:   "compiled",
+	Short: "List compiled plugin code",
+	Long:  `List the compiled code cached under ~/.mcper/cache/compiled/, keyed by plugin SHA256`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		entries, err := mcper.ListCompiledCache()
+		if err != nil {
+			return err
+		}
+
+		if len(entries) == 0 {
+			fmt.Println("No compiled plugins cached.")
+			return nil
+		}
+
+		var total int64
+		fmt.Println("Compiled plugins:")
+		for _, entry := range entries {
+			source := entry.Source
+			if source == "" {
+				source = "(no cached plugin, e.g. a local build)"
+			}
+			fmt.Printf("  %s\n", entry.SHA256[:16]+"...")
+			fmt.Printf("    Plugin: %s\n", source)
+			fmt.Printf("    Size: %d bytes\n", entry.Size)
+			fmt.Printf("    Last used: %s\n", entry.LastUsed.Format("2006-01-02 15:04:05"))
+			total += entry.Size
+		}
+		fmt.Printf("\nTotal: %d entries, %d bytes\n", len(entries), total)
+
+		return nil
+	},
+}
+
+var (
+	cachePruneAll       bool
+	cachePruneOlderThan time.Duration
+)
+
+var cachePruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Remove unused compiled plugin code",
+	Long: `Remove compiled code that no cached plugin refers to, such as code for
+superseded plugin versions. Use --all to remove every entry and
+--older-than to only remove entries that have not been used recently.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		removed, err := mcper.PruneCompiledCache(cachePruneAll, cachePruneOlderThan)
+		if err != nil {
+			return fmt.Errorf("failed to prune compiled cache: %w", err)
+		}
+
+		var freed int64
+		for _, entry := range removed {
+			freed += entry.Size
+		}
+		fmt.Printf("Removed %d compiled entries (%d bytes).\n", len(removed), freed)
+		return nil
+	},
+}
+
 func init() {
 	cacheCmd.AddCommand(cacheListCmd)
 	cacheCmd.AddCommand(cacheCleanCmd)
 	cacheCmd.AddCommand(cachePathCmd)
+	cacheCmd.AddCommand(cacheCompiledCmd)
+	cacheCmd.AddCommand(cachePruneCmd)
+
+	cachePruneCmd.Flags().BoolVar(&cachePruneAll, "all", false, "Remove every compiled entry, not just unreferenced ones")
+	cachePruneCmd.Flags().DurationVar(&cachePruneOlderThan, "older-than", 0, "Only remove entries unused for at least this long (e.g. 720h)")
 }
@@ -105,11 +105,14 @@ func enableForClaude() error {
 		return fmt.Errorf("failed to parse start.sh: %w", err)
 	}
 
-	// Collect all unique env vars from plugins
+	// Collect all unique env vars from plugins. Secret references
+	// (file:, cmd:, keyring:) are resolved by mcper serve, not the client.
 	envVars := make(map[string]string)
 	for _, plugin := range config.Plugins {
-		for _, envVar := range plugin.Env {
-			envVars[envVar] = ""
+		for _, ref := range plugin.Env {
+			if envVar, ok := mcper.SecretEnvName(ref); ok {
+				envVars[envVar] = ""
+			}
 		}
 	}
 
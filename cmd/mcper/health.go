@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/jsonschema-go/jsonschema"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// Retry policy for plugins that fail to load. mcper serve keeps running
+// without them and retries in the background, backing off exponentially
+// from loadRetryMin to loadRetryMax.
+const (
+	loadRetryMin = 5 * time.Second
+	loadRetryMax = 5 * time.Minute
+)
+
+// Plugin health states reported by mcper/native/plugin_health.
+const (
+	healthLoaded   = "loaded"
+	healthRetrying = "retrying"
+	healthFailed   = "failed" // not retried, e.g. the source can't be parsed
+)
+
+// pluginHealth tracks whether one configured plugin has loaded. Its tools
+// are registered once it does, which notifies clients with
+// tools/list_changed.
+type pluginHealth struct {
+	name   string // e.g. "plugin-0"
+	source string
+	kind   string // e.g. "HTTP plugin", for logs
+	load   func(ctx context.Context) error
+
+	wait func(ctx context.Context, d time.Duration) bool // replaced in tests
+
+	mu        sync.Mutex
+	state     string
+	attempts  int
+	lastError string
+	retryAt   time.Time
+	loadedAt  time.Time
+}
+
+// attempt loads the plugin once, recording the outcome.
+func (p *pluginHealth) attempt(ctx context.Context, backoff time.Duration) error {
+	err := p.load(ctx)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.attempts++
+	if err != nil {
+		p.state = healthRetrying
+		p.lastError = err.Error()
+		p.retryAt = time.Now().Add(backoff)
+		slog.Error("Failed to load plugin", "kind", p.kind, "source", p.source, "err", err, "retry_in", backoff)
+		return err
+	}
+	p.state = healthLoaded
+	p.lastError = ""
+	p.retryAt = time.Time{}
+	p.loadedAt = time.Now()
+	slog.Info("Loaded plugin", "kind", p.kind, "source", p.source)
+	return nil
+}
+
+// retry keeps loading the plugin until it succeeds or ctx is cancelled.
+func (p *pluginHealth) retry(ctx context.Context, backoff time.Duration) {
+	for {
+		if !p.wait(ctx, backoff) {
+			return
+		}
+		backoff = min(backoff*2, loadRetryMax)
+		if err := p.attempt(ctx, backoff); err == nil {
+			return
+		}
+	}
+}
+
+// pluginHealthStatus is a point-in-time view of one plugin.
+type pluginHealthStatus struct {
+	Name      string    `json:"name"`
+	Source    string    `json:"source"`
+	State     string    `json:"state"`
+	Attempts  int       `json:"attempts"`
+	LastError string    `json:"last_error,omitempty"`
+	RetryAt   time.Time `json:"retry_at"`
+	LoadedAt  time.Time `json:"loaded_at"`
+}
+
+func (p *pluginHealth) status() pluginHealthStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return pluginHealthStatus{
+		Name:      p.name,
+		Source:    p.source,
+		State:     p.state,
+		Attempts:  p.attempts,
+		LastError: p.lastError,
+		RetryAt:   p.retryAt,
+		LoadedAt:  p.loadedAt,
+	}
+}
+
+// healthSet holds the health of every plugin in the serve config.
+type healthSet struct {
+	mu      sync.Mutex
+	plugins []*pluginHealth
+}
+
+// load tries to load a plugin and, if that fails, keeps retrying in the
+// background until ctx is cancelled.
+func (set *healthSet) load(ctx context.Context, name, source, kind string, load func(ctx context.Context) error) *pluginHealth {
+	p := &pluginHealth{name: name, source: source, kind: kind, load: load, wait: sleepContext}
+	set.add(p)
+
+	slog.Info("Loading plugin", "kind", kind, "source", source)
+	if err := p.attempt(ctx, loadRetryMin); err != nil {
+		go p.retry(ctx, loadRetryMin)
+	}
+	return p
+}
+
+// fail records a plugin that can't be loaded at all, so it is reported
+// rather than silently missing.
+func (set *healthSet) fail(name, source string, err error) *pluginHealth {
+	slog.Error("Skipping plugin", "source", source, "err", err)
+	p := &pluginHealth{name: name, source: source, state: healthFailed, lastError: err.Error()}
+	set.add(p)
+	return p
+}
+
+func (set *healthSet) add(p *pluginHealth) {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	set.plugins = append(set.plugins, p)
+}
+
+// remove forgets `p`, e.g. once the plugin is removed from the config.
+func (set *healthSet) remove(p *pluginHealth) {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	for i, other := range set.plugins {
+		if other == p {
+			set.plugins = append(set.plugins[:i], set.plugins[i+1:]...)
+			return
+		}
+	}
+}
+
+func (set *healthSet) statuses() []pluginHealthStatus {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	statuses := make([]pluginHealthStatus, 0, len(set.plugins))
+	for _, p := range set.plugins {
+		statuses = append(statuses, p.status())
+	}
+	return statuses
+}
+
+// registerHealthTool adds mcper/native/plugin_health, which reports which
+// configured plugins failed to load and when they are next retried.
+func registerHealthTool(server *mcp.Server, set *healthSet) {
+	mcp.AddTool[map[string]any, any](server, &mcp.Tool{
+		Name:        "mcper/native/plugin_health",
+		Description: "Show whether each configured plugin loaded. Plugins that failed are listed with their error and retried in the background; their tools appear once they load.",
+		InputSchema: &jsonschema.Schema{
+			Type:       "object",
+			Properties: map[string]*jsonschema.Schema{},
+		},
+	}, func(ctx context.Context, _ *mcp.CallToolRequest, input map[string]any) (*mcp.CallToolResult, any, error) {
+		return textResult(formatPluginHealth(set.statuses())), nil, nil
+	})
+}
+
+func formatPluginHealth(statuses []pluginHealthStatus) string {
+	if len(statuses) == 0 {
+		return "No plugins configured."
+	}
+	var sb strings.Builder
+	sb.WriteString("# Plugin Health\n\n")
+	for _, st := range statuses {
+		sb.WriteString(fmt.Sprintf("## %s (%s)\n", st.Source, st.Name))
+		sb.WriteString(fmt.Sprintf("**State:** %s\n", st.State))
+		if st.Attempts > 0 {
+			sb.WriteString(fmt.Sprintf("**Attempts:** %d\n", st.Attempts))
+		}
+		if !st.LoadedAt.IsZero() {
+			sb.WriteString(fmt.Sprintf("**Loaded:** %s\n", st.LoadedAt.Format(time.RFC3339)))
+		}
+		if st.LastError != "" {
+			sb.WriteString(fmt.Sprintf("**Last error:** %s\n", st.LastError))
+		}
+		if !st.RetryAt.IsZero() {
+			sb.WriteString(fmt.Sprintf("**Next attempt:** %s\n", st.RetryAt.Format(time.RFC3339)))
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
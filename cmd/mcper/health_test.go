@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPluginHealth_RetriesUntilLoaded(t *testing.T) {
+	failures := 3
+	var waits []time.Duration
+	p := &pluginHealth{
+		name:   "plugin-0",
+		source: "https://example.com/mcp",
+		kind:   "HTTP plugin",
+		load: func(ctx context.Context) error {
+			if failures > 0 {
+				failures--
+				return errors.New("connection refused")
+			}
+			return nil
+		},
+		wait: func(ctx context.Context, d time.Duration) bool {
+			waits = append(waits, d)
+			return true
+		},
+	}
+
+	if err := p.attempt(context.Background(), loadRetryMin); err == nil {
+		t.Fatal("first attempt succeeded, want failure")
+	}
+	if st := p.status(); st.State != healthRetrying || st.LastError != "connection refused" || st.RetryAt.IsZero() {
+		t.Errorf("status after failure = %+v", st)
+	}
+
+	p.retry(context.Background(), loadRetryMin)
+
+	want := []time.Duration{loadRetryMin, 2 * loadRetryMin, 4 * loadRetryMin}
+	if len(waits) != len(want) {
+		t.Fatalf("waits = %v, want %v", waits, want)
+	}
+	for i := range want {
+		if waits[i] != want[i] {
+			t.Errorf("wait %d = %v, want %v", i, waits[i], want[i])
+		}
+	}
+	st := p.status()
+	if st.State != healthLoaded || st.Attempts != 4 || st.LastError != "" || !st.RetryAt.IsZero() {
+		t.Errorf("status after recovery = %+v", st)
+	}
+}
+
+func TestPluginHealth_RetryStopsWithContext(t *testing.T) {
+	p := &pluginHealth{
+		load: func(ctx context.Context) error {
+			t.Fatal("load called after cancellation")
+			return nil
+		},
+		wait: func(ctx context.Context, d time.Duration) bool { return false },
+	}
+	p.retry(context.Background(), loadRetryMin)
+}
+
+func TestFormatPluginHealth(t *testing.T) {
+	out := formatPluginHealth([]pluginHealthStatus{
+		{Name: "plugin-0", Source: "https://example.com/mcp", State: healthRetrying, Attempts: 2, LastError: "connection refused", RetryAt: time.Now()},
+		{Name: "plugin-1", Source: "ghcr.io/x/y", State: healthFailed, LastError: "unsupported plugin type"},
+	})
+	for _, want := range []string{"https://example.com/mcp (plugin-0)", "**State:** retrying", "**Attempts:** 2", "connection refused", "**Next attempt:**", "**State:** failed"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q:\n%s", want, out)
+		}
+	}
+	if got := formatPluginHealth(nil); got != "No plugins configured." {
+		t.Errorf("empty output = %q", got)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// lazyPlugin starts a WASM plugin on its first tool call and stops it again
+// once no call has used it for idleTimeout. It wraps whatever launch
+// returns, a single supervised instance or a pool.
+type lazyPlugin struct {
+	ctx         context.Context // serve's context; the plugin outlives tool calls
+	pluginName  string
+	idleTimeout time.Duration
+	launch      func(ctx context.Context) (*mcp.ClientSession, connSource, func(), error)
+	// firstStart, if set, is called with the session of the plugin's first
+	// start, e.g. to forward what a manifest doesn't declare.
+	firstStart func(session *mcp.ClientSession)
+
+	mu       sync.Mutex
+	closed   bool
+	started  bool
+	source   connSource // nil while stopped
+	stop     func()
+	inflight int
+	lastUsed time.Time
+}
+
+// acquire starts the plugin if it is not running. Concurrent first calls
+// wait for the same start.
+func (l *lazyPlugin) acquire(ctx context.Context) (*pluginConn, error) {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil, fmt.Errorf("plugin %s has been removed", l.pluginName)
+	}
+	first := false
+	var started *mcp.ClientSession
+	if l.source == nil {
+		slog.Info("Starting plugin on first use", "plugin", l.pluginName)
+		session, source, stop, err := l.launch(l.ctx)
+		if err != nil {
+			l.mu.Unlock()
+			return nil, err
+		}
+		l.source, l.stop = source, stop
+		l.lastUsed = time.Now()
+		first, started = !l.started, session
+		l.started = true
+	}
+	source := l.source
+	l.inflight++
+	l.mu.Unlock()
+
+	if first && l.firstStart != nil {
+		l.firstStart(started)
+	}
+
+	conn, err := source.acquire(ctx)
+	if err != nil {
+		l.done()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (l *lazyPlugin) release(conn *pluginConn) {
+	// The plugin is only stopped while a call is in flight when it is
+	// closed, so source is nil or still the one conn came from.
+	l.mu.Lock()
+	source := l.source
+	l.mu.Unlock()
+	if source != nil {
+		source.release(conn)
+	}
+	l.done()
+}
+
+func (l *lazyPlugin) done() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inflight--
+	l.lastUsed = time.Now()
+}
+
+// close stops the plugin for good, e.g. once it is removed from the config.
+func (l *lazyPlugin) close() {
+	l.mu.Lock()
+	stop := l.stop
+	l.closed = true
+	l.source, l.stop = nil, nil
+	l.mu.Unlock()
+	if stop != nil {
+		stop()
+	}
+}
+
+// run stops the plugin whenever it goes idle, until l.ctx is cancelled.
+func (l *lazyPlugin) run() {
+	ticker := time.NewTicker(reapInterval(l.idleTimeout))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.ctx.Done():
+			return
+		case now := <-ticker.C:
+			l.reap(now)
+		}
+	}
+}
+
+// reap stops the plugin if it has been idle for idleTimeout.
+func (l *lazyPlugin) reap(now time.Time) {
+	l.mu.Lock()
+	if l.source == nil || l.inflight > 0 || now.Sub(l.lastUsed) < l.idleTimeout {
+		l.mu.Unlock()
+		return
+	}
+	stop := l.stop
+	l.source, l.stop = nil, nil
+	l.mu.Unlock()
+
+	stop()
+	slog.Info("Stopped idle plugin", "plugin", l.pluginName, "idle_timeout", l.idleTimeout)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+func TestLazyPlugin_StartsOnFirstUseAndStopsWhenIdle(t *testing.T) {
+	var launches, stops int
+	launchErr := errors.New("bad module")
+	fail := true
+	l := &lazyPlugin{
+		ctx:         context.Background(),
+		pluginName:  "hello",
+		idleTimeout: time.Minute,
+		launch: func(ctx context.Context) (*mcp.ClientSession, connSource, func(), error) {
+			if fail {
+				return nil, nil, nil, launchErr
+			}
+			launches++
+			return nil, &pluginConn{}, func() { stops++ }, nil
+		},
+	}
+	ctx := context.Background()
+
+	if _, err := l.acquire(ctx); !errors.Is(err, launchErr) {
+		t.Fatalf("acquire with failing launch = %v, want %v", err, launchErr)
+	}
+	fail = false
+
+	conn, err := l.acquire(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.acquire(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if launches != 1 {
+		t.Errorf("launches = %d, want 1", launches)
+	}
+
+	// Not stopped while calls are in flight, however long they take.
+	l.reap(time.Now().Add(time.Hour))
+	if stops != 0 {
+		t.Fatalf("plugin stopped with calls in flight")
+	}
+	l.release(conn)
+	l.release(conn)
+
+	l.reap(time.Now())
+	if stops != 0 {
+		t.Fatalf("plugin stopped before idle timeout")
+	}
+	l.reap(time.Now().Add(time.Minute))
+	if stops != 1 {
+		t.Fatalf("stops = %d after idle timeout, want 1", stops)
+	}
+
+	if _, err := l.acquire(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if launches != 2 {
+		t.Errorf("launches = %d after restart, want 2", launches)
+	}
+}
+
+func TestLazyPlugin_FirstStart(t *testing.T) {
+	var firstStarts int
+	l := &lazyPlugin{
+		ctx:         context.Background(),
+		pluginName:  "hello",
+		idleTimeout: time.Minute,
+		launch: func(ctx context.Context) (*mcp.ClientSession, connSource, func(), error) {
+			return nil, &pluginConn{}, func() {}, nil
+		},
+		firstStart: func(*mcp.ClientSession) { firstStarts++ },
+	}
+	ctx := context.Background()
+
+	// Started, stopped when idle and started again.
+	for range 2 {
+		conn, err := l.acquire(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		l.release(conn)
+		l.reap(time.Now().Add(time.Minute))
+	}
+	if firstStarts != 1 {
+		t.Errorf("firstStart called %d times, want 1", firstStarts)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// `mcper serve --listen` serves the aggregated server over Streamable HTTP
+// at /mcp. Every client gets an MCP session of its own on the one server;
+// the SDK's handler keeps the session table, keyed by Mcp-Session-Id, and
+// streams server messages over SSE. Requests must carry the listener's
+// bearer token: $MCPER_SERVE_TOKEN, or else one generated on first use and
+// kept in ~/.mcper/serve-token.
+const (
+	serveTokenEnv        = "MCPER_SERVE_TOKEN"
+	serveTokenFile       = "serve-token"
+	listenPath           = "/mcp"
+	listenReadHeader     = 10 * time.Second
+	listenShutdownWindow = 5 * time.Second
+)
+
+// serveHTTP serves `server` on addr until ctx is cancelled.
+func serveHTTP(ctx context.Context, server *mcp.Server, addr string) error {
+	token, err := listenToken()
+	if err != nil {
+		return err
+	}
+
+	httpServer := &http.Server{Handler: listenHandler(server, token), ReadHeaderTimeout: listenReadHeader}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	slog.Info("Serving MCP over Streamable HTTP", "url", "http://"+ln.Addr().String()+listenPath)
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), listenShutdownWindow)
+		defer cancel()
+		httpServer.Shutdown(shutdownCtx)
+	}()
+
+	if err := httpServer.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// listenHandler serves `server` at /mcp to clients that present `token`.
+func listenHandler(server *mcp.Server, token string) http.Handler {
+	handler := mcp.NewStreamableHTTPHandler(func(*http.Request) *mcp.Server { return server }, nil)
+	mux := http.NewServeMux()
+	mux.Handle(listenPath, requireBearer(token, handler))
+	return mux
+}
+
+// listenToken returns the bearer token clients must present.
+func listenToken() (string, error) {
+	if token := os.Getenv(serveTokenEnv); token != "" {
+		return token, nil
+	}
+
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(homeDir, ".mcper", serveTokenFile)
+	if data, err := os.ReadFile(path); err == nil {
+		if token := strings.TrimSpace(string(data)); token != "" {
+			slog.Info("Using the listener token", "path", path)
+			return token, nil
+		}
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(b)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(path, []byte(token+"\n"), 0600); err != nil {
+		return "", fmt.Errorf("failed to save listener token: %w", err)
+	}
+	slog.Info("Generated a listener token; clients must send it as a bearer token", "path", path)
+	return token, nil
+}
+
+// requireBearer rejects requests that don't carry `token` as a bearer token.
+func requireBearer(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="mcper"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+func TestRequireBearer(t *testing.T) {
+	handler := requireBearer("secret", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		name          string
+		authorization string
+		want          int
+	}{
+		{"no header", "", http.StatusUnauthorized},
+		{"wrong token", "Bearer nope", http.StatusUnauthorized},
+		{"wrong scheme", "Basic secret", http.StatusUnauthorized},
+		{"token prefix", "Bearer secre", http.StatusUnauthorized},
+		{"valid", "Bearer secret", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, listenPath, nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestListenHandler_ConcurrentSessions(t *testing.T) {
+	ctx := context.Background()
+
+	server := mcp.NewServer(&mcp.Implementation{Name: "mcper", Version: "test"}, nil)
+	mcp.AddTool(server, &mcp.Tool{Name: "echo"}, func(ctx context.Context, req *mcp.CallToolRequest, input map[string]any) (*mcp.CallToolResult, any, error) {
+		return textResult("echo"), nil, nil
+	})
+	httpServer := httptest.NewServer(listenHandler(server, "secret"))
+	defer httpServer.Close()
+
+	connectHTTP := func(token string) (*mcp.ClientSession, error) {
+		transport := &mcp.StreamableClientTransport{
+			Endpoint:   httpServer.URL + listenPath,
+			HTTPClient: &http.Client{Transport: &bearerAuthRoundTripper{base: http.DefaultTransport, token: token}},
+		}
+		return mcp.NewClient(&mcp.Implementation{Name: "client", Version: "test"}, nil).Connect(ctx, transport, nil)
+	}
+
+	if session, err := connectHTTP("wrong"); err == nil {
+		session.Close()
+		t.Fatal("connected with the wrong token")
+	}
+
+	var ids []string
+	for range 2 {
+		session, err := connectHTTP("secret")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer session.Close()
+		result, err := session.CallTool(ctx, &mcp.CallToolParams{Name: "echo"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if text := result.Content[0].(*mcp.TextContent).Text; !strings.Contains(text, "echo") {
+			t.Errorf("result = %q", text)
+		}
+		ids = append(ids, session.ID())
+	}
+	if ids[0] == "" || ids[0] == ids[1] {
+		t.Errorf("session IDs = %q, want two distinct IDs", ids)
+	}
+	sessions := 0
+	for range server.Sessions() {
+		sessions++
+	}
+	if sessions != 2 {
+		t.Errorf("server has %d sessions, want 2", sessions)
+	}
+}
@@ -3,7 +3,9 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
@@ -21,41 +23,68 @@ var loginCmd = &cobra.Command{
 	Short: "Log in to mcper-cloud",
 	Long: `Log in to mcper-cloud to enable OAuth token management.
 
-This command prompts you to enter a code generated from the mcper-cloud website.
+This command shows a short code and a URL. Open the URL in a browser on
+any device, log in and enter the code; mcper picks up the login once you
+approve it, so this works over SSH too.
 
-Steps:
+With --paste-code (or against a mcper-cloud without device login), you
+instead generate a code on the website and paste it:
   1. Visit the mcper-cloud website and log in
   2. Go to Dashboard > CLI Login Code
   3. Generate a code and paste it when prompted
 
+Each profile (--profile, default "default") has its own login, so you can
+be logged in to staging and production mcper-cloud instances at once. A
+profile keeps the server it last logged in to unless --server is given.
+
 Example:
   mcper login
+  mcper login --paste-code
   mcper login --code ABCD-1234
-  mcper login --server https://api.mcper.com`,
+  mcper login --server https://api.mcper.com
+  mcper login --profile staging --server https://staging.mcper.com`,
 	RunE: runLogin,
 }
 
 var (
-	loginServer string
-	loginCode   string
+	loginServer    string
+	loginCode      string
+	loginPasteCode bool
 )
 
 func init() {
 	loginCmd.Flags().StringVar(&loginServer, "server", mcper.DefaultCloudURL, "mcper-cloud server URL")
 	loginCmd.Flags().StringVar(&loginCode, "code", "", "Login code from website (skip interactive prompt)")
+	loginCmd.Flags().BoolVar(&loginPasteCode, "paste-code", false, "Paste a code generated on the website instead of approving a device login")
 }
 
 func runLogin(cmd *cobra.Command, args []string) error {
+	profile, err := selectedProfile(projectProfile())
+	if err != nil {
+		return err
+	}
+
 	// Check if already logged in
-	if creds, err := mcper.LoadCredentials(); err == nil && creds.IsValid() {
-		fmt.Printf("Already logged in as %s\n", creds.UserEmail)
+	creds, err := mcper.LoadProfileCredentials(profile)
+	if err == nil && creds.IsValid() {
+		fmt.Printf("Already logged in as %s (profile %s)\n", creds.UserEmail, profile)
 		fmt.Println("Use 'mcper logout' to log out first.")
 		return nil
 	}
+	useProfileServer(cmd, creds, err)
 
 	// If code provided via flag, use it directly
 	if loginCode != "" {
-		return claimCode(loginCode)
+		return claimCode(profile, loginCode)
+	}
+
+	if !loginPasteCode {
+		err := deviceLogin(context.Background(), profile)
+		if !errors.Is(err, mcper.ErrDeviceFlowUnsupported) {
+			return err
+		}
+		fmt.Println("This mcper-cloud doesn't support device login; log in with a code instead.")
+		fmt.Println()
 	}
 
 	// Interactive flow
@@ -89,11 +118,57 @@ func runLogin(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("code cannot be empty")
 	}
 
-	return claimCode(code)
+	return claimCode(profile, code)
+}
+
+// useProfileServer logs in to the server a profile was last logged in to,
+// unless --server was given.
+func useProfileServer(cmd *cobra.Command, creds *mcper.Credentials, err error) {
+	if err == nil && creds.CloudURL != "" && !cmd.Flags().Changed("server") {
+		loginServer = creds.CloudURL
+	}
+}
+
+// deviceLogin logs in to `profile` with the device authorization flow: the
+// user approves the login in a browser on any device while mcper polls for
+// the API key.
+func deviceLogin(ctx context.Context, profile string) error {
+	login := mcper.NewDeviceLogin(loginServer, cliClientInfo())
+	auth, err := login.Start(ctx)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("To log in to mcper-cloud, visit:")
+	fmt.Println()
+	fmt.Printf("  %s\n", auth.VerificationURI)
+	fmt.Println()
+	fmt.Printf("and enter the code: %s\n", auth.UserCode)
+	fmt.Println()
+	browserURL := auth.VerificationURIComplete
+	if browserURL == "" {
+		browserURL = auth.VerificationURI
+	}
+	openBrowser(browserURL)
+	fmt.Println("Waiting for you to approve the login...")
+
+	creds, err := login.Wait(ctx, auth)
+	if err != nil {
+		return fmt.Errorf("failed to log in: %w", err)
+	}
+	creds.Profile = profile
+	return saveLogin(creds)
+}
+
+// cliClientInfo describes this CLI to mcper-cloud, which shows it next to
+// the API key it issues.
+func cliClientInfo() string {
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("mcper-cli/%s on %s (%s)", mcper.Version, hostname, runtime.GOOS)
 }
 
-// claimCode exchanges a login code for an API key
-func claimCode(code string) error {
+// claimCode exchanges a login code for an API key for `profile`
+func claimCode(profile, code string) error {
 	fmt.Println("Claiming login code...")
 
 	// Normalize code (uppercase, handle with/without dash)
@@ -102,17 +177,13 @@ func claimCode(code string) error {
 		code = code[:4] + "-" + code[4:]
 	}
 
-	// Get hostname for client info
-	hostname, _ := os.Hostname()
-	clientInfo := fmt.Sprintf("mcper-cli/%s on %s (%s)", mcper.Version, hostname, runtime.GOOS)
-
 	// Prepare request body
 	reqBody := struct {
 		Code       string `json:"code"`
 		ClientInfo string `json:"client_info"`
 	}{
 		Code:       code,
-		ClientInfo: clientInfo,
+		ClientInfo: cliClientInfo(),
 	}
 
 	bodyBytes, err := json.Marshal(reqBody)
@@ -167,15 +238,22 @@ func claimCode(code string) error {
 		UserID:    result.UserID,
 		CloudURL:  loginServer,
 		ExpiresAt: expiresAt,
+		Profile:   profile,
 	}
+	return saveLogin(creds)
+}
 
+// saveLogin saves the credentials a login produced.
+func saveLogin(creds *mcper.Credentials) error {
 	if err := mcper.SaveCredentials(creds); err != nil {
 		return fmt.Errorf("failed to save credentials: %w", err)
 	}
 
 	fmt.Println()
-	fmt.Printf("Logged in successfully as %s\n", creds.UserEmail)
-	fmt.Println("Your API key has been saved to ~/.mcper/credentials.json")
+	fmt.Printf("Logged in successfully as %s (profile %s)\n", creds.UserEmail, creds.Profile)
+	if store, err := mcper.CredentialStoreForProfile(creds.Profile); err == nil {
+		fmt.Printf("Your API key has been saved to %s\n", store)
+	}
 	return nil
 }
 
@@ -231,6 +309,13 @@ func init() {
 }
 
 func runLoginAPIKey(cmd *cobra.Command, args []string) error {
+	profile, err := selectedProfile(projectProfile())
+	if err != nil {
+		return err
+	}
+	existing, err := mcper.LoadProfileCredentials(profile)
+	useProfileServer(cmd, existing, err)
+
 	// Validate API key with server
 	fmt.Println("Validating API key...")
 
@@ -266,12 +351,13 @@ func runLoginAPIKey(cmd *cobra.Command, args []string) error {
 		UserEmail: userInfo.Email,
 		UserID:    userInfo.UserID,
 		CloudURL:  loginServer,
+		Profile:   profile,
 	}
 
 	if err := mcper.SaveCredentials(creds); err != nil {
 		return fmt.Errorf("failed to save credentials: %w", err)
 	}
 
-	fmt.Printf("Logged in successfully as %s\n", creds.UserEmail)
+	fmt.Printf("Logged in successfully as %s (profile %s)\n", creds.UserEmail, profile)
 	return nil
 }
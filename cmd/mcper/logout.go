@@ -12,23 +12,31 @@ var logoutCmd = &cobra.Command{
 	Short: "Log out of mcper-cloud",
 	Long: `Log out of mcper-cloud and remove stored credentials.
 
-This removes your API key from ~/.mcper/credentials.json
+This removes your API key from the credential store (see mcper status),
+and any copy left in plaintext in ~/.mcper/credentials.json. Only the
+selected profile is logged out.
 
 Example:
-  mcper logout`,
+  mcper logout
+  mcper logout --profile staging`,
 	RunE: runLogout,
 }
 
 func runLogout(cmd *cobra.Command, args []string) error {
+	profile, err := selectedProfile(projectProfile())
+	if err != nil {
+		return err
+	}
+
 	// Check if logged in
-	creds, err := mcper.LoadCredentials()
+	creds, err := mcper.LoadProfileCredentials(profile)
 	if err != nil {
-		fmt.Println("Not logged in.")
+		fmt.Printf("Not logged in (profile %s).\n", profile)
 		return nil
 	}
 
 	// Delete credentials
-	if err := mcper.DeleteCredentials(); err != nil {
+	if err := mcper.DeleteCredentials(profile); err != nil {
 		return fmt.Errorf("failed to delete credentials: %w", err)
 	}
 
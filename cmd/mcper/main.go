@@ -29,13 +29,19 @@ Usage:
   mcper add <plugin>            Add a plugin to the project
   mcper plugin list             List plugins in current project
   mcper plugin update           Update plugins to latest versions
+  mcper tools                   List the tool names mcper serve exposes
   mcper registry list           List available plugins in registry
+  mcper audit                   Show recent tool calls from the audit log
+  mcper secret set <name>       Store a plugin secret in the keyring
+  mcper profile pin <profile>   Use an mcper-cloud profile for the project
   mcper serve --config-json ... Run MCP server with the given config
   mcper update                  Update mcper to latest version
   mcper version                 Show version information`,
 }
 
 func init() {
+	rootCmd.PersistentFlags().StringVar(&cloudProfile, "profile", "", "mcper-cloud profile (default $MCPER_PROFILE, the project's pinned profile, or \"default\")")
+
 	rootCmd.AddCommand(versionCmd)
 	rootCmd.AddCommand(initCmd)
 	rootCmd.AddCommand(addCmd)
@@ -45,8 +51,12 @@ func init() {
 	rootCmd.AddCommand(enableCmd)
 	rootCmd.AddCommand(updateCmd)
 	rootCmd.AddCommand(listCmd)
+	rootCmd.AddCommand(toolsCmd)
 	rootCmd.AddCommand(pluginCmd)
 	rootCmd.AddCommand(registryCmd)
+	rootCmd.AddCommand(auditCmd)
+	rootCmd.AddCommand(secretCmd)
+	rootCmd.AddCommand(profileCmd)
 
 	// API proxy generation
 	rootCmd.AddCommand(addAPICmd)
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/joshcarp/mcper/pkg/mcper"
+)
+
+// `mcper serve --metrics-listen` exports metrics in the Prometheus text
+// format at /metrics. The endpoint is unauthenticated: it reveals plugin
+// and tool names but no arguments, and is meant to be bound to loopback.
+const (
+	metricsPath        = "/metrics"
+	metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+// serveMetrics holds the metrics mcper serve records. A nil serveMetrics
+// records nothing.
+type serveMetrics struct {
+	registry     *mcper.Metrics
+	calls        *mcper.CounterVec
+	errors       *mcper.CounterVec
+	latency      *mcper.HistogramVec
+	capMint      *mcper.HistogramVec
+	approvalWait *mcper.HistogramVec
+}
+
+// newServeMetrics registers serve's metrics. WASM restarts are counted by
+// `supervisors`; instance memory is read from them when scraped.
+func newServeMetrics(supervisors *supervisorSet) *serveMetrics {
+	registry := mcper.NewMetrics()
+	m := &serveMetrics{
+		registry:     registry,
+		calls:        registry.Counter("mcper_tool_calls_total", "Forwarded tool calls.", "plugin", "tool"),
+		errors:       registry.Counter("mcper_tool_call_errors_total", "Forwarded tool calls that returned an error.", "plugin", "tool"),
+		latency:      registry.Histogram("mcper_tool_call_duration_seconds", "Time to complete a forwarded tool call, approval and cap mint included.", mcper.DefaultBuckets, "plugin", "tool"),
+		capMint:      registry.Histogram("mcper_cap_mint_duration_seconds", "Time to mint a cap from mcper-cloud.", mcper.DefaultBuckets, "plugin"),
+		approvalWait: registry.Histogram("mcper_cap_approval_wait_seconds", "Time spent waiting for a cap to be approved in mcper-cloud.", mcper.DefaultBuckets, "plugin"),
+	}
+	supervisors.restarts = registry.Counter("mcper_wasm_restarts_total", "WASM plugin instances restarted by their supervisor.", "plugin")
+	registry.Collect("mcper_wasm_memory_bytes", "Linear memory of running WASM plugin instances.", mcper.MetricGauge, []string{"plugin", "module"}, func(emit func(float64, ...string)) {
+		for _, s := range supervisors.all() {
+			if _, inst, _ := s.conn.current(); inst != nil {
+				emit(float64(inst.MemoryBytes()), s.pluginName, s.name)
+			}
+		}
+	})
+	return m
+}
+
+// observeCall records a finished tool call.
+func (m *serveMetrics) observeCall(plugin, tool string, d time.Duration, failed bool) {
+	if m == nil {
+		return
+	}
+	m.calls.Inc(plugin, tool)
+	if failed {
+		m.errors.Inc(plugin, tool)
+	}
+	m.latency.Observe(d.Seconds(), plugin, tool)
+}
+
+func (m *serveMetrics) observeCapMint(plugin string, d time.Duration) {
+	if m != nil {
+		m.capMint.Observe(d.Seconds(), plugin)
+	}
+}
+
+func (m *serveMetrics) observeApprovalWait(plugin string, d time.Duration) {
+	if m != nil {
+		m.approvalWait.Observe(d.Seconds(), plugin)
+	}
+}
+
+// ServeHTTP writes the metrics in the text exposition format.
+func (m *serveMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", metricsContentType)
+	if err := m.registry.WriteText(w); err != nil {
+		slog.Warn("Failed to write metrics", "err", err)
+	}
+}
+
+// serveMetricsHTTP serves `m` at /metrics on addr until ctx is cancelled.
+func serveMetricsHTTP(ctx context.Context, m *serveMetrics, addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle(metricsPath, m)
+	httpServer := &http.Server{Handler: mux, ReadHeaderTimeout: listenReadHeader}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	slog.Info("Serving metrics", "url", "http://"+ln.Addr().String()+metricsPath)
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), listenShutdownWindow)
+		defer cancel()
+		httpServer.Shutdown(shutdownCtx)
+	}()
+
+	if err := httpServer.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
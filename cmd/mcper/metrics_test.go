@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/jsonschema-go/jsonschema"
+	"github.com/joshcarp/mcper/pkg/mcper"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+func TestServeMetrics(t *testing.T) {
+	ctx := context.Background()
+
+	plugin := mcp.NewServer(&mcp.Implementation{Name: "github", Version: "test"}, nil)
+	mcp.AddTool(plugin, &mcp.Tool{Name: "create_issue", InputSchema: &jsonschema.Schema{Type: "object"}}, func(ctx context.Context, req *mcp.CallToolRequest, input map[string]any) (*mcp.CallToolResult, any, error) {
+		if input["title"] == "" {
+			return errorResult("title is required"), nil, nil
+		}
+		return textResult("created"), nil, nil
+	})
+	metrics := newServeMetrics(&supervisorSet{})
+	server := mcp.NewServer(&mcp.Implementation{Name: "mcper", Version: "test"}, nil)
+	scope := &pluginScope{server: server, source: "github.wasm", progress: &progressRelay{}, metrics: metrics}
+	registerForwardedTool(scope, &pluginConn{session: connect(t, plugin)}, namespaceWASM, "github", "Tool call failed", &mcp.Tool{Name: "create_issue"}, mcper.ApprovalAllow, nil)
+
+	session := connect(t, server)
+	for _, title := range []string{"Flaky test", "Slow build", ""} {
+		if _, err := session.CallTool(ctx, &mcp.CallToolParams{Name: "wasm_github_create_issue", Arguments: map[string]any{"title": title}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	metrics.ServeHTTP(rec, httptest.NewRequest("GET", metricsPath, nil))
+	if got := rec.Header().Get("Content-Type"); got != metricsContentType {
+		t.Errorf("Content-Type = %q", got)
+	}
+	body, _ := io.ReadAll(rec.Body)
+	for _, want := range []string{
+		`mcper_tool_calls_total{plugin="github",tool="wasm_github_create_issue"} 3`,
+		`mcper_tool_call_errors_total{plugin="github",tool="wasm_github_create_issue"} 1`,
+		`mcper_tool_call_duration_seconds_count{plugin="github",tool="wasm_github_create_issue"} 3`,
+		`# TYPE mcper_wasm_restarts_total counter`,
+		`# TYPE mcper_wasm_memory_bytes gauge`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("metrics missing %q:\n%s", want, body)
+		}
+	}
+}
@@ -72,7 +72,7 @@ func runPluginList(cmd *cobra.Command, args []string) error {
 
 	// Check for cloud servers if logged in
 	var remoteServers []mcper.RemoteServer
-	creds, credErr := mcper.LoadCredentials()
+	creds, credErr := loadProfileCredentials(config.Profile)
 	if credErr == nil && creds.IsValid() {
 		remoteServers, err = mcper.FetchRemoteServers(creds)
 		if err != nil {
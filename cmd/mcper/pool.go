@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/joshcarp/mcper/pkg/wasmhost"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// Idle handling. Pool instances above the minimum, and lazily started
+// plugins, are stopped after defaultIdleTimeout without calls unless the
+// plugin sets idle_timeout. Idleness is checked at most every
+// maxReapInterval.
+const (
+	defaultIdleTimeout = 5 * time.Minute
+	maxReapInterval    = 30 * time.Second
+)
+
+// reapInterval is how often to look for instances idle for idleTimeout.
+func reapInterval(idleTimeout time.Duration) time.Duration {
+	return max(min(idleTimeout/2, maxReapInterval), time.Second)
+}
+
+// connSource hands a forwarded tool call the pluginConn to use, and takes
+// it back once the call is done.
+type connSource interface {
+	acquire(ctx context.Context) (*pluginConn, error)
+	release(conn *pluginConn)
+}
+
+// acquire returns the connection itself: HTTP and cloud sessions, and
+// unpooled WASM plugins, take concurrent calls on a single session.
+func (c *pluginConn) acquire(ctx context.Context) (*pluginConn, error) {
+	return c, nil
+}
+
+func (c *pluginConn) release(*pluginConn) {}
+
+// pluginPool runs between min and max instances of one WASM plugin and
+// lends each tool call an idle one. Every instance has its own MCP client
+// session and its own supervisor. Pooling lives here rather than in
+// WasmHost because the session, not the instance, is what calls go through.
+type pluginPool struct {
+	ctx         context.Context // serve's context; members outlive tool calls
+	name        string
+	pluginName  string
+	min, max    int
+	idleTimeout time.Duration
+	callTimeout time.Duration
+	start       func(ctx context.Context) (*mcp.ClientSession, *wasmhost.Instance, error)
+	supervisors *supervisorSet
+
+	mu       sync.Mutex
+	members  []*poolMember
+	starting int
+	seq      int
+	released chan struct{} // closed and replaced whenever a member frees up
+}
+
+type poolMember struct {
+	conn       *pluginConn
+	supervisor *supervisor
+	cancel     context.CancelFunc
+	busy       bool
+	lastUsed   time.Time
+}
+
+func newPluginPool(ctx context.Context, name, pluginName string, min, max int, idleTimeout, callTimeout time.Duration, start func(ctx context.Context) (*mcp.ClientSession, *wasmhost.Instance, error), supervisors *supervisorSet) *pluginPool {
+	return &pluginPool{
+		ctx:         ctx,
+		name:        name,
+		pluginName:  pluginName,
+		min:         min,
+		max:         max,
+		idleTimeout: idleTimeout,
+		callTimeout: callTimeout,
+		start:       start,
+		supervisors: supervisors,
+		released:    make(chan struct{}),
+	}
+}
+
+// adopt adds an already started connection to the pool. The caller must
+// not hold p.mu.
+func (p *pluginPool) adopt(conn *pluginConn) *poolMember {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.addLocked(conn)
+}
+
+func (p *pluginPool) addLocked(conn *pluginConn) *poolMember {
+	ctx, cancel := context.WithCancel(p.ctx)
+	p.seq++
+	m := &poolMember{
+		conn:       conn,
+		supervisor: &supervisor{name: fmt.Sprintf("%s#%d", p.name, p.seq), pluginName: p.pluginName, conn: conn, start: p.start},
+		cancel:     cancel,
+		lastUsed:   time.Now(),
+	}
+	p.members = append(p.members, m)
+	p.supervisors.add(ctx, m.supervisor)
+	return m
+}
+
+// grow starts one more instance, lent out straight away when busy is set.
+// It is called with p.starting already incremented so concurrent callers
+// don't overshoot max.
+func (p *pluginPool) grow(busy bool) (*poolMember, error) {
+	session, inst, err := p.start(p.ctx)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.starting--
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("Started pool instance", "plugin", p.pluginName, "instance", len(p.members)+1, "max", p.max)
+	m := p.addLocked(&pluginConn{session: session, instance: inst, callTimeout: p.callTimeout})
+	m.busy = busy
+	return m, nil
+}
+
+// acquire lends out an idle instance, starting a new one if every instance
+// is busy and the pool is below max, and otherwise waits for a release.
+func (p *pluginPool) acquire(ctx context.Context) (*pluginConn, error) {
+	for {
+		p.mu.Lock()
+		var fallback *poolMember
+		for _, m := range p.members {
+			if m.busy {
+				continue
+			}
+			if _, _, down := m.conn.current(); down != nil {
+				fallback = m
+				continue
+			}
+			m.busy = true
+			p.mu.Unlock()
+			return m.conn, nil
+		}
+		if len(p.members)+p.starting < p.max {
+			p.starting++
+			p.mu.Unlock()
+			m, err := p.grow(true)
+			if err != nil {
+				return nil, fmt.Errorf("failed to start another instance: %w", err)
+			}
+			return m.conn, nil
+		}
+		if fallback != nil {
+			// Every idle instance is restarting; let the call fail fast
+			// with the reason instead of waiting out the backoff.
+			fallback.busy = true
+			p.mu.Unlock()
+			return fallback.conn, nil
+		}
+		released := p.released
+		p.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-released:
+		}
+	}
+}
+
+func (p *pluginPool) release(conn *pluginConn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, m := range p.members {
+		if m.conn == conn {
+			m.busy = false
+			m.lastUsed = time.Now()
+		}
+	}
+	close(p.released)
+	p.released = make(chan struct{})
+}
+
+// run keeps the pool at its minimum and stops instances that have been
+// idle too long, until the pool's context is cancelled.
+func (p *pluginPool) run() {
+	ticker := time.NewTicker(reapInterval(p.idleTimeout))
+	defer ticker.Stop()
+	for {
+		p.fill()
+		p.reap(time.Now())
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *pluginPool) fill() {
+	for {
+		p.mu.Lock()
+		if len(p.members)+p.starting >= p.min {
+			p.mu.Unlock()
+			return
+		}
+		p.starting++
+		p.mu.Unlock()
+		if _, err := p.grow(false); err != nil {
+			slog.Error("Failed to start pool instance", "plugin", p.pluginName, "err", err)
+			return
+		}
+	}
+}
+
+// reap stops instances above the pool minimum that have been idle for
+// p.idleTimeout.
+func (p *pluginPool) reap(now time.Time) {
+	p.mu.Lock()
+	var stopped []*poolMember
+	kept := p.members[:0]
+	for _, m := range p.members {
+		surplus := len(p.members) - len(stopped) - p.min
+		if surplus > 0 && !m.busy && now.Sub(m.lastUsed) >= p.idleTimeout {
+			stopped = append(stopped, m)
+			continue
+		}
+		kept = append(kept, m)
+	}
+	p.members = kept
+	p.mu.Unlock()
+
+	p.stopMembers(stopped)
+}
+
+// close stops every instance. Cancel the pool's context first so run
+// doesn't start new ones.
+func (p *pluginPool) close() {
+	p.mu.Lock()
+	members := p.members
+	p.members = nil
+	p.mu.Unlock()
+
+	p.stopMembers(members)
+}
+
+func (p *pluginPool) stopMembers(members []*poolMember) {
+	for _, m := range members {
+		m.cancel()
+		p.supervisors.remove(m.supervisor)
+		m.conn.close()
+		slog.Info("Stopped pool instance", "plugin", p.pluginName, "module", m.supervisor.name)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/joshcarp/mcper/pkg/wasmhost"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// testPool returns a pool whose members are already in place, so no
+// instances or supervisors are started.
+func testPool(min, max int, conns ...*pluginConn) *pluginPool {
+	start := func(ctx context.Context) (*mcp.ClientSession, *wasmhost.Instance, error) {
+		return nil, nil, errors.New("no instances in tests")
+	}
+	p := newPluginPool(context.Background(), "plugin-0", "hello", min, max, defaultIdleTimeout, 0, start, &supervisorSet{})
+	for _, c := range conns {
+		p.members = append(p.members, &poolMember{conn: c, supervisor: &supervisor{}, cancel: func() {}, lastUsed: time.Now()})
+	}
+	return p
+}
+
+func TestPluginPool_AcquireRelease(t *testing.T) {
+	a, b := &pluginConn{}, &pluginConn{}
+	p := testPool(1, 2, a, b)
+	ctx := context.Background()
+
+	first, err := p.acquire(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := p.acquire(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == second {
+		t.Fatalf("both calls got the same instance")
+	}
+
+	got := make(chan *pluginConn)
+	go func() {
+		c, _ := p.acquire(ctx)
+		got <- c
+	}()
+	select {
+	case <-got:
+		t.Fatal("acquire returned while every instance was busy")
+	case <-time.After(20 * time.Millisecond):
+	}
+	p.release(first)
+	select {
+	case c := <-got:
+		if c != first {
+			t.Errorf("waiter got %p, want released %p", c, first)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("waiter not woken by release")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if _, err := p.acquire(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("acquire on exhausted pool = %v, want deadline exceeded", err)
+	}
+}
+
+func TestPluginPool_SkipsRestartingInstances(t *testing.T) {
+	down, up := &pluginConn{down: errors.New("restarting")}, &pluginConn{}
+	p := testPool(1, 2, down, up)
+
+	if c, _ := p.acquire(context.Background()); c != up {
+		t.Errorf("acquire = %p, want the running instance", c)
+	}
+	// With the running instance busy and no room to grow, the call fails
+	// fast on the restarting one rather than waiting.
+	if c, _ := p.acquire(context.Background()); c != down {
+		t.Errorf("acquire = %p, want the restarting instance", c)
+	}
+}
+
+func TestPluginPool_GrowFailure(t *testing.T) {
+	p := testPool(0, 1)
+	if _, err := p.acquire(context.Background()); err == nil {
+		t.Errorf("acquire succeeded although starting an instance failed")
+	}
+	if p.starting != 0 {
+		t.Errorf("starting = %d after failed grow, want 0", p.starting)
+	}
+}
+
+func TestPluginPool_Reap(t *testing.T) {
+	a, b, c := &pluginConn{}, &pluginConn{}, &pluginConn{}
+	p := testPool(1, 3, a, b, c)
+	p.members[1].busy = true
+
+	p.reap(time.Now())
+	if len(p.members) != 3 {
+		t.Errorf("recently used instances were reaped")
+	}
+
+	// The busy instance counts towards the minimum, so both idle ones go.
+	p.reap(time.Now().Add(defaultIdleTimeout))
+	if len(p.members) != 1 || p.members[0].conn != b {
+		t.Errorf("members after reap = %d, want only the busy instance", len(p.members))
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/joshcarp/mcper/pkg/mcper"
+	"github.com/spf13/cobra"
+)
+
+// cloudProfile is the --profile flag every command takes.
+var cloudProfile string
+
+var profileCmd = &cobra.Command{
+	Use:   "profile",
+	Short: "Pin the project to an mcper-cloud profile",
+	Long: `Pin the project to an mcper-cloud profile.
+
+Each profile has its own login, e.g. for staging and production mcper-cloud
+instances or a personal account:
+
+  mcper login --profile staging --server https://staging.example.com
+
+Commands use the profile given by --profile, else $MCPER_PROFILE, else the
+profile pinned in .mcper/start.sh, else "default".
+
+Example:
+  mcper profile pin staging
+  mcper profile unpin`,
+}
+
+var profilePinCmd = &cobra.Command{
+	Use:   "pin <profile>",
+	Short: "Use a profile for this project",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := mcper.ValidateProfile(args[0]); err != nil {
+			return err
+		}
+		return setProjectProfile(args[0])
+	},
+}
+
+var profileUnpinCmd = &cobra.Command{
+	Use:   "unpin",
+	Short: "Stop pinning a profile for this project",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return setProjectProfile("")
+	},
+}
+
+func init() {
+	profileCmd.AddCommand(profilePinCmd)
+	profileCmd.AddCommand(profileUnpinCmd)
+}
+
+func setProjectProfile(profile string) error {
+	startPath := filepath.Join(".mcper", mcper.StartScriptName)
+	config, err := mcper.ParseStartScript(startPath)
+	if err != nil {
+		return fmt.Errorf("no project here (run mcper init first): %w", err)
+	}
+	config.Profile = profile
+	if err := mcper.UpdateStartScript(startPath, config); err != nil {
+		return err
+	}
+	if profile == "" {
+		fmt.Println("Unpinned the project's profile")
+	} else {
+		fmt.Printf("Pinned the project to profile %s\n", profile)
+	}
+	return nil
+}
+
+// projectProfile returns the profile pinned in .mcper/start.sh, if the
+// current directory has one.
+func projectProfile() string {
+	startPath := filepath.Join(".mcper", mcper.StartScriptName)
+	if _, err := os.Stat(startPath); err != nil {
+		return ""
+	}
+	config, err := mcper.ParseStartScript(startPath)
+	if err != nil {
+		return ""
+	}
+	return config.Profile
+}
+
+// selectedProfile returns the profile a command uses, given the profile
+// pinned by its project's config.
+func selectedProfile(pinned string) (string, error) {
+	return mcper.SelectProfile(cloudProfile, pinned)
+}
+
+// loadProfileCredentials loads the credentials of the selected profile.
+func loadProfileCredentials(pinned string) (*mcper.Credentials, error) {
+	profile, err := selectedProfile(pinned)
+	if err != nil {
+		return nil, err
+	}
+	return mcper.LoadProfileCredentials(profile)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/joshcarp/mcper/pkg/mcper"
+)
+
+func TestProjectProfilePin(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	t.Setenv(mcper.ProfileEnv, "")
+	if err := os.Mkdir(".mcper", 0755); err != nil {
+		t.Fatal(err)
+	}
+	startPath := filepath.Join(".mcper", mcper.StartScriptName)
+	if err := mcper.WriteStartScript(startPath, &mcper.Config{Plugins: []mcper.PluginConfig{{Source: "github"}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := projectProfile(); got != "" {
+		t.Errorf("projectProfile before pin = %q", got)
+	}
+	if err := setProjectProfile("staging"); err != nil {
+		t.Fatal(err)
+	}
+	if got := projectProfile(); got != "staging" {
+		t.Errorf("projectProfile after pin = %q, want staging", got)
+	}
+	config, err := mcper.ParseStartScript(startPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Plugins) != 1 {
+		t.Errorf("pinning changed the plugins: %+v", config.Plugins)
+	}
+
+	if got, err := selectedProfile(projectProfile()); err != nil || got != "staging" {
+		t.Errorf("selectedProfile = %q, %v, want staging", got, err)
+	}
+	cloudProfile = "prod"
+	t.Cleanup(func() { cloudProfile = "" })
+	if got, err := selectedProfile(projectProfile()); err != nil || got != "prod" {
+		t.Errorf("selectedProfile with --profile = %q, %v, want prod", got, err)
+	}
+
+	if err := setProjectProfile(""); err != nil {
+		t.Fatal(err)
+	}
+	if got := projectProfile(); got != "" {
+		t.Errorf("projectProfile after unpin = %q", got)
+	}
+}
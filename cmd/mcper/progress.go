@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// progressRelay passes plugins' notifications/progress back to the client
+// whose call they belong to. Every forwarded call that asked for progress
+// is given a token of its own for the plugin, since separate clients, or
+// calls, may well pick the same token.
+type progressRelay struct {
+	mu    sync.Mutex
+	calls map[string]progressTarget
+}
+
+// progressLinger is how long a finished call's token stays mapped. The
+// client session handles notifications separately from responses, so
+// progress a plugin sent just before its result can arrive just after it.
+const progressLinger = time.Second
+
+// progressTarget is where progress for one forwarded call goes.
+type progressTarget struct {
+	session *mcp.ServerSession
+	token   any
+}
+
+// track registers a call's progress token and returns the token to send to
+// the plugin instead, along with a func to forget it once the call is done.
+func (r *progressRelay) track(session *mcp.ServerSession, token any) (string, func()) {
+	downstream := "mcper-" + uuid.NewString()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.calls == nil {
+		r.calls = make(map[string]progressTarget)
+	}
+	r.calls[downstream] = progressTarget{session: session, token: token}
+	return downstream, func() {
+		time.AfterFunc(progressLinger, func() {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			delete(r.calls, downstream)
+		})
+	}
+}
+
+// notify relays a plugin's progress notification. It is the
+// ProgressNotificationHandler of every plugin client session.
+func (r *progressRelay) notify(ctx context.Context, req *mcp.ProgressNotificationClientRequest) {
+	token, _ := req.Params.ProgressToken.(string)
+	r.mu.Lock()
+	target, ok := r.calls[token]
+	r.mu.Unlock()
+	if !ok {
+		return // the call already finished, or the plugin made the token up
+	}
+	err := target.session.NotifyProgress(ctx, &mcp.ProgressNotificationParams{
+		ProgressToken: target.token,
+		Message:       req.Params.Message,
+		Progress:      req.Params.Progress,
+		Total:         req.Params.Total,
+	})
+	if err != nil {
+		slog.Warn("Failed to relay progress", "err", err)
+	}
+}
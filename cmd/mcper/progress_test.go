@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/jsonschema-go/jsonschema"
+	"github.com/joshcarp/mcper/pkg/mcper"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+func TestForwardedTool_RelaysProgress(t *testing.T) {
+	ctx := context.Background()
+
+	// The plugin reports progress against whatever token it was given.
+	plugin := mcp.NewServer(&mcp.Implementation{Name: "devops", Version: "test"}, nil)
+	mcp.AddTool(plugin, &mcp.Tool{Name: "run_pipeline", InputSchema: &jsonschema.Schema{Type: "object"}}, func(ctx context.Context, req *mcp.CallToolRequest, input map[string]any) (*mcp.CallToolResult, any, error) {
+		token := req.Params.GetProgressToken()
+		if token == nil {
+			return errorResult("no progress token"), nil, nil
+		}
+		for i := 1; i <= 2; i++ {
+			req.Session.NotifyProgress(ctx, &mcp.ProgressNotificationParams{ProgressToken: token, Progress: float64(i), Total: 2, Message: "stage"})
+		}
+		return textResult("done"), nil, nil
+	})
+
+	server := mcp.NewServer(&mcp.Implementation{Name: "mcper", Version: "test"}, nil)
+	scope := &pluginScope{server: server, progress: &progressRelay{}}
+	serverTransport, clientTransport := mcp.NewInMemoryTransports()
+	if _, err := plugin.Connect(ctx, serverTransport, nil); err != nil {
+		t.Fatal(err)
+	}
+	pluginSession, err := scope.newClient(&mcp.Implementation{Name: "WASM-plugin-0", Version: "test"}).Connect(ctx, clientTransport, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pluginSession.Close()
+	registerForwardedTool(scope, &pluginConn{session: pluginSession}, namespaceWASM, "devops", "Tool call failed", &mcp.Tool{Name: "run_pipeline"}, mcper.ApprovalAllow, nil)
+
+	progress := make(chan *mcp.ProgressNotificationParams, 10)
+	serverTransport, clientTransport = mcp.NewInMemoryTransports()
+	if _, err := server.Connect(ctx, serverTransport, nil); err != nil {
+		t.Fatal(err)
+	}
+	client, err := mcp.NewClient(&mcp.Implementation{Name: "client", Version: "test"}, &mcp.ClientOptions{
+		ProgressNotificationHandler: func(_ context.Context, req *mcp.ProgressNotificationClientRequest) { progress <- req.Params },
+	}).Connect(ctx, clientTransport, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	params := &mcp.CallToolParams{Name: "wasm_devops_run_pipeline", Arguments: map[string]any{}}
+	params.SetProgressToken("client-token")
+	result, err := client.CallTool(ctx, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.IsError {
+		t.Fatalf("tool call failed: %+v", result.Content)
+	}
+
+	for i := 1; i <= 2; i++ {
+		select {
+		case p := <-progress:
+			if p.ProgressToken != "client-token" || p.Progress != float64(i) || p.Total != 2 {
+				t.Errorf("progress %d = %+v, want client-token %d/2", i, p, i)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("progress %d not relayed", i)
+		}
+	}
+}
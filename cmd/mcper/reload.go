@@ -0,0 +1,354 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/joshcarp/mcper/pkg/mcper"
+	"github.com/joshcarp/mcper/pkg/wasmhost"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// startScriptPollInterval is how often `mcper serve --watch` checks
+// .mcper/start.sh for changes.
+const startScriptPollInterval = 2 * time.Second
+
+// pluginScope is what one configured plugin has added to the server: its
+// tools, resources and prompts, and whatever has to be stopped when the
+// plugin is removed.
+type pluginScope struct {
+	server      *mcp.Server
+	source      string // the plugin's source, for errors
+	rules       *mcper.ToolRules
+	owners      *toolOwners
+	progress    *progressRelay
+	sampling    bool // relay the plugin's sampling requests
+	elicitation bool // relay the plugin's elicitation requests
+	callers     callers
+	approvals   approvals
+	audit       *mcper.AuditLog // nil if tool calls aren't audited
+	metrics     *serveMetrics   // nil unless serving metrics
+	tracer      *mcper.Tracer   // nil unless tracing
+
+	mu        sync.Mutex
+	closed    bool
+	tools     []string
+	resources []string // URIs
+	templates []string // URI templates
+	prompts   []string
+	closers   []func()
+}
+
+// addTool registers a tool on the server. Once the scope is closed it does
+// nothing, so a background retry that finishes after the plugin was removed
+// can't bring its tools back.
+func (s *pluginScope) addTool(tool *mcp.Tool, handler mcp.ToolHandlerFor[map[string]any, any]) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	mcp.AddTool(s.server, tool, handler)
+	s.tools = append(s.tools, tool.Name)
+}
+
+func (s *pluginScope) addResource(resource *mcp.Resource, handler mcp.ResourceHandler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.server.AddResource(resource, handler)
+	s.resources = append(s.resources, resource.URI)
+}
+
+func (s *pluginScope) addResourceTemplate(template *mcp.ResourceTemplate, handler mcp.ResourceHandler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.server.AddResourceTemplate(template, handler)
+	s.templates = append(s.templates, template.URITemplate)
+}
+
+func (s *pluginScope) addPrompt(prompt *mcp.Prompt, handler mcp.PromptHandler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.server.AddPrompt(prompt, handler)
+	s.prompts = append(s.prompts, prompt.Name)
+}
+
+// onClose arranges for f to run when the scope is closed, or runs it
+// straight away if it already is.
+func (s *pluginScope) onClose(f func()) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		f()
+		return
+	}
+	s.closers = append(s.closers, f)
+	s.mu.Unlock()
+}
+
+// close removes the plugin's tools, resources and prompts, which notifies
+// clients that the lists changed, and runs the closers in the order they
+// were added.
+func (s *pluginScope) close() {
+	s.mu.Lock()
+	s.closed = true
+	tools, resources, templates, prompts, closers := s.tools, s.resources, s.templates, s.prompts, s.closers
+	s.tools, s.resources, s.templates, s.prompts, s.closers = nil, nil, nil, nil, nil
+	s.mu.Unlock()
+	if s.owners != nil {
+		s.owners.release(s)
+	}
+
+	if len(tools) > 0 {
+		s.server.RemoveTools(tools...)
+	}
+	if len(resources) > 0 {
+		s.server.RemoveResources(resources...)
+	}
+	if len(templates) > 0 {
+		s.server.RemoveResourceTemplates(templates...)
+	}
+	if len(prompts) > 0 {
+		s.server.RemovePrompts(prompts...)
+	}
+	for _, f := range closers {
+		f()
+	}
+}
+
+// pluginSet starts and stops the plugins in the serve config. With --watch,
+// every change to .mcper/start.sh is applied to it.
+type pluginSet struct {
+	ctx         context.Context
+	server      *mcp.Server
+	host        *wasmhost.WasmHost
+	supervisors *supervisorSet
+	health      *healthSet
+	progress    *progressRelay
+	audit       *mcper.AuditLog
+	metrics     *serveMetrics
+	tracer      *mcper.Tracer
+	owners      toolOwners
+	profile     string              // mcper-cloud profile keys belong to
+	keys        *mcper.KeyRefresher // nil unless logged in
+	proxyURL    string
+
+	mu      sync.Mutex
+	seq     int // module names stay unique across reloads
+	plugins []*runningPlugin
+}
+
+type runningPlugin struct {
+	name   string // e.g. "plugin-0"
+	config mcper.PluginConfig
+	scope  *pluginScope
+	health *pluginHealth
+	cancel context.CancelFunc
+}
+
+// apply brings the running plugins in line with `plugins`. Plugins that are
+// no longer listed, or whose settings changed, are stopped and new or
+// changed ones started; the rest keep running untouched. Plugins fetched
+// from mcper-cloud aren't part of the start script and are left alone.
+func (ps *pluginSet) apply(plugins []mcper.PluginConfig) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	var kept, running []*runningPlugin
+	var configs []mcper.PluginConfig
+	for _, p := range ps.plugins {
+		if p.config.IsCloud {
+			kept = append(kept, p)
+			continue
+		}
+		running = append(running, p)
+		configs = append(configs, p.config)
+	}
+
+	removed, added := diffPlugins(configs, plugins)
+	for i, p := range running {
+		if removed[i] {
+			ps.stop(p)
+			continue
+		}
+		kept = append(kept, p)
+	}
+	ps.plugins = kept
+	for _, plugin := range added {
+		ps.plugins = append(ps.plugins, ps.start(plugin))
+	}
+}
+
+// diffPlugins compares the running plugin configs with the wanted ones. It
+// reports which running plugins to stop, by index, and which configs to
+// start. A plugin whose settings changed is stopped and started again.
+func diffPlugins(running, wanted []mcper.PluginConfig) (removed map[int]bool, added []mcper.PluginConfig) {
+	removed = make(map[int]bool)
+	matched := make([]bool, len(wanted))
+	for i, old := range running {
+		removed[i] = true
+		for j, plugin := range wanted {
+			if !matched[j] && reflect.DeepEqual(old, plugin) {
+				matched[j] = true
+				delete(removed, i)
+				break
+			}
+		}
+	}
+	for j, plugin := range wanted {
+		if !matched[j] {
+			added = append(added, plugin)
+		}
+	}
+	return removed, added
+}
+
+// start loads one plugin. A plugin that fails to load is retried in the
+// background until it loads or is stopped.
+func (ps *pluginSet) start(plugin mcper.PluginConfig) *runningPlugin {
+	name := fmt.Sprintf("plugin-%d", ps.seq)
+	ps.seq++
+	ctx, cancel := context.WithCancel(ps.ctx)
+	scope := &pluginScope{
+		server:      ps.server,
+		source:      plugin.Source,
+		rules:       plugin.Tools,
+		owners:      &ps.owners,
+		progress:    ps.progress,
+		sampling:    plugin.Permissions != nil && plugin.Permissions.Sampling,
+		elicitation: plugin.Permissions != nil && plugin.Permissions.Elicitation,
+		audit:       ps.audit,
+		metrics:     ps.metrics,
+		tracer:      ps.tracer,
+	}
+	p := &runningPlugin{name: name, config: plugin, scope: scope, cancel: cancel}
+
+	slog.Info("Loading plugin", "module", name, "source", plugin.Source)
+	kind, load, err := ps.loader(name, plugin, p.scope)
+	if err != nil {
+		p.health = ps.health.fail(name, plugin.Source, err)
+		return p
+	}
+	p.health = ps.health.load(ctx, name, plugin.Source, kind, load)
+	return p
+}
+
+// stop removes a plugin's tools and stops it, along with any retry.
+func (ps *pluginSet) stop(p *runningPlugin) {
+	slog.Info("Stopping plugin", "module", p.name, "source", p.config.Source)
+	p.cancel()
+	p.scope.close()
+	ps.host.UnloadModule(ps.ctx, p.name)
+	ps.health.remove(p.health)
+}
+
+// stopAll stops every plugin, cloud plugins included.
+func (ps *pluginSet) stopAll() {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	for _, p := range ps.plugins {
+		ps.stop(p)
+	}
+	ps.plugins = nil
+}
+
+// loader picks how to load `plugin` and returns a description for logs
+// along with the load function.
+func (ps *pluginSet) loader(name string, plugin mcper.PluginConfig, scope *pluginScope) (string, func(ctx context.Context) error, error) {
+	parsed, err := mcper.ParsePluginSource(plugin.Source)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to parse plugin source: %w", err)
+	}
+	slog.Debug("Parsed plugin source", "module", name, "type", parsed.Type, "name", parsed.Name, "version", parsed.Version)
+	if err := plugin.Tools.Validate(); err != nil {
+		return "", nil, err
+	}
+
+	switch {
+	case plugin.IsCloud:
+		// Cloud plugins are forwarded to mcper-cloud, not run locally
+		return "cloud plugin", func(ctx context.Context) error {
+			// Re-read credentials so a retry picks up a fresh `mcper login`
+			// made since serve found none, or only an expired one.
+			keys := ps.keys
+			if !keys.Credentials().IsValid() {
+				if fresh, err := mcper.LoadProfileCredentials(ps.profile); err == nil && fresh.IsValid() {
+					keys = mcper.NewKeyRefresher(fresh, nil, nil)
+				}
+			}
+			_, err := loadCloudPlugin(ctx, scope, name, plugin, keys)
+			return err
+		}, nil
+
+	case parsed.Type == mcper.PluginTypeLocal:
+		// Local WASM file
+		return "local WASM", func(ctx context.Context) error {
+			_, err := loadLocalWASM(ctx, ps.host, ps.supervisors, scope, name, plugin, parsed, ps.keys, ps.proxyURL)
+			return err
+		}, nil
+
+	case parsed.Type == mcper.PluginTypeWASM:
+		// Remote WASM - check cache first
+		return "remote WASM", func(ctx context.Context) error {
+			_, err := loadRemoteWASM(ctx, ps.host, ps.supervisors, scope, name, plugin, parsed, ps.keys, ps.proxyURL)
+			return err
+		}, nil
+
+	case parsed.Type == mcper.PluginTypeHTTP:
+		// HTTP MCP server
+		return "HTTP plugin", func(ctx context.Context) error {
+			_, err := loadHTTPPlugin(ctx, scope, name, plugin)
+			return err
+		}, nil
+	}
+	return "", nil, fmt.Errorf("unsupported plugin type")
+}
+
+// watchStartScript applies the config in the start script at `path` each
+// time the file changes, until ctx is cancelled. A script that doesn't
+// parse is logged and otherwise ignored.
+func watchStartScript(ctx context.Context, path string, apply func(plugins []mcper.PluginConfig)) {
+	var lastMod time.Time
+	var lastSize int64
+	if info, err := os.Stat(path); err == nil {
+		lastMod, lastSize = info.ModTime(), info.Size()
+	}
+	slog.Info("Watching start script for plugin changes", "path", path)
+
+	ticker := time.NewTicker(startScriptPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		info, err := os.Stat(path)
+		if err != nil || (info.ModTime().Equal(lastMod) && info.Size() == lastSize) {
+			continue
+		}
+		lastMod, lastSize = info.ModTime(), info.Size()
+
+		config, err := mcper.ParseStartScript(path)
+		if err != nil {
+			slog.Warn("Not reloading plugins", "path", path, "err", err)
+			continue
+		}
+		slog.Info("Start script changed, reloading plugins", "path", path, "plugins", len(config.Plugins))
+		apply(config.Plugins)
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/jsonschema-go/jsonschema"
+	"github.com/joshcarp/mcper/pkg/mcper"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+func TestDiffPlugins(t *testing.T) {
+	hello := mcper.PluginConfig{Source: "./plugin-hello.wasm"}
+	github := mcper.PluginConfig{Source: "https://example.com/plugin-github.wasm"}
+	githubWithEnv := mcper.PluginConfig{Source: github.Source, Env: map[string]string{"GITHUB_TOKEN": "GITHUB_TOKEN"}}
+	docs := mcper.PluginConfig{Source: "https://docs.example.com/mcp"}
+
+	tests := []struct {
+		name        string
+		running     []mcper.PluginConfig
+		wanted      []mcper.PluginConfig
+		wantRemoved []int
+		wantAdded   []string
+	}{
+		{
+			name:    "unchanged",
+			running: []mcper.PluginConfig{hello, github},
+			wanted:  []mcper.PluginConfig{github, hello},
+		},
+		{
+			name:      "added",
+			running:   []mcper.PluginConfig{hello},
+			wanted:    []mcper.PluginConfig{hello, docs},
+			wantAdded: []string{docs.Source},
+		},
+		{
+			name:        "removed",
+			running:     []mcper.PluginConfig{hello, docs},
+			wanted:      []mcper.PluginConfig{hello},
+			wantRemoved: []int{1},
+		},
+		{
+			name:        "changed settings restart the plugin",
+			running:     []mcper.PluginConfig{hello, github},
+			wanted:      []mcper.PluginConfig{hello, githubWithEnv},
+			wantRemoved: []int{1},
+			wantAdded:   []string{github.Source},
+		},
+		{
+			name:        "duplicates are matched one to one",
+			running:     []mcper.PluginConfig{hello, hello},
+			wanted:      []mcper.PluginConfig{hello},
+			wantRemoved: []int{1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			removed, added := diffPlugins(tt.running, tt.wanted)
+			if len(removed) != len(tt.wantRemoved) {
+				t.Errorf("removed = %v, want %v", removed, tt.wantRemoved)
+			}
+			for _, i := range tt.wantRemoved {
+				if !removed[i] {
+					t.Errorf("removed = %v, want %v", removed, tt.wantRemoved)
+				}
+			}
+			if len(added) != len(tt.wantAdded) {
+				t.Fatalf("added = %v, want %v", added, tt.wantAdded)
+			}
+			for i, source := range tt.wantAdded {
+				if added[i].Source != source {
+					t.Errorf("added[%d] = %s, want %s", i, added[i].Source, source)
+				}
+			}
+		})
+	}
+}
+
+func TestPluginScope_CloseRemovesToolsAndNotifies(t *testing.T) {
+	ctx := context.Background()
+	server := mcp.NewServer(&mcp.Implementation{Name: "mcper", Version: "test"}, nil)
+	registerHealthTool(server, &healthSet{})
+
+	changed := make(chan struct{}, 10)
+	client := mcp.NewClient(&mcp.Implementation{Name: "client", Version: "test"}, &mcp.ClientOptions{
+		ToolListChangedHandler: func(context.Context, *mcp.ToolListChangedRequest) { changed <- struct{}{} },
+	})
+	serverTransport, clientTransport := mcp.NewInMemoryTransports()
+	if _, err := server.Connect(ctx, serverTransport, nil); err != nil {
+		t.Fatal(err)
+	}
+	session, err := client.Connect(ctx, clientTransport, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+
+	scope := &pluginScope{server: server, progress: &progressRelay{}}
+	tool := &mcp.Tool{Name: "wasm_hello_greet", InputSchema: &jsonschema.Schema{Type: "object"}}
+	handler := func(context.Context, *mcp.CallToolRequest, map[string]any) (*mcp.CallToolResult, any, error) {
+		return textResult("hi"), nil, nil
+	}
+	scope.addTool(tool, handler)
+	waitForListChanged(t, changed)
+	if !hasTool(t, session, tool.Name) {
+		t.Fatalf("%s not listed after addTool", tool.Name)
+	}
+
+	closed := 0
+	scope.onClose(func() { closed++ })
+	scope.close()
+	waitForListChanged(t, changed)
+	if hasTool(t, session, tool.Name) {
+		t.Errorf("%s still listed after close", tool.Name)
+	}
+	if closed != 1 {
+		t.Errorf("closer ran %d times, want 1", closed)
+	}
+
+	// A retry finishing after the plugin was removed must not bring it back.
+	scope.addTool(tool, handler)
+	if hasTool(t, session, tool.Name) {
+		t.Errorf("%s listed after addTool on a closed scope", tool.Name)
+	}
+	scope.onClose(func() { closed++ })
+	if closed != 2 {
+		t.Errorf("onClose on a closed scope did not run straight away")
+	}
+}
+
+func waitForListChanged(t *testing.T, changed <-chan struct{}) {
+	t.Helper()
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no tools/list_changed notification")
+	}
+}
+
+func hasTool(t *testing.T, session *mcp.ClientSession, name string) bool {
+	t.Helper()
+	tools, err := session.ListTools(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tool := range tools.Tools {
+		if tool.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func TestRegisterForwardedTools_RulesAndCollisions(t *testing.T) {
+	server := mcp.NewServer(&mcp.Implementation{Name: "mcper", Version: "test"}, nil)
+	session := connect(t, server)
+	owners := &toolOwners{}
+	tools := []*mcp.Tool{{Name: "list_messages"}, {Name: "send_message", Description: "Send"}}
+
+	gmail := &pluginScope{server: server, source: "gmail.wasm", owners: owners, progress: &progressRelay{}, rules: &mcper.ToolRules{
+		Alias:   "mail",
+		Exclude: []string{"send_*"},
+	}}
+	if err := registerForwardedTools(gmail, &pluginConn{}, namespaceWASM, "plugin-0", "Tool call failed", tools, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !hasTool(t, session, "mail_list_messages") {
+		t.Error("mail_list_messages not listed")
+	}
+	if hasTool(t, session, "mail_send_message") {
+		t.Error("excluded mail_send_message listed")
+	}
+
+	// A second plugin aliased the same way collides and registers nothing.
+	other := &pluginScope{server: server, source: "other.wasm", owners: owners, progress: &progressRelay{}, rules: &mcper.ToolRules{Alias: "mail"}}
+	if err := registerForwardedTools(other, &pluginConn{}, namespaceWASM, "plugin-1", "Tool call failed", tools, nil, nil); err == nil {
+		t.Error("registered a tool name another plugin forwards, want error")
+	}
+	if hasTool(t, session, "mail_send_message") {
+		t.Error("mail_send_message listed after a collision")
+	}
+
+	// Two tools of one plugin renamed alike collide too.
+	renamed := &pluginScope{server: server, source: "renamed.wasm", owners: owners, progress: &progressRelay{}, rules: &mcper.ToolRules{
+		Rename: map[string]mcper.ToolOverride{"list_messages": {Name: "mail"}, "send_message": {Name: "mail"}},
+	}}
+	if err := registerForwardedTools(renamed, &pluginConn{}, namespaceWASM, "plugin-2", "Tool call failed", tools, nil, nil); err == nil {
+		t.Error("registered two tools under one name, want error")
+	}
+
+	// Once the first plugin is removed its names are free again.
+	gmail.close()
+	if err := registerForwardedTools(other, &pluginConn{}, namespaceWASM, "plugin-1", "Tool call failed", tools, nil, nil); err != nil {
+		t.Errorf("after the first plugin closed: %v", err)
+	}
+	if !hasTool(t, session, "mail_send_message") {
+		t.Error("mail_send_message not listed")
+	}
+}
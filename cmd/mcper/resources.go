@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// forwardedURIPrefix is prepended to the URIs and URI templates of a
+// plugin's resources, e.g. gmail://messages/1 becomes
+// mcper://wasm_gmail/gmail://messages/1. `prefix` is the one the plugin's
+// tools are named with. The plugin's own URI is kept verbatim after it so
+// reads can be routed back to the plugin.
+func forwardedURIPrefix(prefix string) string {
+	return "mcper://" + prefix + "/"
+}
+
+// forwardedItemName names a resource, resource template or prompt the way
+// tools are named: `prefix`, an underscore and its own name, sanitized.
+func forwardedItemName(prefix, name string) string {
+	forwarded, _ := sanitizeToolName(prefix + "_" + name)
+	return forwarded
+}
+
+// registerForwardedResources forwards the resources, resource templates and
+// prompts `session` offers, named and rewritten like forwarded tools: under
+// the tool rules' alias, if any, skipping those the include and exclude
+// patterns leave out, and never replacing another plugin's. Reads and
+// prompt requests go to a session from `source`, as tool calls do.
+// Failures are only logged: the plugin's tools work without them.
+func registerForwardedResources(ctx context.Context, scope *pluginScope, source connSource, session *mcp.ClientSession, namespace, pluginName string) {
+	caps := session.InitializeResult().Capabilities
+	if caps == nil {
+		return
+	}
+	namePrefix := scope.rules.Prefix(fmt.Sprintf("%s_%s", namespace, pluginName))
+	prefix := forwardedURIPrefix(namePrefix)
+
+	if caps.Resources != nil {
+		read := func(ctx context.Context, req *mcp.ReadResourceRequest) (*mcp.ReadResourceResult, error) {
+			uri, ok := strings.CutPrefix(req.Params.URI, prefix)
+			if !ok {
+				return nil, mcp.ResourceNotFoundError(req.Params.URI)
+			}
+			result, err := forwardRequest(ctx, source, func(ctx context.Context, session *mcp.ClientSession) (*mcp.ReadResourceResult, error) {
+				return session.ReadResource(ctx, &mcp.ReadResourceParams{Meta: req.Params.Meta, URI: uri})
+			})
+			if err != nil {
+				return nil, err
+			}
+			for _, contents := range result.Contents {
+				contents.URI = prefix + contents.URI
+			}
+			return result, nil
+		}
+
+		var resources []*mcp.Resource
+		var names []forwardedName
+		for resource, err := range session.Resources(ctx, nil) {
+			if err != nil {
+				slog.Warn("Failed to list resources", "plugin", pluginName, "err", err)
+				break
+			}
+			if !scope.rules.Forwards(resource.Name) {
+				slog.Info("Not forwarding resource", "namespace", namespace, "uri", resource.URI, "plugin", pluginName)
+				continue
+			}
+			forwarded := *resource
+			forwarded.URI = prefix + resource.URI
+			forwarded.Name = forwardedItemName(namePrefix, resource.Name)
+			if _, err := url.Parse(forwarded.URI); err != nil {
+				slog.Warn("Skipping resource", "uri", resource.URI, "plugin", pluginName, "err", err)
+				continue
+			}
+			resources = append(resources, &forwarded)
+			names = append(names, forwardedName{Name: forwarded.URI, Tool: resource.URI, Source: scope.source})
+		}
+		if err := scope.reserveKind(kindResource, names); err != nil {
+			slog.Warn("Not forwarding resources", "plugin", pluginName, "err", err)
+		} else {
+			for _, resource := range resources {
+				scope.addResource(resource, read)
+				slog.Info("Registered resource", "namespace", namespace, "uri", resource.URI)
+			}
+		}
+
+		var templates []*mcp.ResourceTemplate
+		names = nil
+		for template, err := range session.ResourceTemplates(ctx, nil) {
+			if err != nil {
+				slog.Warn("Failed to list resource templates", "plugin", pluginName, "err", err)
+				break
+			}
+			if !scope.rules.Forwards(template.Name) {
+				slog.Info("Not forwarding resource template", "namespace", namespace, "uri_template", template.URITemplate, "plugin", pluginName)
+				continue
+			}
+			forwarded := *template
+			forwarded.URITemplate = prefix + template.URITemplate
+			forwarded.Name = forwardedItemName(namePrefix, template.Name)
+			templates = append(templates, &forwarded)
+			names = append(names, forwardedName{Name: forwarded.URITemplate, Tool: template.URITemplate, Source: scope.source})
+		}
+		if err := scope.reserveKind(kindResourceTemplate, names); err != nil {
+			slog.Warn("Not forwarding resource templates", "plugin", pluginName, "err", err)
+		} else {
+			for _, template := range templates {
+				scope.addResourceTemplate(template, read)
+				slog.Info("Registered resource template", "namespace", namespace, "uri_template", template.URITemplate)
+			}
+		}
+	}
+
+	if caps.Prompts != nil {
+		var prompts []*mcp.Prompt
+		var names []forwardedName
+		seen := make(map[string]string) // forwarded name -> plugin prompt name
+		for prompt, err := range session.Prompts(ctx, nil) {
+			if err != nil {
+				slog.Warn("Failed to list prompts", "plugin", pluginName, "err", err)
+				break
+			}
+			if !scope.rules.Forwards(prompt.Name) {
+				slog.Info("Not forwarding prompt", "namespace", namespace, "prompt", prompt.Name, "plugin", pluginName)
+				continue
+			}
+			forwarded := *prompt
+			forwarded.Name = forwardedItemName(namePrefix, prompt.Name)
+			if other, ok := seen[forwarded.Name]; ok {
+				slog.Warn("Not forwarding prompt, its name is taken", "prompt", prompt.Name, "plugin", pluginName, "other", other, "name", forwarded.Name)
+				continue
+			}
+			seen[forwarded.Name] = prompt.Name
+			prompts = append(prompts, &forwarded)
+			names = append(names, forwardedName{Name: forwarded.Name, Tool: prompt.Name, Source: scope.source})
+		}
+		if err := scope.reserveKind(kindPrompt, names); err != nil {
+			slog.Warn("Not forwarding prompts", "plugin", pluginName, "err", err)
+			return
+		}
+		for i, prompt := range prompts {
+			promptName := names[i].Tool
+			scope.addPrompt(prompt, func(ctx context.Context, req *mcp.GetPromptRequest) (*mcp.GetPromptResult, error) {
+				return forwardRequest(ctx, source, func(ctx context.Context, session *mcp.ClientSession) (*mcp.GetPromptResult, error) {
+					return session.GetPrompt(ctx, &mcp.GetPromptParams{Meta: req.Params.Meta, Name: promptName, Arguments: req.Params.Arguments})
+				})
+			})
+			slog.Info("Registered prompt", "namespace", namespace, "prompt", prompt.Name)
+		}
+	}
+}
+
+// forwardRequest runs `call` against a session from `source`, bounded by
+// the plugin's call timeout.
+func forwardRequest[T any](ctx context.Context, source connSource, call func(ctx context.Context, session *mcp.ClientSession) (T, error)) (T, error) {
+	var zero T
+	conn, err := source.acquire(ctx)
+	if err != nil {
+		return zero, err
+	}
+	defer source.release(conn)
+	session, _, downErr := conn.current()
+	if downErr != nil {
+		return zero, downErr
+	}
+	if conn.callTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, conn.callTimeout)
+		defer cancel()
+	}
+	return call(ctx, session)
+}
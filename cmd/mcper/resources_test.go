@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/joshcarp/mcper/pkg/mcper"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// connect returns a client session to `server` over in-memory transports.
+func connect(t *testing.T, server *mcp.Server) *mcp.ClientSession {
+	t.Helper()
+	ctx := context.Background()
+	serverTransport, clientTransport := mcp.NewInMemoryTransports()
+	if _, err := server.Connect(ctx, serverTransport, nil); err != nil {
+		t.Fatal(err)
+	}
+	session, err := mcp.NewClient(&mcp.Implementation{Name: "client", Version: "test"}, nil).Connect(ctx, clientTransport, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { session.Close() })
+	return session
+}
+
+func TestRegisterForwardedResources(t *testing.T) {
+	ctx := context.Background()
+
+	plugin := mcp.NewServer(&mcp.Implementation{Name: "gmail", Version: "test"}, nil)
+	readMessage := func(ctx context.Context, req *mcp.ReadResourceRequest) (*mcp.ReadResourceResult, error) {
+		return &mcp.ReadResourceResult{Contents: []*mcp.ResourceContents{{URI: req.Params.URI, Text: "hello from " + req.Params.URI}}}, nil
+	}
+	plugin.AddResource(&mcp.Resource{Name: "inbox", URI: "gmail://inbox"}, readMessage)
+	plugin.AddResourceTemplate(&mcp.ResourceTemplate{Name: "message", URITemplate: "gmail://messages/{id}"}, readMessage)
+	plugin.AddPrompt(&mcp.Prompt{Name: "summarize", Arguments: []*mcp.PromptArgument{{Name: "id"}}}, func(ctx context.Context, req *mcp.GetPromptRequest) (*mcp.GetPromptResult, error) {
+		return &mcp.GetPromptResult{Messages: []*mcp.PromptMessage{{Role: "user", Content: &mcp.TextContent{Text: "summarize " + req.Params.Arguments["id"]}}}}, nil
+	})
+	pluginSession := connect(t, plugin)
+
+	server := mcp.NewServer(&mcp.Implementation{Name: "mcper", Version: "test"}, nil)
+	scope := &pluginScope{server: server, progress: &progressRelay{}}
+	registerForwardedResources(ctx, scope, &pluginConn{session: pluginSession}, pluginSession, namespaceWASM, "gmail")
+	client := connect(t, server)
+
+	resources, err := client.ListResources(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resources.Resources) != 1 || resources.Resources[0].URI != "mcper://wasm_gmail/gmail://inbox" || resources.Resources[0].Name != "wasm_gmail_inbox" {
+		t.Fatalf("resources = %+v", resources.Resources)
+	}
+
+	tests := []struct {
+		uri      string
+		wantText string
+	}{
+		{"mcper://wasm_gmail/gmail://inbox", "hello from gmail://inbox"},
+		{"mcper://wasm_gmail/gmail://messages/42", "hello from gmail://messages/42"},
+	}
+	for _, tt := range tests {
+		result, err := client.ReadResource(ctx, &mcp.ReadResourceParams{URI: tt.uri})
+		if err != nil {
+			t.Errorf("ReadResource(%s): %v", tt.uri, err)
+			continue
+		}
+		if len(result.Contents) != 1 || result.Contents[0].Text != tt.wantText || result.Contents[0].URI != tt.uri {
+			t.Errorf("ReadResource(%s) contents = %+v", tt.uri, result.Contents[0])
+		}
+	}
+
+	prompt, err := client.GetPrompt(ctx, &mcp.GetPromptParams{Name: "wasm_gmail_summarize", Arguments: map[string]string{"id": "42"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text, ok := prompt.Messages[0].Content.(*mcp.TextContent); !ok || text.Text != "summarize 42" {
+		t.Errorf("prompt messages = %+v", prompt.Messages)
+	}
+
+	scope.close()
+	if resources, err := client.ListResources(ctx, nil); err != nil || len(resources.Resources) != 0 {
+		t.Errorf("resources after close = %v, %v", resources, err)
+	}
+	if prompts, err := client.ListPrompts(ctx, nil); err != nil || len(prompts.Prompts) != 0 {
+		t.Errorf("prompts after close = %v, %v", prompts, err)
+	}
+}
+
+// gmailPlugin returns a session to a plugin offering an inbox resource, a
+// drafts resource and a summarize prompt.
+func gmailPlugin(t *testing.T) *mcp.ClientSession {
+	t.Helper()
+	plugin := mcp.NewServer(&mcp.Implementation{Name: "gmail", Version: "test"}, nil)
+	read := func(ctx context.Context, req *mcp.ReadResourceRequest) (*mcp.ReadResourceResult, error) {
+		return &mcp.ReadResourceResult{Contents: []*mcp.ResourceContents{{URI: req.Params.URI, Text: "hello"}}}, nil
+	}
+	plugin.AddResource(&mcp.Resource{Name: "inbox", URI: "gmail://inbox"}, read)
+	plugin.AddResource(&mcp.Resource{Name: "drafts", URI: "gmail://drafts"}, read)
+	plugin.AddPrompt(&mcp.Prompt{Name: "summarize"}, func(ctx context.Context, req *mcp.GetPromptRequest) (*mcp.GetPromptResult, error) {
+		return &mcp.GetPromptResult{}, nil
+	})
+	return connect(t, plugin)
+}
+
+func TestRegisterForwardedResources_ToolRules(t *testing.T) {
+	ctx := context.Background()
+	server := mcp.NewServer(&mcp.Implementation{Name: "mcper", Version: "test"}, nil)
+	owners := &toolOwners{}
+	rules := &mcper.ToolRules{Alias: "mail", Exclude: []string{"drafts"}}
+	scope := &pluginScope{server: server, source: "gmail.wasm", rules: rules, owners: owners, progress: &progressRelay{}}
+	session := gmailPlugin(t)
+	registerForwardedResources(ctx, scope, &pluginConn{session: session}, session, namespaceWASM, "gmail")
+	client := connect(t, server)
+
+	resources, err := client.ListResources(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resources.Resources) != 1 || resources.Resources[0].URI != "mcper://mail/gmail://inbox" || resources.Resources[0].Name != "mail_inbox" {
+		t.Errorf("resources = %+v, want only mail_inbox under mcper://mail/", resources.Resources)
+	}
+	prompts, err := client.ListPrompts(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(prompts.Prompts) != 1 || prompts.Prompts[0].Name != "mail_summarize" {
+		t.Errorf("prompts = %+v, want mail_summarize", prompts.Prompts)
+	}
+
+	// A second plugin under the same alias can't take over the first's
+	// resources or prompts.
+	other := &pluginScope{server: server, source: "other.wasm", rules: &mcper.ToolRules{Alias: "mail"}, owners: owners, progress: &progressRelay{}}
+	otherSession := gmailPlugin(t)
+	registerForwardedResources(ctx, other, &pluginConn{session: otherSession}, otherSession, namespaceWASM, "other")
+	other.mu.Lock()
+	added := len(other.resources) + len(other.prompts)
+	other.mu.Unlock()
+	if added != 0 {
+		t.Errorf("second plugin forwarded %d resources and prompts under the same names, want none", added)
+	}
+
+	// Once the first plugin is removed its names are free again.
+	scope.close()
+	registerForwardedResources(ctx, other, &pluginConn{session: otherSession}, otherSession, namespaceWASM, "other")
+	if prompts, err := client.ListPrompts(ctx, nil); err != nil || len(prompts.Prompts) != 1 {
+		t.Errorf("prompts after the first plugin was removed = %+v, %v", prompts, err)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/joshcarp/mcper/pkg/mcper"
+	"github.com/spf13/cobra"
+)
+
+var secretCmd = &cobra.Command{
+	Use:   "secret",
+	Short: "Manage plugin secrets in the keyring",
+	Long: `Manage secrets that plugins read through keyring: references.
+
+A plugin's env values in .mcper/start.sh may name a host env var or refer
+to a secret, resolved each time the plugin starts:
+
+  "AZDO_PAT": "AZURE_DEVOPS_PAT"            host env var
+  "AZDO_PAT": "env:AZURE_DEVOPS_PAT"        host env var
+  "AZDO_PAT": "file:~/.secrets/azdo"        contents of a file
+  "AZDO_PAT": "cmd:pass show azdo"          output of a command
+  "AZDO_PAT": "keyring:mcper/azdo"          service mcper, account azdo
+
+keyring: references use the macOS keychain or the Secret Service
+(secret-tool) on Linux, and otherwise ~/.mcper/keyring.json, or the file
+named by $MCPER_KEYRING_FILE.
+
+Example:
+  pass show azdo | mcper secret set mcper/azdo
+  mcper secret rm mcper/azdo`,
+}
+
+var secretSetCmd = &cobra.Command{
+	Use:   "set <service/account>",
+	Short: "Store a secret read from stdin",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runSecretSet,
+}
+
+var secretRmCmd = &cobra.Command{
+	Use:   "rm <service/account>",
+	Short: "Remove a secret",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runSecretRm,
+}
+
+func init() {
+	secretCmd.AddCommand(secretSetCmd)
+	secretCmd.AddCommand(secretRmCmd)
+}
+
+func runSecretSet(cmd *cobra.Command, args []string) error {
+	keyring, err := mcper.DefaultKeyring()
+	if err != nil {
+		return err
+	}
+	if info, err := os.Stdin.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		fmt.Fprint(os.Stderr, "Secret: ")
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return fmt.Errorf("failed to read secret: %w", err)
+	}
+	secret := strings.TrimRight(line, "\r\n")
+	if secret == "" {
+		return fmt.Errorf("secret is empty")
+	}
+	service, account := mcper.ParseKeyringRef(args[0])
+	if err := keyring.Set(service, account, secret); err != nil {
+		return fmt.Errorf("failed to store secret: %w", err)
+	}
+	fmt.Printf("Stored %s/%s; refer to it as \"%s%s/%s\"\n", service, account, mcper.SecretKeyring, service, account)
+	return nil
+}
+
+func runSecretRm(cmd *cobra.Command, args []string) error {
+	keyring, err := mcper.DefaultKeyring()
+	if err != nil {
+		return err
+	}
+	service, account := mcper.ParseKeyringRef(args[0])
+	if err := keyring.Delete(service, account); err != nil {
+		return fmt.Errorf("failed to remove %s/%s: %w", service, account, err)
+	}
+	fmt.Printf("Removed %s/%s\n", service, account)
+	return nil
+}
+
+// resolvePluginEnv resolves a plugin's env values, each a host env var name
+// or a secret reference, into NAME=value pairs. It runs each time the
+// plugin starts, so a rotated secret is picked up on restart. Resolved
+// values are registered with the log scrubber and never logged.
+func resolvePluginEnv(ctx context.Context, env map[string]string) ([]string, error) {
+	var keyring mcper.Keyring
+	var envVars []string
+	for wasmEnvName, ref := range env {
+		if keyring == nil && strings.HasPrefix(ref, mcper.SecretKeyring) {
+			var err error
+			if keyring, err = mcper.DefaultKeyring(); err != nil {
+				return nil, err
+			}
+		}
+		value, err := mcper.ResolveSecret(ctx, ref, keyring)
+		if err != nil {
+			return nil, fmt.Errorf("env var %s: %w", wasmEnvName, err)
+		}
+		if value == "" {
+			slog.Warn("Plugin env var is empty", "name", wasmEnvName, "ref", ref)
+			continue
+		}
+		mcper.RegisterSecret(value)
+		envVars = append(envVars, fmt.Sprintf("%s=%s", wasmEnvName, value))
+		slog.Info("Passing env var to WASM module", "name", wasmEnvName)
+	}
+	return envVars, nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/joshcarp/mcper/pkg/mcper"
+)
+
+func TestResolvePluginEnv(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv(mcper.KeyringFileEnv, filepath.Join(dir, mcper.KeyringFile))
+	keyring, err := mcper.DefaultKeyring()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := keyring.Set("mcper", "azdo", "keyring-pat-1"); err != nil {
+		t.Fatal(err)
+	}
+	secretFile := filepath.Join(dir, "token")
+	if err := os.WriteFile(secretFile, []byte("file-token-1\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("MCPER_TEST_HOST_TOKEN", "host-token")
+
+	env := map[string]string{
+		"AZDO_PAT":   "keyring:mcper/azdo",
+		"TOKEN":      "file:" + secretFile,
+		"HOST_TOKEN": "MCPER_TEST_HOST_TOKEN",
+		"UNSET":      "env:MCPER_TEST_UNSET",
+	}
+	got, err := resolvePluginEnv(context.Background(), env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(got)
+	want := []string{"AZDO_PAT=keyring-pat-1", "HOST_TOKEN=host-token", "TOKEN=file-token-1"}
+	if !slices.Equal(got, want) {
+		t.Errorf("resolvePluginEnv = %q, want %q", got, want)
+	}
+
+	// A restart resolves the references again and picks up rotated secrets.
+	if err := keyring.Set("mcper", "azdo", "keyring-pat-2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(secretFile, []byte("file-token-2\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	got, err = resolvePluginEnv(context.Background(), env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(got)
+	want = []string{"AZDO_PAT=keyring-pat-2", "HOST_TOKEN=host-token", "TOKEN=file-token-2"}
+	if !slices.Equal(got, want) {
+		t.Errorf("after rotation resolvePluginEnv = %q, want %q", got, want)
+	}
+
+	if mcper.Scrub("pat is keyring-pat-2") != "pat is <REDACTED>" {
+		t.Error("resolved secret not registered with the log scrubber")
+	}
+
+	if _, err := resolvePluginEnv(context.Background(), map[string]string{"X": "file:" + filepath.Join(dir, "missing")}); err == nil {
+		t.Error("missing secret file resolved")
+	}
+}
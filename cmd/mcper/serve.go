@@ -2,15 +2,20 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/joshcarp/mcper/pkg/mcper"
@@ -22,7 +27,14 @@ import (
 )
 
 var (
-	configJSON string
+	configJSON       string
+	serveWatch       bool
+	serveListen      string
+	serveMetricsAddr string
+	serveTraceOTLP   string
+	serveTraceFile   string
+	serveLogLevel    string
+	serveLogJSON     bool
 )
 
 // Tool name namespace prefixes. Tool names follow ^[a-zA-Z0-9_-]{1,64}$
@@ -38,194 +50,251 @@ var serveCmd = &cobra.Command{
 	Short: "Run MCP server with plugins",
 	Long: `Run an MCP server that aggregates plugins defined in the config.
 
-The server communicates over stdin/stdout using the MCP protocol.
+The server communicates over stdin/stdout using the MCP protocol, or with
+--listen over Streamable HTTP at /mcp, where any number of clients can
+connect at once. HTTP clients must send the bearer token from
+$MCPER_SERVE_TOKEN, or else the one generated in ~/.mcper/serve-token.
 
 Examples:
   mcper serve --config-json '{"plugins":[...]}'
-  .mcper/serve.sh  # which calls: mcper serve --config-json "$CONFIG"`,
+  .mcper/serve.sh  # which calls: mcper serve --config-json "$CONFIG"
+  mcper serve --config-json "$CONFIG" --listen 127.0.0.1:8931
+
+With --watch, changes to .mcper/start.sh (e.g. from mcper add) are applied
+without a restart: only added, removed or changed plugins are started or
+stopped, and clients are notified that the tool list changed.
+
+With --metrics-listen, tool call counts, errors and latency, WASM restarts
+and memory, and cap mint and approval times are exported in the Prometheus
+text format at /metrics on the given address (e.g. 127.0.0.1:9464).
+
+With --trace-endpoint (or $OTEL_EXPORTER_OTLP_ENDPOINT) or --trace-file,
+each tool call is traced: spans for approval, cap minting and the plugin
+call are exported over OTLP/HTTP or written to a file as OTLP JSON lines.
+The trace context is passed to plugins in _meta.traceparent.
+
+Logs go to stderr and ~/.mcper/mcper.log, which is rotated at 10 MiB, with
+bearer tokens, API keys and plugin env values redacted. --log-level debug
+also logs every byte exchanged with WASM plugins.`,
 	RunE: runServe,
 }
 
 func init() {
 	serveCmd.Flags().StringVar(&configJSON, "config-json", "", "JSON configuration string")
+	serveCmd.Flags().BoolVar(&serveWatch, "watch", false, "Reload plugins when .mcper/start.sh changes")
+	serveCmd.Flags().StringVar(&serveListen, "listen", "", "Serve over Streamable HTTP on this address (e.g. :8931) instead of stdio")
+	serveCmd.Flags().StringVar(&serveLogLevel, "log-level", "info", "Log level: debug, info, warn or error")
+	serveCmd.Flags().BoolVar(&serveLogJSON, "log-json", false, "Write logs as JSON")
+	serveCmd.Flags().StringVar(&serveTraceOTLP, "trace-endpoint", "", "Export traces to this OTLP/HTTP collector (e.g. http://localhost:4318)")
+	serveCmd.Flags().StringVar(&serveTraceFile, "trace-file", "", "Write traces to this file as OTLP JSON lines")
+	serveCmd.Flags().StringVar(&serveMetricsAddr, "metrics-listen", "", "Serve Prometheus metrics at /metrics on this address (e.g. 127.0.0.1:9464)")
 	serveCmd.MarkFlagRequired("config-json")
 }
 
-// setupLogging configures logging to write to ~/.mcper/mcper.log
-func setupLogging() (*os.File, error) {
+// setupLogging sends logs, including those of the standard log package,
+// to stderr and ~/.mcper/mcper.log with secrets scrubbed. If the log file
+// can't be opened, logs still go to stderr.
+func setupLogging(level slog.Level, jsonFormat bool) (io.Closer, error) {
+	cfg := mcper.LogConfig{Level: level, JSON: jsonFormat, Stderr: os.Stderr}
 	homeDir, err := os.UserHomeDir()
-	if err != nil {
-		return nil, err
+	if err == nil {
+		cfg.File = filepath.Join(homeDir, ".mcper", mcper.LogFile)
 	}
-
-	mcperDir := filepath.Join(homeDir, ".mcper")
-	if err := os.MkdirAll(mcperDir, 0755); err != nil {
-		return nil, err
+	logger, closer, fileErr := mcper.NewLogger(cfg)
+	if fileErr != nil {
+		cfg.File = ""
+		logger, closer, _ = mcper.NewLogger(cfg)
+		err = fileErr
 	}
-
-	logPath := filepath.Join(mcperDir, "mcper.log")
-	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
-	if err != nil {
-		return nil, err
-	}
-
-	// Write to both stderr and log file
-	multiWriter := io.MultiWriter(os.Stderr, logFile)
-	log.SetOutput(multiWriter)
-	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds)
-
-	return logFile, nil
+	slog.SetDefault(logger)
+	log.SetFlags(0)
+	log.SetOutput(mcper.StdLogWriter(logger))
+	return closer, err
 }
 
 func runServe(cmd *cobra.Command, args []string) error {
-	// Setup file logging
-	logFile, err := setupLogging()
+	level, err := mcper.ParseLogLevel(serveLogLevel)
 	if err != nil {
-		log.Printf("Warning: failed to setup file logging: %v", err)
-	} else {
-		defer logFile.Close()
+		return err
+	}
+	logFile, err := setupLogging(level, serveLogJSON)
+	defer logFile.Close()
+	if err != nil {
+		slog.Warn("Failed to set up file logging", "err", err)
 	}
 
 	// Log startup info
-	log.Printf("=== mcper serve starting ===")
-	log.Printf("Version: %s", mcper.Version)
-	log.Printf("OS/Arch: %s/%s", runtime.GOOS, runtime.GOARCH)
-	log.Printf("Go version: %s", runtime.Version())
-	log.Printf("Time: %s", time.Now().Format(time.RFC3339))
-	log.Printf("PID: %d", os.Getpid())
-	if cwd, err := os.Getwd(); err == nil {
-		log.Printf("Working directory: %s", cwd)
-	}
+	cwd, _ := os.Getwd()
+	slog.Info("mcper serve starting",
+		"version", mcper.Version,
+		"os", runtime.GOOS,
+		"arch", runtime.GOARCH,
+		"go_version", runtime.Version(),
+		"pid", os.Getpid(),
+		"cwd", cwd)
 
 	// Parse config
 	config, err := mcper.ParseConfig([]byte(configJSON))
 	if err != nil {
-		log.Printf("ERROR: failed to parse config: %v", err)
+		slog.Error("Failed to parse config", "err", err)
 		return fmt.Errorf("failed to parse config: %w", err)
 	}
-	log.Printf("Config: %s", configJSON)
+	for i, plugin := range config.Plugins {
+		slog.Info("Configured plugin", "index", i, "source", plugin.Source)
+	}
 
-	log.Printf("Loading %d plugin(s)...", len(config.Plugins))
+	slog.Info("Loading plugins", "count", len(config.Plugins))
 
 	// Check for cloud credentials and configure proxy
-	var proxyURL string
-	var apiKey string
-	creds, err := mcper.LoadCredentials()
-	if err == nil && creds.IsValid() {
-		proxyURL = creds.GetProxyURL()
-		apiKey = creds.APIKey
-		log.Printf("Logged in as %s, using cloud proxy for OAuth tokens: %s", creds.UserEmail, proxyURL)
+	profile, err := selectedProfile(config.Profile)
+	if err != nil {
+		return err
+	}
+	// Restart WASM plugins that exit, reporting through a native tool
+	supervisors := &supervisorSet{}
 
-		// Fetch remote servers from mcper-cloud
-		remoteServers, err := mcper.FetchRemoteServers(creds)
-		if err != nil {
-			log.Printf("Warning: failed to fetch remote servers: %v", err)
-		} else if len(remoteServers) > 0 {
-			log.Printf("Fetched %d remote server(s) from mcper-cloud", len(remoteServers))
-			for _, srv := range remoteServers {
-				// Add remote servers to config with IsCloud flag
-				config.Plugins = append(config.Plugins, mcper.PluginConfig{
-					Source:  srv.URL,
-					IsCloud: true, // Mark as fetched from mcper-cloud
-				})
-				log.Printf("  - %s (%s): %s", srv.Name, srv.Type, srv.URL)
+	// Everything that talks to mcper-cloud reads the current key from
+	// `keys`, so a key renewed in the background is used without a restart.
+	// WASM plugins get it in MCPER_AUTH_TOKEN when they start, so running
+	// instances are replaced to pick it up.
+	var proxyURL string
+	var keys *mcper.KeyRefresher
+	creds, err := mcper.LoadProfileCredentials(profile)
+	if err == nil && creds.APIKey != "" {
+		keys = mcper.NewKeyRefresher(creds, func(fresh *mcper.Credentials) {
+			mcper.RegisterSecret(fresh.APIKey)
+			slog.Info("Renewed API key", "profile", profile, "expires_at", fresh.ExpiresAt.Format(time.RFC3339))
+			supervisors.recycle()
+		}, func(err error) {
+			slog.Warn("Failed to renew API key", "profile", profile, "err", err)
+		})
+		// IsValid gives up on a key five minutes before it expires; renew
+		// one that close, or already expired, before the plugins load.
+		if !creds.IsValid() && !creds.ExpiresAt.IsZero() {
+			if err := keys.Refresh(context.Background()); err != nil {
+				slog.Warn("Failed to renew API key", "profile", profile, "err", err)
 			}
+			creds = keys.Credentials()
 		}
+	}
+	if creds.IsValid() {
+		proxyURL = creds.GetProxyURL()
+		mcper.RegisterSecret(creds.APIKey)
+		slog.Info("Logged in to mcper-cloud, using cloud proxy for OAuth tokens", "user", creds.UserEmail, "profile", profile, "proxy", proxyURL)
+		addRemoteServers(config, creds)
 	} else {
-		log.Printf("Not logged in to mcper-cloud, plugins will use direct HTTP (env var auth)")
+		keys = nil
+		slog.Info("Not logged in to mcper-cloud, plugins will use direct HTTP (env var auth)", "profile", profile)
 	}
 
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
 	defer cancel()
 
-	// Create WASM host with optional proxy
-	wasmHost := wasmhost.NewLoggingWasmHost(ctx)
+	if keys != nil {
+		go keys.Run(ctx)
+	}
+
+	// Create WASM host, persisting compiled plugins across runs
+	var wasmHost *wasmhost.WasmHost
+	if compiledDir, err := mcper.CompiledCacheDir(); err == nil {
+		wasmHost = wasmhost.NewWasmHostWithCacheDir(ctx, compiledDir)
+	} else {
+		slog.Warn("Compiled cache disabled", "err", err)
+		wasmHost = wasmhost.NewLoggingWasmHost(ctx)
+	}
 	defer wasmHost.Close(ctx)
 
-	// Create MCP server
-	mcpServer := mcp.NewServer(&mcp.Implementation{Name: "mcper", Version: mcper.Version}, nil)
+	// Create MCP server. Resources and prompts are advertised up front since
+	// plugins that load late (retries, --watch) may add them after clients
+	// have initialized.
+	mcpServer := mcp.NewServer(&mcp.Implementation{Name: "mcper", Version: mcper.Version}, &mcp.ServerOptions{
+		HasPrompts:   true,
+		HasResources: true,
+	})
 
 	// Register native mcper tools (registry, cache, etc.)
 	registerNativeTools(mcpServer)
-	log.Printf("Registered native mcper tools")
+	slog.Info("Registered native mcper tools")
+
+	// Report the WASM plugin supervisors through a native tool
+	registerSupervisorTool(mcpServer, supervisors)
+
+	// Plugins that fail to load are retried in the background instead of
+	// taking the server down; their tools appear once they load.
+	health := &healthSet{}
+	registerHealthTool(mcpServer, health)
+
+	// Export metrics when asked to
+	var metrics *serveMetrics
+	if serveMetricsAddr != "" {
+		metrics = newServeMetrics(supervisors)
+		go func() {
+			if err := serveMetricsHTTP(ctx, metrics, serveMetricsAddr); err != nil {
+				slog.Warn("Metrics endpoint stopped", "err", err)
+			}
+		}()
+	}
 
-	// Track sessions for cleanup
-	sessions := make(map[string]*mcp.ClientSession)
+	// Trace tool calls when an exporter is configured
+	tracer, err := newServeTracer(serveTraceOTLP, serveTraceFile)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), listenShutdownWindow)
+		defer cancel()
+		tracer.Shutdown(shutdownCtx)
+	}()
+
+	// Record every forwarded tool call in ~/.mcper/audit.jsonl
+	var audit *mcper.AuditLog
+	if auditPath, err := mcper.GetAuditPath(); err == nil {
+		audit, err = mcper.OpenAuditLog(auditPath)
+		if err != nil {
+			slog.Warn("Tool calls will not be audited", "err", err)
+		}
+	} else {
+		slog.Warn("Tool calls will not be audited", "err", err)
+	}
 
 	// Load and run each plugin
-	for i, plugin := range config.Plugins {
-		name := fmt.Sprintf("plugin-%d", i)
-		log.Printf("Loading plugin %d: %s", i, plugin.Source)
+	plugins := &pluginSet{
+		ctx:         ctx,
+		server:      mcpServer,
+		host:        wasmHost,
+		supervisors: supervisors,
+		health:      health,
+		progress:    &progressRelay{},
+		audit:       audit,
+		metrics:     metrics,
+		tracer:      tracer,
+		profile:     profile,
+		keys:        keys,
+		proxyURL:    proxyURL,
+	}
+	plugins.apply(config.Plugins)
 
-		parsed, err := mcper.ParsePluginSource(plugin.Source)
+	if serveWatch {
+		cwd, err := os.Getwd()
 		if err != nil {
-			log.Printf("ERROR: failed to parse plugin source %s: %v", plugin.Source, err)
-			return fmt.Errorf("failed to parse plugin source %s: %w", plugin.Source, err)
+			return err
 		}
-		log.Printf("Plugin %d parsed: type=%d name=%s version=%s", i, parsed.Type, parsed.Name, parsed.Version)
-
-		// Cloud plugins are forwarded to mcper-cloud, not run locally
-		if plugin.IsCloud {
-			log.Printf("Loading cloud plugin: %s (forwarding to mcper-cloud)", plugin.Source)
-			session, err := loadCloudPlugin(ctx, mcpServer, name, plugin, creds)
-			if err != nil {
-				log.Printf("ERROR: failed to load cloud plugin %s: %v", plugin.Source, err)
-				return fmt.Errorf("failed to load cloud plugin %s: %w", plugin.Source, err)
-			}
-			sessions[name] = session
-			log.Printf("Successfully loaded cloud plugin: %s", plugin.Source)
-			continue // Skip local WASM handling
-		}
-
-		switch parsed.Type {
-		case mcper.PluginTypeLocal:
-			// Local WASM file
-			log.Printf("Loading local WASM: %s", plugin.Source)
-			session, err := loadLocalWASM(ctx, wasmHost, mcpServer, name, plugin, parsed, creds, proxyURL, apiKey)
-			if err != nil {
-				log.Printf("ERROR: failed to load local WASM %s: %v", plugin.Source, err)
-				return fmt.Errorf("failed to load local WASM %s: %w", plugin.Source, err)
-			}
-			sessions[name] = session
-			log.Printf("Successfully loaded local WASM: %s", plugin.Source)
+		go watchStartScript(ctx, filepath.Join(cwd, ".mcper", mcper.StartScriptName), plugins.apply)
+	}
 
-		case mcper.PluginTypeWASM:
-			// Remote WASM - check cache first
-			log.Printf("Loading remote WASM: %s", plugin.Source)
-			session, err := loadRemoteWASM(ctx, wasmHost, mcpServer, name, plugin, parsed, creds, proxyURL, apiKey)
-			if err != nil {
-				log.Printf("ERROR: failed to load remote WASM %s: %v", plugin.Source, err)
-				return fmt.Errorf("failed to load remote WASM %s: %w", plugin.Source, err)
-			}
-			sessions[name] = session
-			log.Printf("Successfully loaded remote WASM: %s", plugin.Source)
+	slog.Info("Starting MCP server", "plugins", len(config.Plugins))
 
-		case mcper.PluginTypeHTTP:
-			// HTTP MCP server
-			log.Printf("Loading HTTP plugin: %s", plugin.Source)
-			session, err := loadHTTPPlugin(ctx, mcpServer, name, plugin)
-			if err != nil {
-				log.Printf("ERROR: failed to load HTTP plugin %s: %v", plugin.Source, err)
-				return fmt.Errorf("failed to load HTTP plugin %s: %w", plugin.Source, err)
-			}
-			sessions[name] = session
-			log.Printf("Successfully loaded HTTP plugin: %s", plugin.Source)
-
-		default:
-			log.Printf("ERROR: unsupported plugin type for %s", plugin.Source)
-			return fmt.Errorf("unsupported plugin type for %s", plugin.Source)
-		}
+	if serveListen != "" {
+		return serveHTTP(ctx, mcpServer, serveListen)
 	}
 
-	log.Printf("Starting MCP server with %d plugins", len(config.Plugins))
-
 	// Run MCP server on stdin/stdout
 	transport := mcp.NewIOTransport(stdinoutRWC{})
 	return mcpServer.Run(ctx, transport)
 }
 
 // loadLocalWASM loads a local WASM file and registers its tools
-func loadLocalWASM(ctx context.Context, host *wasmhost.WasmHost, server *mcp.Server, name string, plugin mcper.PluginConfig, parsed *mcper.ParsedPlugin, creds *mcper.Credentials, proxyURL, apiKey string) (*mcp.ClientSession, error) {
+func loadLocalWASM(ctx context.Context, host *wasmhost.WasmHost, supervisors *supervisorSet, scope *pluginScope, name string, plugin mcper.PluginConfig, parsed *mcper.ParsedPlugin, keys *mcper.KeyRefresher, proxyURL string) (*mcp.ClientSession, error) {
 	// Resolve source path
 	source := plugin.Source
 	if strings.HasPrefix(source, "./") {
@@ -246,11 +315,11 @@ func loadLocalWASM(ctx context.Context, host *wasmhost.WasmHost, server *mcp.Ser
 	pluginName := strings.TrimSuffix(baseName, ".wasm")
 	pluginName = strings.TrimPrefix(pluginName, "plugin-")
 
-	return runWASMModule(ctx, host, server, name, pluginName, wasmBytes, plugin, parsed, creds, proxyURL, apiKey)
+	return runWASMModule(ctx, host, supervisors, scope, name, pluginName, wasmBytes, plugin, parsed, keys, proxyURL)
 }
 
 // loadRemoteWASM loads a remote WASM file from cache or downloads it
-func loadRemoteWASM(ctx context.Context, host *wasmhost.WasmHost, server *mcp.Server, name string, plugin mcper.PluginConfig, parsed *mcper.ParsedPlugin, creds *mcper.Credentials, proxyURL, apiKey string) (*mcp.ClientSession, error) {
+func loadRemoteWASM(ctx context.Context, host *wasmhost.WasmHost, supervisors *supervisorSet, scope *pluginScope, name string, plugin mcper.PluginConfig, parsed *mcper.ParsedPlugin, keys *mcper.KeyRefresher, proxyURL string) (*mcp.ClientSession, error) {
 	// Check cache first
 	entry, err := mcper.GetCacheEntry(parsed)
 	if err != nil {
@@ -269,7 +338,7 @@ func loadRemoteWASM(ctx context.Context, host *wasmhost.WasmHost, server *mcp.Se
 		// Verify integrity
 		valid, err := mcper.VerifyCache(entry)
 		if err != nil || !valid {
-			log.Printf("Cache integrity check failed for %s, re-downloading", plugin.Source)
+			slog.Warn("Cache integrity check failed, re-downloading", "source", plugin.Source)
 			entry = nil
 		}
 	}
@@ -277,7 +346,7 @@ func loadRemoteWASM(ctx context.Context, host *wasmhost.WasmHost, server *mcp.Se
 	if entry == nil {
 		// Download from registry
 		url := parsed.RegistryURL()
-		log.Printf("Downloading plugin from %s", url)
+		slog.Info("Downloading plugin", "url", url)
 
 		resp, err := http.Get(url)
 		if err != nil {
@@ -303,7 +372,7 @@ func loadRemoteWASM(ctx context.Context, host *wasmhost.WasmHost, server *mcp.Se
 		// Save to cache
 		_, err = mcper.SaveToCache(parsed, wasmBytes, plugin.Permissions, envVars)
 		if err != nil {
-			log.Printf("Warning: failed to cache WASM: %v", err)
+			slog.Warn("Failed to cache WASM", "err", err)
 		}
 	}
 
@@ -313,7 +382,7 @@ func loadRemoteWASM(ctx context.Context, host *wasmhost.WasmHost, server *mcp.Se
 		pluginName = name // fallback to internal name
 	}
 
-	return runWASMModule(ctx, host, server, name, pluginName, wasmBytes, plugin, parsed, creds, proxyURL, apiKey)
+	return runWASMModule(ctx, host, supervisors, scope, name, pluginName, wasmBytes, plugin, parsed, keys, proxyURL)
 }
 
 // resolveCapContext decides whether this plugin should run in cap-proxy mode.
@@ -322,16 +391,17 @@ func loadRemoteWASM(ctx context.Context, host *wasmhost.WasmHost, server *mcp.Se
 // force_legacy_proxy provides emergency rollback per the plan. Manifest fetch
 // failure (404, parse error, etc.) is non-fatal — the plugin falls back to
 // legacy proxy.
-func resolveCapContext(ctx context.Context, pluginName string, plugin mcper.PluginConfig, parsed *mcper.ParsedPlugin, creds *mcper.Credentials) *CapContext {
+func resolveCapContext(ctx context.Context, pluginName string, plugin mcper.PluginConfig, parsed *mcper.ParsedPlugin, keys *mcper.KeyRefresher) *CapContext {
 	if os.Getenv("MCPER_USE_CAP_PROXY") != "true" {
 		return nil
 	}
 	if plugin.ForceLegacyProxy {
-		log.Printf("cap-proxy: %s pinned to legacy via force_legacy_proxy", pluginName)
+		slog.Info("cap-proxy: pinned to legacy via force_legacy_proxy", "plugin", pluginName)
 		return nil
 	}
-	if creds == nil || !creds.IsValid() {
-		log.Printf("cap-proxy: %s skipped (not logged in)", pluginName)
+	creds := keys.Credentials()
+	if !creds.IsValid() {
+		slog.Info("cap-proxy: skipped, not logged in", "plugin", pluginName)
 		return nil
 	}
 	if parsed == nil || parsed.Type != mcper.PluginTypeWASM {
@@ -345,7 +415,7 @@ func resolveCapContext(ctx context.Context, pluginName string, plugin mcper.Plug
 	// reliably 503 manifest_missing. Until both sides agree on a versioned
 	// layout (planned alongside the PR 10/11 rollout), fall back.
 	if parsed.Version != "" && parsed.Version != "latest" {
-		log.Printf("cap-proxy: %s pinned to version %q; cap-proxy only supports 'latest' in v1, falling back", pluginName, parsed.Version)
+		slog.Info("cap-proxy: only 'latest' is supported in v1, falling back", "plugin", pluginName, "version", parsed.Version)
 		return nil
 	}
 	manifestURL := parsed.ManifestURL()
@@ -354,7 +424,7 @@ func resolveCapContext(ctx context.Context, pluginName string, plugin mcper.Plug
 	}
 	fetched, err := mcper.FetchManifestV2(ctx, manifestURL)
 	if err != nil {
-		log.Printf("cap-proxy: %s manifest fetch failed (%v); falling back to legacy", pluginName, err)
+		slog.Warn("cap-proxy: manifest fetch failed, falling back to legacy", "plugin", pluginName, "err", err)
 		return nil
 	}
 	// plugin_version sent to cap-mint MUST be the URL-path version (e.g.
@@ -366,10 +436,10 @@ func resolveCapContext(ctx context.Context, pluginName string, plugin mcper.Plug
 	if pluginVersion == "" {
 		pluginVersion = "latest"
 	}
-	log.Printf("cap-proxy: %s enabled (manifest %s, plugin_version %s, body_version %s)",
-		pluginName, fetched.Hash, pluginVersion, fetched.Manifest.Version)
+	slog.Info("cap-proxy: enabled", "plugin", pluginName, "manifest", fetched.Hash,
+		"plugin_version", pluginVersion, "body_version", fetched.Manifest.Version)
 	return &CapContext{
-		Cloud:         mcper.NewCloudClient(creds),
+		Cloud:         mcper.NewCloudClientFrom(keys.Credentials),
 		Manifest:      fetched.Manifest,
 		PluginVersion: pluginVersion,
 		ManifestHash:  fetched.Hash,
@@ -377,90 +447,334 @@ func resolveCapContext(ctx context.Context, pluginName string, plugin mcper.Plug
 	}
 }
 
-// runWASMModule loads and runs a WASM module, registering its tools with the MCP server
-func runWASMModule(ctx context.Context, host *wasmhost.WasmHost, server *mcp.Server, name string, pluginName string, wasmBytes []byte, plugin mcper.PluginConfig, parsed *mcper.ParsedPlugin, creds *mcper.Credentials, proxyURL, apiKey string) (*mcp.ClientSession, error) {
-	// Load the module
-	if err := host.LoadModule(ctx, name, wasmBytes); err != nil {
-		return nil, fmt.Errorf("failed to load WASM module: %w", err)
+// resolveManifest returns the plugin's v2 manifest: the one already fetched
+// for cap-proxy mode, or for registry plugins a fresh fetch. It returns nil
+// for local plugins and when the manifest is unavailable.
+func resolveManifest(ctx context.Context, pluginName string, parsed *mcper.ParsedPlugin, capCtx *CapContext) *mcper.PluginInfoV2 {
+	if capCtx != nil {
+		return capCtx.Manifest
+	}
+	if parsed == nil || parsed.Type != mcper.PluginTypeWASM {
+		return nil
+	}
+	fetched, err := mcper.FetchManifestV2(ctx, parsed.ManifestURL())
+	if err != nil {
+		slog.Info("Manifest unavailable", "plugin", pluginName, "err", err)
+		return nil
+	}
+	return fetched.Manifest
+}
+
+// resolveEgressPolicy builds the network allowlist enforced by the WASM host
+// for this plugin: the union of permissions.network from the config, the
+// hosts declared in the plugin's v2 manifest, and whichever mcper-cloud
+// proxy the plugin is told to use. A plugin that declares nothing, local or
+// from the registry, gets an empty (deny-all) policy; permissions.network
+// ["*"] lifts the restriction for development builds.
+func resolveEgressPolicy(name, pluginName string, plugin mcper.PluginConfig, manifest *mcper.PluginInfoV2, capCtx *CapContext, proxyURL string) *wasmhost.EgressPolicy {
+	var hosts []string
+	if plugin.Permissions != nil {
+		hosts = append(hosts, plugin.Permissions.Network...)
+	}
+
+	if manifest != nil {
+		hosts = append(hosts, manifest.EgressHosts()...)
+	}
+	if len(hosts) == 0 {
+		slog.Info("Plugin declares no network access; add hosts to permissions.network, or \"*\", to allow them", "plugin", pluginName)
+	}
+
+	// The plugin reaches upstream APIs through the cloud proxy in both cap
+	// and legacy mode, so its host has to be reachable.
+	for _, u := range []string{proxyURL, capProxyURL(capCtx)} {
+		if u == "" {
+			continue
+		}
+		if parsedURL, err := url.Parse(u); err == nil && parsedURL.Host != "" {
+			hosts = append(hosts, parsedURL.Host)
+		}
+	}
+	return wasmhost.NewEgressPolicy(name, hosts)
+}
+
+// resolveMounts turns permissions.filesystem into host preopens. Registry
+// plugins get no filesystem access unless the config asks for it; local
+// plugins default to the project directory. Mounts that expose the root,
+// a system directory or the home directory are allowed but logged as a
+// warning, since they defeat the point of the sandbox.
+func resolveMounts(pluginName string, plugin mcper.PluginConfig, parsed *mcper.ParsedPlugin) ([]wasmhost.Mount, error) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	mounts, err := plugin.Permissions.Mounts(cwd)
+	if err != nil {
+		return nil, err
+	}
+	if plugin.Permissions == nil || plugin.Permissions.Filesystem == nil {
+		if parsed != nil && parsed.Type == mcper.PluginTypeLocal {
+			mounts = []mcper.Mount{{HostPath: cwd, GuestPath: cwd}}
+		}
+	}
+
+	homeDir, _ := os.UserHomeDir()
+	result := make([]wasmhost.Mount, 0, len(mounts))
+	for _, m := range mounts {
+		if m.IsBroad(homeDir) {
+			slog.Warn("Plugin mount gives it access to far more than the project; narrow permissions.filesystem if possible", "plugin", pluginName, "mount", m)
+		}
+		result = append(result, wasmhost.Mount{
+			HostPath:  m.HostPath,
+			GuestPath: m.GuestPath,
+			ReadOnly:  m.ReadOnly,
+		})
+	}
+	if len(result) == 0 {
+		slog.Info("Plugin has no filesystem access", "plugin", pluginName)
+	}
+	return result, nil
+}
+
+// moduleRunner starts instances of a loaded WASM module; *wasmhost.WasmHost
+// is one.
+type moduleRunner interface {
+	RunModule(ctx context.Context, name string, opts wasmhost.RunOptions) (*wasmhost.Instance, error)
+	RunModuleWithLogging(ctx context.Context, name string, opts wasmhost.RunOptions) (*wasmhost.Instance, error)
+}
+
+// wasmStart returns the func that starts an instance of module `name` and
+// connects to it, used for the first start, restarts, pool growth and lazy
+// starts alike. The plugin's secrets and the legacy proxy's API key are
+// resolved on each call, so a new instance gets the current ones.
+func wasmStart(host moduleRunner, scope *pluginScope, name string, opts wasmhost.RunOptions, pluginEnv map[string]string, proxyURL string, keys *mcper.KeyRefresher) func(ctx context.Context) (*mcp.ClientSession, *wasmhost.Instance, error) {
+	return func(ctx context.Context) (*mcp.ClientSession, *wasmhost.Instance, error) {
+		secretEnv, err := resolvePluginEnv(ctx, pluginEnv)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to resolve plugin env: %w", err)
+		}
+		opts := opts
+		opts.Env = append(secretEnv, legacyProxyEnv(proxyURL, keys)...)
+
+		run := host.RunModule
+		if slog.Default().Enabled(ctx, slog.LevelDebug) {
+			run = host.RunModuleWithLogging
+		}
+		inst, err := run(ctx, name, opts)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to run WASM module: %w", err)
+		}
+
+		// Create MCP client for the WASM module
+		wasmClient := scope.newClient(&mcp.Implementation{Name: "WASM-" + name, Version: "1.0.0"})
+		transport := mcp.NewIOTransport(inst)
+
+		session, err := wasmClient.Connect(ctx, transport, nil)
+		if err != nil {
+			inst.Close()
+			return nil, nil, fmt.Errorf("failed to connect to WASM module: %w", err)
+		}
+		return session, inst, nil
+	}
+}
+
+// legacyProxyEnv returns the env vars that send a plugin's HTTP through
+// the legacy cloud proxy at `proxyURL`, if any, with the current API key.
+func legacyProxyEnv(proxyURL string, keys *mcper.KeyRefresher) []string {
+	if proxyURL == "" {
+		return nil
+	}
+	env := []string{
+		// Standard proxy vars (for HTTP clients that support them)
+		fmt.Sprintf("HTTP_PROXY=%s", proxyURL),
+		fmt.Sprintf("HTTPS_PROXY=%s", proxyURL),
+		// MCPER-specific vars (for plugins that use custom proxy logic)
+		fmt.Sprintf("MCPER_PROXY_URL=%s", proxyURL),
+	}
+	if apiKey := keys.APIKey(); apiKey != "" {
+		env = append(env, fmt.Sprintf("MCPER_AUTH_TOKEN=%s", apiKey))
+	}
+	return env
+}
+
+func capProxyURL(capCtx *CapContext) string {
+	if capCtx == nil {
+		return ""
 	}
+	return capCtx.ProxyURL
+}
 
+// runWASMModule loads and runs a WASM module, registering its tools with the MCP server.
+// When the plugin's v2 manifest lists its tools, they are registered from the
+// manifest and the module is only compiled and started on first use; the
+// returned session is then nil.
+func runWASMModule(ctx context.Context, host *wasmhost.WasmHost, supervisors *supervisorSet, scope *pluginScope, name string, pluginName string, wasmBytes []byte, plugin mcper.PluginConfig, parsed *mcper.ParsedPlugin, keys *mcper.KeyRefresher, proxyURL string) (*mcp.ClientSession, error) {
 	// Decide cap-proxy vs legacy before building env vars — cap mode skips
 	// HTTP_PROXY / MCPER_PROXY_URL so plugins don't have two paths to fight
 	// over.
-	capCtx := resolveCapContext(ctx, pluginName, plugin, parsed, creds)
+	capCtx := resolveCapContext(ctx, pluginName, plugin, parsed, keys)
 
-	// Resolve environment variables: plugin.Env maps WASM env name -> host env name.
+	// plugin.Env maps WASM env name -> host env name or secret reference,
+	// resolved each time the module starts.
 	// In cap mode we skip ALL plugin.Env entries — the cloud /proxy injects
 	// upstream credentials, so the plugin should not see any local secrets.
 	// Leaving GITHUB_TOKEN (or similar) in the plugin process would let a
 	// rogue/buggy plugin bypass the cap path entirely.
-	var envVars []string
+	var pluginEnv map[string]string
 	if capCtx != nil && len(plugin.Env) > 0 {
-		log.Printf("cap-proxy: %s skipping %d plugin.Env entries (cloud injects credentials)", pluginName, len(plugin.Env))
+		slog.Info("cap-proxy: skipping plugin.Env entries, the cloud injects credentials", "plugin", pluginName, "entries", len(plugin.Env))
 	}
 	if capCtx == nil {
-		for wasmEnvName, hostEnvName := range plugin.Env {
-			value := os.Getenv(hostEnvName)
-			if value != "" {
-				envVars = append(envVars, fmt.Sprintf("%s=%s", wasmEnvName, value))
-				log.Printf("Passing env var %s to WASM module", wasmEnvName)
-			} else {
-				log.Printf("Warning: env var %s (mapped from %s) is empty", wasmEnvName, hostEnvName)
-			}
-		}
+		pluginEnv = plugin.Env
 	}
 
 	// Legacy proxy env vars only when NOT in cap mode. Cap-mode plugins
 	// receive auth via _meta + /proxy header injection; legacy env vars
-	// would let a plugin bypass the cap path.
+	// would let a plugin bypass the cap path. Proxy hosts are only
+	// reachable when the proxy env vars are set.
+	egressProxyURL := ""
 	if capCtx == nil && proxyURL != "" {
-		// Standard proxy vars (for HTTP clients that support them)
-		envVars = append(envVars, fmt.Sprintf("HTTP_PROXY=%s", proxyURL))
-		envVars = append(envVars, fmt.Sprintf("HTTPS_PROXY=%s", proxyURL))
-		// MCPER-specific vars (for plugins that use custom proxy logic)
-		envVars = append(envVars, fmt.Sprintf("MCPER_PROXY_URL=%s", proxyURL))
-		if apiKey != "" {
-			envVars = append(envVars, fmt.Sprintf("MCPER_AUTH_TOKEN=%s", apiKey))
-		}
-		log.Printf("Setting legacy proxy for WASM module: %s", proxyURL)
+		egressProxyURL = proxyURL
+		slog.Info("Setting legacy proxy for WASM module", "plugin", pluginName, "proxy", proxyURL)
 	}
+	manifest := resolveManifest(ctx, pluginName, parsed, capCtx)
+	egress := resolveEgressPolicy(name, pluginName, plugin, manifest, capCtx, egressProxyURL)
 
-	// Run the module with environment variables
-	read, write, err := host.RunModuleWithLogging(ctx, name, envVars...)
+	mounts, err := resolveMounts(pluginName, plugin, parsed)
 	if err != nil {
-		return nil, fmt.Errorf("failed to run WASM module: %w", err)
+		return nil, fmt.Errorf("invalid filesystem permissions: %w", err)
 	}
 
-	// Create MCP client for the WASM module
-	wasmClient := mcp.NewClient(&mcp.Implementation{Name: "WASM-"+name, Version: "1.0.0"}, nil)
-	transport := mcp.NewIOTransport(&wasmConn{read: read, write: write})
+	minInstances, maxInstances, err := plugin.Pool.Bounds()
+	if err != nil {
+		return nil, err
+	}
 
-	session, err := wasmClient.Connect(ctx, transport, nil)
+	// The supervisor reuses start to bring the plugin back after it exits.
+	opts := wasmhost.RunOptions{
+		Egress: egress,
+		Mounts: mounts,
+	}
+	start := wasmStart(host, scope, name, opts, pluginEnv, egressProxyURL, keys)
+
+	var callTimeout time.Duration
+	if plugin.Limits != nil {
+		callTimeout = time.Duration(plugin.Limits.CallTimeout)
+	}
+	idleTimeout := time.Duration(plugin.IdleTimeout)
+	if idleTimeout == 0 {
+		idleTimeout = defaultIdleTimeout
+	}
+
+	// launch compiles the module if needed and starts it: one supervised
+	// instance, or a pool when configured. The returned func stops it again.
+	launch := func(ctx context.Context) (*mcp.ClientSession, connSource, func(), error) {
+		if !host.HasModule(name) {
+			if err := host.LoadModule(ctx, name, wasmBytes, hostLimits(plugin.Limits)); err != nil {
+				return nil, nil, nil, fmt.Errorf("failed to load WASM module: %w", err)
+			}
+		}
+		session, inst, err := start(ctx)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		conn := &pluginConn{session: session, instance: inst, callTimeout: callTimeout}
+		ctx, cancel := context.WithCancel(ctx)
+		if maxInstances > 1 {
+			pool := newPluginPool(ctx, name, pluginName, minInstances, maxInstances, idleTimeout, callTimeout, start, supervisors)
+			pool.adopt(conn)
+			go pool.run()
+			return session, pool, func() { cancel(); pool.close() }, nil
+		}
+		sup := &supervisor{name: name, pluginName: pluginName, conn: conn, start: start}
+		supervisors.add(ctx, sup)
+		return session, conn, func() { cancel(); supervisors.remove(sup); conn.close() }, nil
+	}
+
+	namespace := namespaceWASM
+	if plugin.IsCloud {
+		namespace = namespaceCloud
+	}
+
+	// With a manifest the tools are known without running the plugin.
+	// Resources and prompts aren't declared there, so they are forwarded
+	// once the first tool call has started it.
+	if manifest != nil && len(manifest.Tools) > 0 {
+		lazy := &lazyPlugin{ctx: ctx, pluginName: pluginName, idleTimeout: idleTimeout, launch: launch}
+		lazy.firstStart = func(session *mcp.ClientSession) {
+			registerForwardedResources(ctx, scope, lazy, session, namespace, pluginName)
+		}
+		var tools []*mcp.Tool
+		for _, decl := range manifest.Tools {
+			tools = append(tools, &mcp.Tool{Name: decl.Name, Description: decl.Description})
+		}
+		if err := registerForwardedTools(scope, lazy, namespace, pluginName, "Tool call failed", tools, manifestApprovals(manifest), capCtx); err != nil {
+			return nil, err
+		}
+		go lazy.run()
+		scope.onClose(lazy.close)
+		slog.Info("Registered tools from manifest; plugin starts on first use", "plugin", pluginName, "tools", len(manifest.Tools))
+		return nil, nil
+	}
+
+	session, source, stop, err := launch(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to WASM module: %w", err)
+		return nil, err
 	}
 
 	// Get tools from the WASM module
 	tools, err := session.ListTools(ctx, nil)
 	if err != nil {
+		stop()
 		return nil, fmt.Errorf("failed to list tools from WASM module: %w", err)
 	}
 
+	// Eagerly started plugins are only stopped when idle if asked to.
+	var lazy *lazyPlugin
+	if plugin.IdleTimeout > 0 {
+		lazy = &lazyPlugin{ctx: ctx, pluginName: pluginName, idleTimeout: idleTimeout, launch: launch, source: source, stop: stop, lastUsed: time.Now()}
+		source = lazy
+	}
+
 	// Register each tool with the MCP server
-	namespace := namespaceWASM
-	if plugin.IsCloud {
-		namespace = namespaceCloud
+	if err := registerForwardedTools(scope, source, namespace, pluginName, "Tool call failed", tools.Tools, manifestApprovals(manifest), capCtx); err != nil {
+		stop()
+		return nil, err
 	}
-	for _, tool := range tools.Tools {
-		registerForwardedTool(server, session, namespace, pluginName, "Tool call failed", tool, capCtx)
+	if lazy != nil {
+		go lazy.run()
+		scope.onClose(lazy.close)
+	} else {
+		scope.onClose(stop)
 	}
+	registerForwardedResources(ctx, scope, source, session, namespace, pluginName)
 
 	return session, nil
 }
 
+// addRemoteServers fetches the remote servers configured in mcper-cloud and
+// adds them to config as cloud plugins.
+func addRemoteServers(config *mcper.Config, creds *mcper.Credentials) {
+	remoteServers, err := mcper.FetchRemoteServers(creds)
+	if err != nil {
+		slog.Warn("Failed to fetch remote servers", "err", err)
+		return
+	}
+	if len(remoteServers) > 0 {
+		slog.Info("Fetched remote servers from mcper-cloud", "count", len(remoteServers))
+	}
+	for _, srv := range remoteServers {
+		// Add remote servers to config with IsCloud flag
+		config.Plugins = append(config.Plugins, mcper.PluginConfig{
+			Source:  srv.URL,
+			IsCloud: true, // Mark as fetched from mcper-cloud
+		})
+		slog.Info("Remote server", "name", srv.Name, "type", srv.Type, "url", srv.URL)
+	}
+}
+
 // loadHTTPPlugin connects to an HTTP MCP server and forwards its tools
-func loadHTTPPlugin(ctx context.Context, server *mcp.Server, name string, plugin mcper.PluginConfig) (*mcp.ClientSession, error) {
-	httpClient := mcp.NewClient(&mcp.Implementation{Name: "HTTP-"+name, Version: "1.0.0"}, nil)
+func loadHTTPPlugin(ctx context.Context, scope *pluginScope, name string, plugin mcper.PluginConfig) (*mcp.ClientSession, error) {
+	httpClient := scope.newClient(&mcp.Implementation{Name: "HTTP-" + name, Version: "1.0.0"})
 	transport := &mcp.StreamableClientTransport{Endpoint: plugin.Source}
 
 	session, err := httpClient.Connect(ctx, transport, nil)
@@ -471,6 +785,7 @@ func loadHTTPPlugin(ctx context.Context, server *mcp.Server, name string, plugin
 	// Get tools from the HTTP server
 	tools, err := session.ListTools(ctx, nil)
 	if err != nil {
+		session.Close()
 		return nil, fmt.Errorf("failed to list tools from HTTP plugin: %w", err)
 	}
 
@@ -478,9 +793,13 @@ func loadHTTPPlugin(ctx context.Context, server *mcp.Server, name string, plugin
 	pluginName := name
 
 	// Register each tool with the MCP server
-	for _, tool := range tools.Tools {
-		registerForwardedTool(server, session, namespaceHTTP, pluginName, "Tool call failed", tool, nil)
+	conn := &pluginConn{session: session}
+	if err := registerForwardedTools(scope, conn, namespaceHTTP, pluginName, "Tool call failed", tools.Tools, nil, nil); err != nil {
+		session.Close()
+		return nil, err
 	}
+	scope.onClose(func() { session.Close() })
+	registerForwardedResources(ctx, scope, conn, session, namespaceHTTP, pluginName)
 
 	return session, nil
 }
@@ -488,26 +807,27 @@ func loadHTTPPlugin(ctx context.Context, server *mcp.Server, name string, plugin
 // loadCloudPlugin connects to mcper-cloud's MCP endpoint and forwards its tools
 // This is used for plugins with IsCloud: true - tool calls are forwarded to the cloud
 // instead of running WASM locally
-func loadCloudPlugin(ctx context.Context, server *mcp.Server, name string, plugin mcper.PluginConfig, creds *mcper.Credentials) (*mcp.ClientSession, error) {
-	if creds == nil || !creds.IsValid() {
+func loadCloudPlugin(ctx context.Context, scope *pluginScope, name string, plugin mcper.PluginConfig, keys *mcper.KeyRefresher) (*mcp.ClientSession, error) {
+	creds := keys.Credentials()
+	if !creds.IsValid() {
 		return nil, fmt.Errorf("valid credentials required for cloud plugins")
 	}
 
 	// Connect to mcper-cloud's MCP endpoint (Streamable HTTP transport)
 	mcpEndpointURL := creds.CloudURL + "/mcp"
-	log.Printf("Connecting to cloud MCP endpoint: %s", mcpEndpointURL)
+	slog.Info("Connecting to cloud MCP endpoint", "url", mcpEndpointURL)
 
 	// Create HTTP client with Bearer token auth
 	httpClient := &http.Client{
 		Transport: &bearerAuthRoundTripper{
-			base:  http.DefaultTransport,
-			token: creds.APIKey,
+			base: http.DefaultTransport,
+			keys: keys,
 		},
 		Timeout: 5 * time.Minute, // Long timeout for streaming
 	}
 
 	// Create MCP client for the cloud server
-	cloudClient := mcp.NewClient(&mcp.Implementation{Name: "Cloud-"+name, Version: "1.0.0"}, nil)
+	cloudClient := scope.newClient(&mcp.Implementation{Name: "Cloud-" + name, Version: "1.0.0"})
 	transport := &mcp.StreamableClientTransport{
 		Endpoint:   mcpEndpointURL,
 		HTTPClient: httpClient,
@@ -533,21 +853,74 @@ func loadCloudPlugin(ctx context.Context, server *mcp.Server, name string, plugi
 	}
 
 	// Register each tool with the MCP server
-	for _, tool := range tools.Tools {
-		registerForwardedTool(server, session, namespaceCloud, pluginName, "Cloud tool call failed", tool, nil)
+	conn := &pluginConn{session: session}
+	if err := registerForwardedTools(scope, conn, namespaceCloud, pluginName, "Cloud tool call failed", tools.Tools, nil, nil); err != nil {
+		session.Close()
+		return nil, err
 	}
+	scope.onClose(func() { session.Close() })
+	registerForwardedResources(ctx, scope, conn, session, namespaceCloud, pluginName)
 
-	log.Printf("Successfully connected to cloud plugin with %d tools", len(tools.Tools))
+	slog.Info("Connected to cloud plugin", "tools", len(tools.Tools))
 	return session, nil
 }
 
-// registerForwardedTool installs a tool on `server` that proxies calls
-// through to `session` (a plugin/WASM/cloud client session). Tools are
-// namespaced as `<namespace>_<pluginName>_<toolName>` to comply with
-// Claude.ai connector tool name pattern: ^[a-zA-Z0-9_-]{1,64}$.
+// registerForwardedTools forwards the tools the plugin's tool rules let
+// through, except those whose approval mode is "deny". `declared` holds the
+// approval modes from the plugin's manifest, by tool name. If two tools
+// would end up with the same name, or one with the name of a tool already
+// forwarded from another plugin, it registers none of them and returns an
+// error.
+func registerForwardedTools(scope *pluginScope, source connSource, namespace, pluginName, errPrefix string, tools []*mcp.Tool, declared map[string]string, capCtx *CapContext) error {
+	if capCtx != nil {
+		// mcper-cloud enforces the manifest's modes when minting caps.
+		declared = nil
+	}
+	var forwarded []*mcp.Tool
+	var modes []string
+	var names []forwardedName
+	seen := make(map[string]string) // forwarded name -> plugin tool name
+	for _, tool := range tools {
+		if !scope.rules.Forwards(tool.Name) {
+			slog.Info("Not forwarding tool", "namespace", namespace, "tool", tool.Name, "plugin", pluginName)
+			continue
+		}
+		mode := scope.rules.ApprovalMode(tool.Name, declared[tool.Name])
+		if mode == mcper.ApprovalDeny {
+			slog.Info("Not forwarding tool, approval mode is deny", "namespace", namespace, "tool", tool.Name, "plugin", pluginName)
+			continue
+		}
+		name := scope.forwardedName(namespace, pluginName, tool)
+		if other, ok := seen[name.Name]; ok {
+			return fmt.Errorf("tools %s and %s from %s would both be named %s", other, tool.Name, pluginName, name.Name)
+		}
+		seen[name.Name] = tool.Name
+		names = append(names, name)
+		forwarded = append(forwarded, tool)
+		modes = append(modes, mode)
+	}
+	if err := scope.reserve(names); err != nil {
+		return err
+	}
+	for i, tool := range forwarded {
+		registerForwardedTool(scope, source, namespace, pluginName, errPrefix, tool, modes[i], capCtx)
+	}
+	return nil
+}
+
+// registerForwardedTool installs a tool in `scope` that proxies calls
+// through to a session from `source` (a plugin/WASM/cloud client session,
+// or a pool of WASM instances). Tools are
+// namespaced as `<namespace>_<pluginName>_<toolName>`, unless the plugin's
+// tool rules give them an alias or a new name, and then sanitized to
+// comply with Claude.ai connector tool name pattern: ^[a-zA-Z0-9_-]{1,64}$.
 //
 // errPrefix is prepended to the error text when the downstream session
 // returns an error (e.g. "Cloud tool call failed", "Tool call failed").
+// Calls to a tool whose approval mode is "pre" are only forwarded once the
+// calling client's user confirms them.
+// Every call is recorded in the scope's audit log, metrics and traces, if
+// it has them.
 // CapContext, when non-nil, signals registerForwardedTool to mint a cap
 // per tools/call and inject it into MCP _meta so the plugin's
 // proxy-aware HTTP client can attach X-MCPER-Cap to upstream requests.
@@ -560,93 +933,290 @@ type CapContext struct {
 	ProxyURL      string
 }
 
-func registerForwardedTool(server *mcp.Server, session *mcp.ClientSession, namespace, pluginName, errPrefix string, tool *mcp.Tool, capCtx *CapContext) {
+func registerForwardedTool(scope *pluginScope, source connSource, namespace, pluginName, errPrefix string, tool *mcp.Tool, approval string, capCtx *CapContext) {
 	inputSchema, _ := tool.InputSchema.(*jsonschema.Schema)
 	if inputSchema == nil || inputSchema.Type == "" {
 		inputSchema = &jsonschema.Schema{Type: "object", Properties: map[string]*jsonschema.Schema{}}
 	}
-	toolSession := session
 	toolName := tool.Name
-	handler := func(ctx context.Context, _ *mcp.CallToolRequest, input map[string]any) (*mcp.CallToolResult, any, error) {
+	exposed := scope.forwardedName(namespace, pluginName, tool)
+	// forward makes one call, filling in `entry` for the audit log as it
+	// goes.
+	forward := func(ctx context.Context, callReq *mcp.CallToolRequest, input map[string]any, entry *mcper.AuditEntry) *mcp.CallToolResult {
+		if approval == mcper.ApprovalPre {
+			approveCtx, span := scope.tracer.Start(ctx, "approval", mcper.SpanKindInternal)
+			outcome, err := scope.approve(approveCtx, callReq.Session, exposed, input)
+			span.SetAttributes("mcper.approval", outcome)
+			endSpan(span, err)
+			entry.Approval = outcome
+			if err != nil {
+				return errorResult(err.Error())
+			}
+		}
 		params := &mcp.CallToolParams{
 			Name:      toolName,
 			Arguments: input,
 		}
 		if capCtx != nil {
 			// PR 5: per-tools/call cap mint + _meta injection.
-			invocationID := uuid.NewString()
 			req := &mcper.CapMintRequest{
 				Plugin:        pluginName,
 				PluginVersion: capCtx.PluginVersion,
 				ManifestHash:  capCtx.ManifestHash,
 				Tool:          toolName,
-				InvocationID:  invocationID,
+				InvocationID:  entry.InvocationID,
 				Args:          input,
 			}
-			cap, pending, err := capCtx.Cloud.MintCap(ctx, req)
+			mintStart := time.Now()
+			mintCtx, span := scope.tracer.Start(ctx, "cap.mint", mcper.SpanKindClient)
+			cap, pending, err := capCtx.Cloud.MintCap(mintCtx, req)
+			endSpan(span, err)
+			scope.metrics.observeCapMint(pluginName, time.Since(mintStart))
 			if err != nil {
+				entry.Cap = capMintFailed
 				return &mcp.CallToolResult{
 					IsError: true,
 					Content: []mcp.Content{&mcp.TextContent{Text: fmt.Sprintf("cap mint: %v", err)}},
-				}, nil, nil
+				}
 			}
+			entry.Cap = capMinted
 			if pending != nil {
 				// Pre-approval: long-poll until decision.
-				cap, err = capCtx.Cloud.PollCap(ctx, pending)
+				waitStart := time.Now()
+				pollCtx, span := scope.tracer.Start(ctx, "cap.poll", mcper.SpanKindClient, "mcper.approval_id", pending.ApprovalID)
+				cap, err = capCtx.Cloud.PollCap(pollCtx, pending)
+				endSpan(span, err)
+				scope.metrics.observeApprovalWait(pluginName, time.Since(waitStart))
 				if err != nil {
+					entry.Cap = capNotApproved
 					return &mcp.CallToolResult{
 						IsError: true,
 						Content: []mcp.Content{&mcp.TextContent{Text: fmt.Sprintf("approval: %v", err)}},
-					}, nil, nil
+					}
 				}
+				entry.Cap = capApproved
 			}
 			if params.Meta == nil {
 				params.Meta = mcp.Meta{}
 			}
 			params.Meta["mcper_cap"] = cap.Cap
-			params.Meta["mcper_invocation_id"] = invocationID
+			params.Meta["mcper_invocation_id"] = entry.InvocationID
 			params.Meta["mcper_proxy_url"] = capCtx.ProxyURL
 		}
-		result, err := toolSession.CallTool(ctx, params)
+		if token := callReq.Params.GetProgressToken(); token != nil {
+			downstream, done := scope.progress.track(callReq.Session, token)
+			defer done()
+			params.SetProgressToken(downstream)
+		}
+		// The plugin's span covers waiting for an instance as well as the
+		// call itself, and is passed on so its HTTP requests join the trace.
+		ctx, span := scope.tracer.Start(ctx, "plugin.call", mcper.SpanKindClient, "mcper.plugin_tool", toolName)
+		defer span.End()
+		setTraceparent(ctx, params)
+		// Entered before acquire: a plugin started by this call may make
+		// requests of its client while starting.
+		defer scope.callers.enter(callReq.Session)()
+		conn, err := source.acquire(ctx)
+		if err != nil {
+			span.SetError(err.Error())
+			return errorResult(fmt.Sprintf("%s: %v", errPrefix, err))
+		}
+		defer source.release(conn)
+		conn.calls.Add(1)
+		defer conn.calls.Add(-1)
+		session, inst, downErr := conn.current()
+		if downErr != nil {
+			span.SetError(downErr.Error())
+			return errorResult(fmt.Sprintf("%s: %v", errPrefix, downErr))
+		}
+		callCtx := ctx
+		if conn.callTimeout > 0 {
+			var cancel context.CancelFunc
+			callCtx, cancel = context.WithTimeout(ctx, conn.callTimeout)
+			defer cancel()
+		}
+		result, err := session.CallTool(callCtx, params)
 		if err != nil {
+			span.SetError(err.Error())
+			if limitErr := conn.limitError(callCtx, inst); limitErr != nil {
+				return limitResult(errPrefix, limitErr)
+			}
+			if errors.Is(ctx.Err(), context.Canceled) {
+				conn.cancelled(inst)
+			}
 			return &mcp.CallToolResult{
 				IsError: true,
 				Content: []mcp.Content{&mcp.TextContent{Text: fmt.Sprintf("%s: %v", errPrefix, err)}},
-			}, nil, nil
+			}
 		}
 		return &mcp.CallToolResult{
 			Meta:    result.Meta,
 			Content: result.Content,
 			IsError: result.IsError,
-		}, nil, nil
+		}
+	}
+	handler := func(ctx context.Context, callReq *mcp.CallToolRequest, input map[string]any) (*mcp.CallToolResult, any, error) {
+		entry := mcper.AuditEntry{
+			InvocationID: uuid.NewString(),
+			Plugin:       scope.source,
+			Tool:         exposed.Name,
+			PluginTool:   toolName,
+			ArgsDigest:   mcper.ArgsDigest(input),
+			Approval:     approvalAllowed,
+		}
+		start := time.Now()
+		ctx, span := scope.tracer.Start(clientTraceContext(ctx, callReq), "tools/call "+exposed.Name, mcper.SpanKindServer,
+			"mcp.tool", exposed.Name, "mcper.plugin", pluginName, "mcper.invocation_id", entry.InvocationID)
+		result := forward(ctx, callReq, input, &entry)
+		elapsed := time.Since(start)
+		entry.DurationMS = elapsed.Milliseconds()
+		if result.IsError {
+			entry.Error = resultText(result)
+			span.SetError(entry.Error)
+		}
+		span.SetAttributes("mcper.approval", entry.Approval)
+		span.End()
+		scope.record(entry)
+		scope.metrics.observeCall(pluginName, exposed.Name, elapsed, result.IsError)
+		return result, nil, nil
+	}
+	if exposed.Mangled {
+		slog.Warn("Tool renamed to fit connector tool name rules", "namespace", namespace, "tool", tool.Name, "plugin", pluginName, "name", exposed.Name)
 	}
-	namespacedName := fmt.Sprintf("%s_%s_%s", namespace, pluginName, tool.Name)
-	mcp.AddTool[map[string]any, any](server, &mcp.Tool{
+	namespacedName := exposed.Name
+	scope.addTool(&mcp.Tool{
 		Name:        namespacedName,
-		Description: tool.Description,
+		Description: scope.rules.Description(tool.Name, tool.Description),
 		InputSchema: inputSchema,
 	}, handler)
-	log.Printf("Registered %s tool: %s", namespace, namespacedName)
+	slog.Info("Registered tool", "namespace", namespace, "name", namespacedName)
+}
+
+// pluginConn is the downstream end of a forwarded tool: the MCP session
+// and, for WASM plugins, the instance behind it and its per-call timeout.
+// A supervisor swaps in a new session and instance when the plugin restarts.
+type pluginConn struct {
+	mu          sync.RWMutex
+	session     *mcp.ClientSession
+	instance    *wasmhost.Instance
+	down        error // why there is no usable session, e.g. while restarting
+	callTimeout time.Duration
+	calls       atomic.Int32 // forwarded tool calls in flight
+}
+
+// current returns the live session and instance, or why there is none.
+func (c *pluginConn) current() (*mcp.ClientSession, *wasmhost.Instance, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.session, c.instance, c.down
+}
+
+// swap installs a freshly started session and instance.
+func (c *pluginConn) swap(session *mcp.ClientSession, inst *wasmhost.Instance) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.session, c.instance, c.down = session, inst, nil
+}
+
+// retireGrace is how long a replaced session is kept open for calls that
+// were already using it, unless call_timeout is longer.
+const retireGrace = time.Minute
+
+// retire closes `session`, which swap has replaced, once no calls are in
+// flight or the grace period has passed.
+func (c *pluginConn) retire(session *mcp.ClientSession) {
+	deadline := time.Now().Add(max(c.callTimeout, retireGrace))
+	for c.calls.Load() > 0 && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+	if session != nil {
+		session.Close()
+	}
 }
 
-// wasmConn implements io.ReadWriteCloser for WASM communication
-type wasmConn struct {
-	read  io.Reader
-	write io.Writer
+// close closes the current session, which also stops its instance.
+func (c *pluginConn) close() {
+	if session, _, _ := c.current(); session != nil {
+		session.Close()
+	}
 }
 
-func (w *wasmConn) Read(p []byte) (n int, err error) {
-	return w.read.Read(p)
+// setDown makes tool calls fail fast with `err` until the next swap.
+func (c *pluginConn) setDown(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.down = err
 }
 
-func (w *wasmConn) Write(p []byte) (n int, err error) {
-	return w.write.Write(p)
+// cancelled stops the WASM instance behind a tool call the client
+// cancelled, unless other calls are using it. The plugin is sent
+// notifications/cancelled either way, but a guest stuck in a host call or a
+// busy loop never reads it, so stopping the instance is the only sure way
+// to stop the work. The supervisor restarts it straight away.
+func (c *pluginConn) cancelled(inst *wasmhost.Instance) {
+	if inst != nil && c.calls.Load() == 1 {
+		inst.Kill(context.Canceled)
+	}
 }
 
-func (w *wasmConn) Close() error {
+// instanceExitGrace is how long limitError waits for a WASM instance to
+// report why it stopped after its session failed.
+const instanceExitGrace = 250 * time.Millisecond
+
+// limitError attributes a failed call to a resource limit, or returns nil.
+// A call that overran call_timeout kills the instance: a guest that cannot
+// answer in time is presumed wedged, and leaving it running would stall
+// every later call as well.
+func (c *pluginConn) limitError(ctx context.Context, inst *wasmhost.Instance) *wasmhost.LimitError {
+	if inst == nil {
+		return nil
+	}
+	if c.callTimeout > 0 && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		limitErr := &wasmhost.LimitError{Module: inst.Name(), Limit: wasmhost.LimitCallTimeout, Value: c.callTimeout.String()}
+		inst.Kill(limitErr)
+		return limitErr
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	select {
+	case <-inst.Done():
+	case <-time.After(instanceExitGrace):
+		return nil
+	}
+	var limitErr *wasmhost.LimitError
+	if errors.As(inst.Err(), &limitErr) {
+		return limitErr
+	}
 	return nil
 }
 
+// limitResult reports a limit breach as a tool error. The structured content
+// names the limit so clients can tell which setting to raise.
+func limitResult(errPrefix string, limitErr *wasmhost.LimitError) *mcp.CallToolResult {
+	return &mcp.CallToolResult{
+		IsError: true,
+		Content: []mcp.Content{&mcp.TextContent{Text: fmt.Sprintf("%s: plugin exceeded its %s limit (%s)", errPrefix, limitErr.Limit, limitErr.Value)}},
+		StructuredContent: map[string]any{
+			"error": "limit_exceeded",
+			"limit": limitErr.Limit,
+			"value": limitErr.Value,
+		},
+	}
+}
+
+// hostLimits converts a plugin's configured limits for the WASM host. The
+// call timeout is enforced per tool call by pluginConn instead.
+func hostLimits(limits *mcper.Limits) wasmhost.Limits {
+	if limits == nil {
+		return wasmhost.Limits{}
+	}
+	return wasmhost.Limits{
+		MaxMemoryPages: limits.MaxMemoryPages,
+		Lifetime:       time.Duration(limits.Lifetime),
+	}
+}
+
 // stdinoutRWC wraps stdin/stdout as an io.ReadWriteCloser
 type stdinoutRWC struct{}
 
@@ -666,11 +1236,16 @@ func (stdinoutRWC) Close() error {
 type bearerAuthRoundTripper struct {
 	base  http.RoundTripper
 	token string
+	keys  *mcper.KeyRefresher // if set, its current key replaces token
 }
 
 func (rt *bearerAuthRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	token := rt.token
+	if rt.keys != nil {
+		token = rt.keys.APIKey()
+	}
 	// Clone the request to avoid mutating the original
 	req2 := req.Clone(req.Context())
-	req2.Header.Set("Authorization", "Bearer "+rt.token)
+	req2.Header.Set("Authorization", "Bearer "+token)
 	return rt.base.RoundTrip(req2)
 }
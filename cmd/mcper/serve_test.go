@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/joshcarp/mcper/pkg/mcper"
+	"github.com/joshcarp/mcper/pkg/wasmhost"
+)
+
+// recordingRunner records the env of each instance it is asked to start,
+// then fails the start.
+type recordingRunner struct {
+	envs [][]string
+}
+
+var errNotStarted = errors.New("not started")
+
+func (r *recordingRunner) RunModule(ctx context.Context, name string, opts wasmhost.RunOptions) (*wasmhost.Instance, error) {
+	r.envs = append(r.envs, opts.Env)
+	return nil, errNotStarted
+}
+
+func (r *recordingRunner) RunModuleWithLogging(ctx context.Context, name string, opts wasmhost.RunOptions) (*wasmhost.Instance, error) {
+	return r.RunModule(ctx, name, opts)
+}
+
+func TestWASMStartUsesRenewedKey(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv(mcper.CredentialStoreEnv, mcper.CredentialStorePlaintext)
+
+	cloud := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/cli/refresh" || r.Header.Get("Authorization") != "Bearer mcper_live_old" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{
+			"api_key":    "mcper_live_new",
+			"expires_at": time.Now().Add(time.Hour).Format(time.RFC3339),
+		})
+	}))
+	defer cloud.Close()
+
+	creds := &mcper.Credentials{APIKey: "mcper_live_old", CloudURL: cloud.URL, ExpiresAt: time.Now().Add(10 * time.Minute)}
+	keys := mcper.NewKeyRefresher(creds, nil, nil)
+	runner := &recordingRunner{}
+	start := wasmStart(runner, &pluginScope{}, "plugin-0", wasmhost.RunOptions{}, nil, "https://proxy.example", keys)
+
+	if _, _, err := start(context.Background()); !errors.Is(err, errNotStarted) {
+		t.Fatalf("start = %v", err)
+	}
+	if err := keys.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// What the supervisor, the pool and lazy start do for a new instance.
+	if _, _, err := start(context.Background()); !errors.Is(err, errNotStarted) {
+		t.Fatalf("restart = %v", err)
+	}
+
+	if len(runner.envs) != 2 {
+		t.Fatalf("started %d instances, want 2", len(runner.envs))
+	}
+	for i, want := range []string{"MCPER_AUTH_TOKEN=mcper_live_old", "MCPER_AUTH_TOKEN=mcper_live_new"} {
+		if !slices.Contains(runner.envs[i], want) {
+			t.Errorf("instance %d env = %q, want %s", i, runner.envs[i], want)
+		}
+		if !slices.Contains(runner.envs[i], "MCPER_PROXY_URL=https://proxy.example") {
+			t.Errorf("instance %d env = %q, want the proxy URL", i, runner.envs[i])
+		}
+	}
+}
+
+func TestLegacyProxyEnv(t *testing.T) {
+	if env := legacyProxyEnv("", nil); env != nil {
+		t.Errorf("env without a proxy = %q", env)
+	}
+	env := legacyProxyEnv("https://proxy.example", nil)
+	want := []string{"HTTP_PROXY=https://proxy.example", "HTTPS_PROXY=https://proxy.example", "MCPER_PROXY_URL=https://proxy.example"}
+	if !slices.Equal(env, want) {
+		t.Errorf("env when logged out = %q, want %q", env, want)
+	}
+}
+
+func TestResolveEgressPolicy(t *testing.T) {
+	local := mcper.PluginConfig{Source: "./plugin.wasm"}
+	open := mcper.PluginConfig{Source: "./plugin.wasm", Permissions: &mcper.Permissions{Network: []string{"*"}}}
+
+	tests := []struct {
+		name     string
+		plugin   mcper.PluginConfig
+		proxyURL string
+		allowed  []string
+		denied   []string
+	}{
+		{name: "local without network", plugin: local, denied: []string{"api.github.com"}},
+		{name: "proxy stays reachable", plugin: local, proxyURL: "https://proxy.mcper.dev", allowed: []string{"proxy.mcper.dev"}, denied: []string{"api.github.com"}},
+		{name: "wildcard", plugin: open, allowed: []string{"api.github.com"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := resolveEgressPolicy("plugin-0", "plugin", tt.plugin, nil, nil, tt.proxyURL)
+			if policy == nil {
+				t.Fatal("no egress policy, want one")
+			}
+			for _, host := range tt.allowed {
+				if !policy.AllowsHost(host) {
+					t.Errorf("%s denied, want allowed", host)
+				}
+			}
+			for _, host := range tt.denied {
+				if policy.AllowsHost(host) {
+					t.Errorf("%s allowed, want denied", host)
+				}
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"time"
@@ -17,12 +18,13 @@ var statusCmd = &cobra.Command{
 
 Displays:
 - Login status and user email
-- Cloud server URL
+- The mcper-cloud profile and its server URL
 - Connected OAuth providers
 - Local plugin count
 
 Example:
-  mcper status`,
+  mcper status
+  mcper status --profile staging`,
 	RunE: runStatus,
 }
 
@@ -34,10 +36,20 @@ type ProviderStatus struct {
 }
 
 func runStatus(cmd *cobra.Command, args []string) error {
+	profile, err := selectedProfile(projectProfile())
+	if err != nil {
+		return err
+	}
+
 	// Load credentials
-	creds, err := mcper.LoadCredentials()
+	creds, err := mcper.LoadProfileCredentials(profile)
 	if err != nil {
-		fmt.Println("Status: Not logged in")
+		if errors.Is(err, mcper.ErrNotLoggedIn) {
+			fmt.Println("Status: Not logged in")
+		} else {
+			fmt.Printf("Status: Not logged in (%v)\n", err)
+		}
+		fmt.Printf("Profile: %s\n", profile)
 		fmt.Println("\nUse 'mcper login' to connect to mcper-cloud")
 		return nil
 	}
@@ -45,13 +57,18 @@ func runStatus(cmd *cobra.Command, args []string) error {
 	if !creds.IsValid() {
 		fmt.Println("Status: Logged in (credentials expired)")
 		fmt.Printf("User: %s\n", creds.UserEmail)
+		fmt.Printf("Profile: %s\n", profile)
 		fmt.Println("\nUse 'mcper login' to refresh your credentials")
 		return nil
 	}
 
 	fmt.Println("Status: Logged in")
 	fmt.Printf("User: %s\n", creds.UserEmail)
+	fmt.Printf("Profile: %s\n", profile)
 	fmt.Printf("Cloud: %s\n", creds.CloudURL)
+	if store, err := mcper.CredentialStoreForProfile(profile); err == nil {
+		fmt.Printf("Credentials: %s\n", store)
+	}
 
 	if !creds.ExpiresAt.IsZero() {
 		remaining := time.Until(creds.ExpiresAt)
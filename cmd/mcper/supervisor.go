@@ -0,0 +1,340 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/jsonschema-go/jsonschema"
+	"github.com/joshcarp/mcper/pkg/mcper"
+	"github.com/joshcarp/mcper/pkg/wasmhost"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// Restart policy for supervised WASM plugins. Restarts back off
+// exponentially; an instance that stays up for stableRunTime resets the
+// backoff. crashLoopThreshold exits within crashLoopWindow open the circuit
+// breaker, which pauses restarts for circuitCooldown and then allows a
+// single trial start (half-open) before reopening on the next crash.
+const (
+	restartBackoffMin  = 500 * time.Millisecond
+	restartBackoffMax  = 30 * time.Second
+	stableRunTime      = time.Minute
+	crashLoopThreshold = 5
+	crashLoopWindow    = 2 * time.Minute
+	circuitCooldown    = 5 * time.Minute
+)
+
+// Supervisor states reported by mcper/native/plugin_supervisor.
+const (
+	stateRunning     = "running"
+	stateRestarting  = "restarting"
+	stateCircuitOpen = "circuit_open"
+	stateStopped     = "stopped"
+)
+
+// supervisor restarts a WASM plugin when its instance exits and swaps the
+// new session into the plugin's forwarded tools.
+type supervisor struct {
+	name       string // module name in the WasmHost, e.g. "plugin-0"
+	pluginName string
+	conn       *pluginConn
+	start      func(ctx context.Context) (*mcp.ClientSession, *wasmhost.Instance, error)
+
+	now          func() time.Time  // replaced in tests
+	restartCount *mcper.CounterVec // set by supervisorSet.add
+	recycle      chan struct{}     // asks run to replace the instance; set by supervisorSet.add
+
+	mu        sync.Mutex
+	state     string
+	startedAt time.Time
+	restarts  int
+	crashes   []time.Time // within crashLoopWindow
+	halfOpen  bool
+	lastError string
+	retryAt   time.Time
+}
+
+// run supervises until ctx is cancelled.
+func (s *supervisor) run(ctx context.Context) {
+	if s.now == nil {
+		s.now = time.Now
+	}
+	s.setRunning()
+
+	backoff := restartBackoffMin
+	for {
+		session, inst, _ := s.conn.current()
+		select {
+		case <-ctx.Done():
+			s.stop()
+			return
+		case <-s.recycle:
+			s.replace(ctx, session, inst)
+			continue
+		case <-inst.Done():
+		}
+		if ctx.Err() != nil {
+			s.stop()
+			return
+		}
+		session.Close()
+		exitErr := inst.Err()
+		if errors.Is(exitErr, context.Canceled) {
+			// Stopped to abandon a cancelled tool call, not a crash.
+			slog.Info("Plugin stopped for a cancelled call, restarting", "plugin", s.pluginName, "module", s.name)
+			s.conn.setDown(fmt.Errorf("plugin %s is restarting after a cancelled call", s.pluginName))
+			var err error
+			if session, inst, err = s.start(ctx); err == nil {
+				s.conn.swap(session, inst)
+				s.setRunning()
+				continue
+			}
+			exitErr = err
+		}
+		if exitErr == nil {
+			exitErr = fmt.Errorf("plugin exited")
+		}
+		slog.Warn("Plugin exited", "plugin", s.pluginName, "module", s.name, "err", exitErr)
+
+		s.mu.Lock()
+		if s.now().Sub(s.startedAt) >= stableRunTime {
+			backoff = restartBackoffMin
+			s.crashes = nil
+			s.halfOpen = false
+		}
+		s.mu.Unlock()
+
+		for {
+			wait, open := s.recordCrash(exitErr, backoff)
+			if open {
+				slog.Error("Plugin is crash-looping, pausing restarts", "plugin", s.pluginName, "module", s.name, "pause", wait)
+				s.conn.setDown(fmt.Errorf("plugin %s is crash-looping (last error: %v); restarts paused until %s", s.pluginName, exitErr, s.retryTime().Format(time.RFC3339)))
+			} else {
+				backoff = min(backoff*2, restartBackoffMax)
+				s.conn.setDown(fmt.Errorf("plugin %s exited (%v) and is restarting", s.pluginName, exitErr))
+			}
+			if !sleepContext(ctx, wait) {
+				s.stop()
+				return
+			}
+
+			var err error
+			session, inst, err = s.start(ctx)
+			if err == nil {
+				s.conn.swap(session, inst)
+				s.setRunning()
+				slog.Info("Restarted plugin", "plugin", s.pluginName, "module", s.name)
+				break
+			}
+			slog.Error("Failed to restart plugin", "plugin", s.pluginName, "module", s.name, "err", err)
+			exitErr = err
+		}
+	}
+}
+
+// replace starts a new instance in place of the running one, which is
+// retired once its calls finish. A plugin that fails to start keeps the
+// old instance.
+func (s *supervisor) replace(ctx context.Context, session *mcp.ClientSession, inst *wasmhost.Instance) {
+	newSession, newInst, err := s.start(ctx)
+	if err != nil {
+		slog.Warn("Failed to replace plugin instance", "plugin", s.pluginName, "module", s.name, "err", err)
+		return
+	}
+	s.conn.swap(newSession, newInst)
+	slog.Info("Replaced plugin instance", "plugin", s.pluginName, "module", s.name)
+	go s.conn.retire(session)
+}
+
+// recycleRequested asks run to replace the instance, e.g. so it picks up a
+// renewed API key.
+func (s *supervisor) recycleRequested() {
+	select {
+	case s.recycle <- struct{}{}:
+	default: // already pending
+	}
+}
+
+// recordCrash notes an exit or failed restart and returns how long to wait
+// before the next attempt, and whether the circuit breaker is now open.
+func (s *supervisor) recordCrash(err error, backoff time.Duration) (time.Duration, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.lastError = err.Error()
+	recent := s.crashes[:0]
+	for _, t := range s.crashes {
+		if now.Sub(t) < crashLoopWindow {
+			recent = append(recent, t)
+		}
+	}
+	s.crashes = append(recent, now)
+
+	if s.halfOpen || len(s.crashes) >= crashLoopThreshold {
+		s.state = stateCircuitOpen
+		s.crashes = nil
+		s.halfOpen = true // the next start is a trial
+		s.retryAt = now.Add(circuitCooldown)
+		return circuitCooldown, true
+	}
+	s.state = stateRestarting
+	s.retryAt = now.Add(backoff)
+	return backoff, false
+}
+
+func (s *supervisor) setRunning() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.state != "" {
+		s.restarts++
+		s.restartCount.Inc(s.pluginName)
+	}
+	s.state = stateRunning
+	s.startedAt = s.now()
+	s.retryAt = time.Time{}
+}
+
+func (s *supervisor) stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state = stateStopped
+}
+
+func (s *supervisor) retryTime() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.retryAt
+}
+
+// supervisorStatus is a point-in-time view of one supervisor.
+type supervisorStatus struct {
+	Name       string
+	PluginName string
+	State      string
+	Restarts   int
+	LastError  string
+	RetryAt    time.Time
+}
+
+func (s *supervisor) status() supervisorStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return supervisorStatus{
+		Name:       s.name,
+		PluginName: s.pluginName,
+		State:      s.state,
+		Restarts:   s.restarts,
+		LastError:  s.lastError,
+		RetryAt:    s.retryAt,
+	}
+}
+
+// supervisorSet holds the supervisors started by runServe.
+type supervisorSet struct {
+	restarts *mcper.CounterVec // counts restarts by plugin, if set
+
+	mu          sync.Mutex
+	supervisors []*supervisor
+}
+
+// add starts supervising `s` until ctx is cancelled.
+func (set *supervisorSet) add(ctx context.Context, s *supervisor) {
+	s.restartCount = set.restarts
+	s.recycle = make(chan struct{}, 1)
+	set.mu.Lock()
+	set.supervisors = append(set.supervisors, s)
+	set.mu.Unlock()
+	go s.run(ctx)
+}
+
+// remove forgets `s`, e.g. once a pool has stopped its instance.
+func (set *supervisorSet) remove(s *supervisor) {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	for i, other := range set.supervisors {
+		if other == s {
+			set.supervisors = append(set.supervisors[:i], set.supervisors[i+1:]...)
+			return
+		}
+	}
+}
+
+// recycle replaces every supervised instance with a fresh one. Plugins read
+// MCPER_AUTH_TOKEN once at startup, so this is how a renewed API key
+// reaches them.
+func (set *supervisorSet) recycle() {
+	for _, s := range set.all() {
+		s.recycleRequested()
+	}
+}
+
+func (set *supervisorSet) statuses() []supervisorStatus {
+	supervisors := set.all()
+	statuses := make([]supervisorStatus, 0, len(supervisors))
+	for _, s := range supervisors {
+		statuses = append(statuses, s.status())
+	}
+	return statuses
+}
+
+// all returns the current supervisors.
+func (set *supervisorSet) all() []*supervisor {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	return slices.Clone(set.supervisors)
+}
+
+// registerSupervisorTool adds mcper/native/plugin_supervisor, which reports
+// restart counts and circuit breaker state for each WASM plugin.
+func registerSupervisorTool(server *mcp.Server, set *supervisorSet) {
+	mcp.AddTool[map[string]any, any](server, &mcp.Tool{
+		Name:        "mcper/native/plugin_supervisor",
+		Description: "Show the supervisor state of each running WASM plugin: restarts, last crash, and whether its crash-loop circuit breaker is open.",
+		InputSchema: &jsonschema.Schema{
+			Type:       "object",
+			Properties: map[string]*jsonschema.Schema{},
+		},
+	}, func(ctx context.Context, _ *mcp.CallToolRequest, input map[string]any) (*mcp.CallToolResult, any, error) {
+		return textResult(formatSupervisorStatuses(set.statuses())), nil, nil
+	})
+}
+
+func formatSupervisorStatuses(statuses []supervisorStatus) string {
+	if len(statuses) == 0 {
+		return "No supervised WASM plugins."
+	}
+	var sb strings.Builder
+	sb.WriteString("# Plugin Supervisor\n\n")
+	for _, st := range statuses {
+		sb.WriteString(fmt.Sprintf("## %s (%s)\n", st.PluginName, st.Name))
+		sb.WriteString(fmt.Sprintf("**State:** %s\n", st.State))
+		sb.WriteString(fmt.Sprintf("**Restarts:** %d\n", st.Restarts))
+		if st.LastError != "" {
+			sb.WriteString(fmt.Sprintf("**Last error:** %s\n", st.LastError))
+		}
+		if !st.RetryAt.IsZero() {
+			sb.WriteString(fmt.Sprintf("**Next attempt:** %s\n", st.RetryAt.Format(time.RFC3339)))
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// sleepContext waits for d, returning false if ctx is cancelled first.
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
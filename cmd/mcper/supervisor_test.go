@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joshcarp/mcper/pkg/wasmhost"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+func TestSupervisor_CircuitBreaker(t *testing.T) {
+	clock := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := &supervisor{name: "plugin-0", pluginName: "hello", now: func() time.Time { return clock }}
+	crash := errors.New("exit code 2")
+
+	for i := 1; i < crashLoopThreshold; i++ {
+		wait, open := s.recordCrash(crash, time.Second)
+		if open || wait != time.Second {
+			t.Fatalf("crash %d: wait=%v open=%v, want backoff without opening", i, wait, open)
+		}
+		clock = clock.Add(10 * time.Second)
+	}
+	wait, open := s.recordCrash(crash, time.Second)
+	if !open || wait != circuitCooldown {
+		t.Fatalf("crash %d: wait=%v open=%v, want circuit open", crashLoopThreshold, wait, open)
+	}
+	if st := s.status(); st.State != stateCircuitOpen || st.LastError != crash.Error() {
+		t.Errorf("status = %+v, want circuit_open with last error", st)
+	}
+
+	// The trial start after the cooldown reopens the circuit on its first crash.
+	clock = clock.Add(circuitCooldown)
+	if _, open := s.recordCrash(crash, time.Second); !open {
+		t.Errorf("half-open crash did not reopen the circuit")
+	}
+}
+
+func TestSupervisor_CrashesOutsideWindowDoNotTrip(t *testing.T) {
+	clock := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := &supervisor{now: func() time.Time { return clock }}
+	for i := 0; i < 2*crashLoopThreshold; i++ {
+		if _, open := s.recordCrash(errors.New("boom"), time.Second); open {
+			t.Fatalf("crash %d opened the circuit despite being spread out", i)
+		}
+		clock = clock.Add(crashLoopWindow / 2)
+	}
+}
+
+func TestSupervisorSet_RecycleReplacesInstances(t *testing.T) {
+	old, fresh := &wasmhost.Instance{}, &wasmhost.Instance{}
+	conn := &pluginConn{instance: old}
+	starts := make(chan struct{}, 1)
+	s := &supervisor{name: "plugin-0", pluginName: "hello", conn: conn, start: func(context.Context) (*mcp.ClientSession, *wasmhost.Instance, error) {
+		starts <- struct{}{}
+		return nil, fresh, nil
+	}}
+	set := &supervisorSet{}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	set.add(ctx, s)
+
+	set.recycle()
+	select {
+	case <-starts:
+	case <-time.After(5 * time.Second):
+		t.Fatal("recycle did not start a new instance")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, inst, _ := conn.current(); inst == fresh {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("new instance was not swapped in")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if st := s.status(); st.State != stateRunning || st.Restarts != 0 {
+		t.Errorf("status = %+v, want running without counting a restart", st)
+	}
+}
+
+func TestSupervisor_ReplaceKeepsInstanceWhenStartFails(t *testing.T) {
+	old := &wasmhost.Instance{}
+	conn := &pluginConn{instance: old}
+	s := &supervisor{conn: conn, start: func(context.Context) (*mcp.ClientSession, *wasmhost.Instance, error) {
+		return nil, nil, errors.New("boom")
+	}}
+	s.replace(context.Background(), nil, old)
+	if _, inst, down := conn.current(); inst != old || down != nil {
+		t.Errorf("current = %p, %v; want the old instance, still up", inst, down)
+	}
+}
+
+func TestFormatSupervisorStatuses(t *testing.T) {
+	out := formatSupervisorStatuses([]supervisorStatus{{
+		Name:       "plugin-0",
+		PluginName: "github",
+		State:      stateRestarting,
+		Restarts:   3,
+		LastError:  "module plugin-0 exceeded its lifetime limit (1h0m0s)",
+	}})
+	for _, want := range []string{"github (plugin-0)", "**State:** restarting", "**Restarts:** 3", "lifetime limit"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q:\n%s", want, out)
+		}
+	}
+	if got := formatSupervisorStatuses(nil); got != "No supervised WASM plugins." {
+		t.Errorf("empty output = %q", got)
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// maxToolNameLen is the longest tool name connectors such as Claude.ai
+// accept. Tool names must match ^[a-zA-Z0-9_-]{1,64}$.
+const maxToolNameLen = 64
+
+// toolNameHashLen is how many hex digits of the full name's SHA-256 end a
+// shortened name, keeping names that share a long prefix apart.
+const toolNameHashLen = 8
+
+// sanitizeToolName makes `name` a valid connector tool name, reporting
+// whether it had to change. Disallowed characters become underscores and a
+// name that is still too long is cut short and given a hash of the original,
+// so the same name always maps to the same result.
+func sanitizeToolName(name string) (string, bool) {
+	sanitized := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '-':
+			return r
+		}
+		return '_'
+	}, name)
+	if sanitized == "" {
+		sanitized = "_"
+	}
+	if len(sanitized) > maxToolNameLen {
+		sum := sha256.Sum256([]byte(name))
+		suffix := hex.EncodeToString(sum[:])[:toolNameHashLen]
+		sanitized = sanitized[:maxToolNameLen-len(suffix)-1] + "_" + suffix
+	}
+	return sanitized, sanitized != name
+}
+
+// forwardedName is the name a plugin tool is exposed under.
+type forwardedName struct {
+	Name    string `json:"name"`              // as exposed to clients
+	Tool    string `json:"tool"`              // the plugin's own name for it
+	Source  string `json:"source"`            // the plugin's source
+	Mangled bool   `json:"mangled,omitempty"` // sanitized or shortened to fit
+}
+
+// forwardedName works out what `tool` is called once forwarded: named by
+// the plugin's tool rules, then sanitized.
+func (s *pluginScope) forwardedName(namespace, pluginName string, tool *mcp.Tool) forwardedName {
+	name, mangled := sanitizeToolName(s.rules.ToolName(fmt.Sprintf("%s_%s", namespace, pluginName), tool.Name))
+	return forwardedName{Name: name, Tool: tool.Name, Source: s.source, Mangled: mangled}
+}
+
+// Kinds of things a plugin forwards. Each kind has its own names on the
+// server: tools and prompts by name, resources by URI and resource
+// templates by URI template.
+const (
+	kindTool             = "tool"
+	kindResource         = "resource"
+	kindResourceTemplate = "resource template"
+	kindPrompt           = "prompt"
+)
+
+// ownedName is a forwarded name of one kind.
+type ownedName struct {
+	kind string
+	name string
+}
+
+// toolOwners maps each forwarded name back to the plugin and tool, resource
+// or prompt it came from, so that one plugin can't silently replace
+// another's.
+type toolOwners struct {
+	mu     sync.Mutex
+	owners map[ownedName]toolOwner
+}
+
+type toolOwner struct {
+	scope *pluginScope
+	name  forwardedName
+}
+
+// lookup returns where the forwarded tool `name` came from.
+func (o *toolOwners) lookup(name string) (forwardedName, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	owner, ok := o.owners[ownedName{kindTool, name}]
+	return owner.name, ok
+}
+
+// names returns every forwarded tool name, sorted.
+func (o *toolOwners) names() []forwardedName {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	names := make([]forwardedName, 0, len(o.owners))
+	for key, owner := range o.owners {
+		if key.kind == kindTool {
+			names = append(names, owner.name)
+		}
+	}
+	sort.Slice(names, func(i, j int) bool { return names[i].Name < names[j].Name })
+	return names
+}
+
+// release frees the names `scope` reserved.
+func (o *toolOwners) release(scope *pluginScope) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for name, owner := range o.owners {
+		if owner.scope == scope {
+			delete(o.owners, name)
+		}
+	}
+}
+
+// reserve claims tool names for the scope's plugin. It claims none of them
+// if any is already forwarded from another plugin.
+func (s *pluginScope) reserve(names []forwardedName) error {
+	return s.reserveKind(kindTool, names)
+}
+
+// reserveKind is reserve for names of any kind.
+func (s *pluginScope) reserveKind(kind string, names []forwardedName) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed || s.owners == nil {
+		return nil
+	}
+	o := s.owners
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, name := range names {
+		if owner, ok := o.owners[ownedName{kind, name.Name}]; ok && owner.scope != s {
+			return fmt.Errorf("%s %s is already forwarded from %s", kind, name.Name, owner.name.Source)
+		}
+	}
+	if o.owners == nil {
+		o.owners = make(map[ownedName]toolOwner)
+	}
+	for _, name := range names {
+		o.owners[ownedName{kind, name.Name}] = toolOwner{scope: s, name: name}
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/joshcarp/mcper/pkg/mcper"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+var connectorToolName = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+func TestSanitizeToolName(t *testing.T) {
+	long := "wasm_" + strings.Repeat("x", 80)
+	tests := []struct {
+		name        string
+		in          string
+		want        string
+		wantMangled bool
+	}{
+		{"valid", "wasm_github_list_issues", "wasm_github_list_issues", false},
+		{"slashes from a cloud url", "cloud_acme/tools_search", "cloud_acme_tools_search", true},
+		{"dots and spaces", "http_plugin-1_get.page now", "http_plugin-1_get_page_now", true},
+		{"empty", "", "_", true},
+		{"exactly 64", strings.Repeat("a", 64), strings.Repeat("a", 64), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, mangled := sanitizeToolName(tt.in)
+			if got != tt.want {
+				t.Errorf("sanitizeToolName(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+			if mangled != tt.wantMangled {
+				t.Errorf("sanitizeToolName(%q) mangled = %v, want %v", tt.in, mangled, tt.wantMangled)
+			}
+			if !connectorToolName.MatchString(got) {
+				t.Errorf("sanitizeToolName(%q) = %q, not a valid connector tool name", tt.in, got)
+			}
+		})
+	}
+
+	// Long names are cut short and stay stable, and differ when the
+	// originals do.
+	a, mangled := sanitizeToolName(long + "_a")
+	if len(a) != maxToolNameLen || !strings.HasPrefix(a, long[:55]+"_") || !mangled {
+		t.Errorf("sanitizeToolName(long) = %q, %v; want 64 characters starting %q", a, mangled, long[:55]+"_")
+	}
+	again, _ := sanitizeToolName(long + "_a")
+	b, _ := sanitizeToolName(long + "_b")
+	if a != again {
+		t.Errorf("shortened names differ between calls: %q, %q", a, again)
+	}
+	if a == b {
+		t.Errorf("names with a common 64-character prefix both shortened to %q", a)
+	}
+}
+
+func TestToolOwners_Lookup(t *testing.T) {
+	owners := &toolOwners{}
+	scope := &pluginScope{source: "https://cloud.example.com/acme/tools", owners: owners, rules: &mcper.ToolRules{Alias: "acme"}}
+	name := scope.forwardedName(namespaceCloud, "acme/tools", &mcp.Tool{Name: "search.v2"})
+	if name.Name != "acme_search_v2" || !name.Mangled {
+		t.Fatalf("forwardedName = %+v, want acme_search_v2, mangled", name)
+	}
+	if err := scope.reserve([]forwardedName{name}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, ok := owners.lookup("acme_search_v2")
+	if !ok || got.Tool != "search.v2" || got.Source != scope.source {
+		t.Errorf("lookup = %+v, %v; want search.v2 from %s", got, ok, scope.source)
+	}
+	if _, ok := owners.lookup("acme_search"); ok {
+		t.Error("lookup found a name nobody forwards")
+	}
+
+	owners.release(scope)
+	if names := owners.names(); len(names) != 0 {
+		t.Errorf("names after release = %v, want none", names)
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/joshcarp/mcper/pkg/mcper"
+	"github.com/joshcarp/mcper/pkg/wasmhost"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/spf13/cobra"
+)
+
+var toolsJSON bool
+
+var toolsCmd = &cobra.Command{
+	Use:   "tools",
+	Short: "List the tools mcper serve exposes",
+	Long: `Load the plugins configured in this project, as mcper serve does, and
+list the name every forwarded tool is exposed under, the plugin's own name
+for it and where it comes from.
+
+Tool names must match ^[a-zA-Z0-9_-]{1,64}$. Names that had to be sanitized
+or shortened to fit are marked with *. Plugins that fail to load are listed
+with their error.
+
+Examples:
+  mcper tools          List exposed tool names
+  mcper tools --json   Output as JSON`,
+	RunE: runTools,
+}
+
+func init() {
+	toolsCmd.Flags().BoolVar(&toolsJSON, "json", false, "Output as JSON")
+}
+
+func runTools(cmd *cobra.Command, args []string) error {
+	startScript := filepath.Join(".mcper", mcper.StartScriptName)
+	config := &mcper.Config{}
+	if _, err := os.Stat(startScript); err == nil {
+		config, err = mcper.ParseStartScript(startScript)
+		if err != nil {
+			return fmt.Errorf("failed to parse start script: %w", err)
+		}
+	}
+
+	// Loading plugins logs as much as serve does; none of it belongs on
+	// the terminal here.
+	log.SetOutput(io.Discard)
+
+	profile, err := selectedProfile(config.Profile)
+	if err != nil {
+		return err
+	}
+	var proxyURL string
+	var keys *mcper.KeyRefresher
+	creds, err := mcper.LoadProfileCredentials(profile)
+	if err == nil && creds.IsValid() {
+		proxyURL = creds.GetProxyURL()
+		keys = mcper.NewKeyRefresher(creds, nil, nil)
+		addRemoteServers(config, creds)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var wasmHost *wasmhost.WasmHost
+	if compiledDir, err := mcper.CompiledCacheDir(); err == nil {
+		wasmHost = wasmhost.NewWasmHostWithCacheDir(ctx, compiledDir)
+	} else {
+		wasmHost = wasmhost.NewLoggingWasmHost(ctx)
+	}
+	defer wasmHost.Close(ctx)
+
+	plugins := &pluginSet{
+		ctx:         ctx,
+		server:      mcp.NewServer(&mcp.Implementation{Name: "mcper", Version: mcper.Version}, nil),
+		host:        wasmHost,
+		supervisors: &supervisorSet{},
+		health:      &healthSet{},
+		progress:    &progressRelay{},
+		profile:     profile,
+		keys:        keys,
+		proxyURL:    proxyURL,
+	}
+	plugins.apply(config.Plugins)
+	defer plugins.stopAll()
+
+	names := plugins.owners.names()
+	var failed []pluginHealthStatus
+	for _, st := range plugins.health.statuses() {
+		if st.State != healthLoaded {
+			failed = append(failed, st)
+		}
+	}
+
+	if toolsJSON {
+		output := struct {
+			Tools  []forwardedName      `json:"tools"`
+			Failed []pluginHealthStatus `json:"failed,omitempty"`
+		}{Tools: names, Failed: failed}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(output)
+	}
+	printToolNames(os.Stdout, names, failed)
+	return nil
+}
+
+// printToolNames writes the exposed tool names as a table, followed by
+// the plugins that failed to load.
+func printToolNames(out io.Writer, names []forwardedName, failed []pluginHealthStatus) {
+	if len(names) == 0 && len(failed) == 0 {
+		fmt.Fprintln(out, "No tools.")
+		return
+	}
+
+	mangled := 0
+	if len(names) > 0 {
+		w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "TOOL\tPLUGIN TOOL\tSOURCE")
+		fmt.Fprintln(w, "----\t-----------\t------")
+		for _, name := range names {
+			exposed := name.Name
+			if name.Mangled {
+				exposed += " *"
+				mangled++
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\n", exposed, name.Tool, name.Source)
+		}
+		w.Flush()
+	}
+	if mangled > 0 {
+		fmt.Fprintf(out, "\n* %d name(s) sanitized or shortened to fit ^[a-zA-Z0-9_-]{1,64}$\n", mangled)
+	}
+
+	if len(failed) > 0 {
+		fmt.Fprintln(out, "\nFAILED TO LOAD:")
+		for _, st := range failed {
+			fmt.Fprintf(out, "  %s: %s\n", st.Source, st.LastError)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+
+	"github.com/joshcarp/mcper/pkg/mcper"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// otlpEndpointEnv is the standard OpenTelemetry variable naming an OTLP
+// collector, used when --trace-endpoint isn't given.
+const otlpEndpointEnv = "OTEL_EXPORTER_OTLP_ENDPOINT"
+
+// newServeTracer returns a tracer exporting to the OTLP collector at
+// `endpoint`, or to `file` as JSON lines, or nil if neither is set.
+func newServeTracer(endpoint, file string) (*mcper.Tracer, error) {
+	if endpoint == "" {
+		endpoint = os.Getenv(otlpEndpointEnv)
+	}
+	exporter, err := mcper.NewTraceExporter(endpoint, file)
+	if err != nil || exporter == nil {
+		return nil, err
+	}
+	if file != "" {
+		slog.Info("Writing traces", "path", file)
+	} else {
+		slog.Info("Exporting traces", "endpoint", endpoint)
+	}
+	return mcper.NewTracer(exporter, func(err error) {
+		slog.Warn("Tracing failed", "err", err)
+	}), nil
+}
+
+// clientTraceContext continues the trace the client started, if its
+// request carries a traceparent in _meta.
+func clientTraceContext(ctx context.Context, callReq *mcp.CallToolRequest) context.Context {
+	if callReq.Params == nil {
+		return ctx
+	}
+	traceparent, _ := callReq.Params.Meta[mcper.TraceparentKey].(string)
+	if traceparent == "" {
+		return ctx
+	}
+	sc, err := mcper.ParseTraceparent(traceparent)
+	if err != nil {
+		slog.Info("Ignoring traceparent from client", "err", err)
+		return ctx
+	}
+	return mcper.ContextWithSpanContext(ctx, sc)
+}
+
+// setTraceparent passes the current span to a plugin through _meta, so its
+// HTTP requests can carry it on.
+func setTraceparent(ctx context.Context, params *mcp.CallToolParams) {
+	sc, ok := mcper.SpanContextFromContext(ctx)
+	if !ok {
+		return
+	}
+	if params.Meta == nil {
+		params.Meta = mcp.Meta{}
+	}
+	params.Meta[mcper.TraceparentKey] = sc.Traceparent()
+}
+
+// endSpan ends `span`, marking it failed if err is set.
+func endSpan(span *mcper.Span, err error) {
+	if err != nil {
+		span.SetError(err.Error())
+	}
+	span.End()
+}
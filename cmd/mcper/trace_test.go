@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/jsonschema-go/jsonschema"
+	"github.com/joshcarp/mcper/pkg/mcper"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+func TestForwardedTool_Traced(t *testing.T) {
+	ctx := context.Background()
+	const clientTrace = "4bf92f3577b34da6a3ce929d0e0e4736"
+
+	var pluginTraceparent string
+	plugin := mcp.NewServer(&mcp.Implementation{Name: "github", Version: "test"}, nil)
+	mcp.AddTool(plugin, &mcp.Tool{Name: "create_issue", InputSchema: &jsonschema.Schema{Type: "object"}}, func(ctx context.Context, req *mcp.CallToolRequest, input map[string]any) (*mcp.CallToolResult, any, error) {
+		pluginTraceparent, _ = req.Params.Meta[mcper.TraceparentKey].(string)
+		return textResult("created"), nil, nil
+	})
+	tracePath := filepath.Join(t.TempDir(), "traces.jsonl")
+	tracer, err := newServeTracer("", tracePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := mcp.NewServer(&mcp.Implementation{Name: "mcper", Version: "test"}, nil)
+	scope := &pluginScope{server: server, source: "github.wasm", progress: &progressRelay{}, tracer: tracer}
+	registerForwardedTool(scope, &pluginConn{session: connect(t, plugin)}, namespaceWASM, "github", "Tool call failed", &mcp.Tool{Name: "create_issue"}, mcper.ApprovalAllow, nil)
+
+	params := &mcp.CallToolParams{
+		Meta:      mcp.Meta{mcper.TraceparentKey: "00-" + clientTrace + "-00f067aa0ba902b7-01"},
+		Name:      "wasm_github_create_issue",
+		Arguments: map[string]any{},
+	}
+	if _, err := connect(t, server).CallTool(ctx, params); err != nil {
+		t.Fatal(err)
+	}
+	if err := tracer.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	sc, err := mcper.ParseTraceparent(pluginTraceparent)
+	if err != nil {
+		t.Fatalf("plugin got no traceparent: %v", err)
+	}
+	if got := sc.Traceparent(); !strings.Contains(got, clientTrace) {
+		t.Errorf("plugin traceparent %s isn't in the client's trace", got)
+	}
+	data, err := os.ReadFile(tracePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"tools/call wasm_github_create_issue", "plugin.call"} {
+		if !strings.Contains(string(data), `"name":"`+name+`"`) {
+			t.Errorf("no %s span in %s", name, data)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// newClient returns an MCP client for one of the plugin's sessions. It
+// relays what the plugin asks of its client to mcper's own clients:
+// progress and roots, and sampling and elicitation when the plugin is
+// allowed them. Plugins that aren't are never told they are available.
+func (s *pluginScope) newClient(impl *mcp.Implementation) *mcp.Client {
+	opts := &mcp.ClientOptions{
+		ProgressNotificationHandler: s.progress.notify,
+	}
+	if s.sampling {
+		opts.CreateMessageHandler = s.createMessage
+	}
+	if s.elicitation {
+		opts.ElicitationHandler = s.elicit
+	}
+	client := mcp.NewClient(impl, opts)
+	client.AddReceivingMiddleware(s.relayRoots)
+	return client
+}
+
+func (s *pluginScope) createMessage(ctx context.Context, req *mcp.CreateMessageRequest) (*mcp.CreateMessageResult, error) {
+	session, err := s.callers.caller()
+	if err != nil {
+		return nil, err
+	}
+	return session.CreateMessage(ctx, req.Params)
+}
+
+func (s *pluginScope) elicit(ctx context.Context, req *mcp.ElicitRequest) (*mcp.ElicitResult, error) {
+	session, err := s.callers.caller()
+	if err != nil {
+		return nil, err
+	}
+	return session.Elicit(ctx, req.Params)
+}
+
+// relayRoots answers roots/list with the calling client's roots instead of
+// the plugin client's own, which are empty.
+func (s *pluginScope) relayRoots(next mcp.MethodHandler) mcp.MethodHandler {
+	return func(ctx context.Context, method string, req mcp.Request) (mcp.Result, error) {
+		if method != "roots/list" {
+			return next(ctx, method, req)
+		}
+		session, err := s.callers.caller()
+		if err != nil {
+			return nil, err
+		}
+		return session.ListRoots(ctx, &mcp.ListRootsParams{})
+	}
+}
+
+// callers tracks which client sessions are calling a plugin, so requests
+// the plugin makes back reach the client whose call caused them.
+type callers struct {
+	mu       sync.Mutex
+	inflight map[*mcp.ServerSession]int
+}
+
+// enter records a call from `session` and returns a func to call when it
+// is done.
+func (c *callers) enter(session *mcp.ServerSession) func() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.inflight == nil {
+		c.inflight = make(map[*mcp.ServerSession]int)
+	}
+	c.inflight[session]++
+	return func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.inflight[session]--; c.inflight[session] == 0 {
+			delete(c.inflight, session)
+		}
+	}
+}
+
+// caller returns the client session for a request from the plugin: the
+// one whose forwarded call is in flight. Requests made outside a call, or
+// while several clients are calling the plugin, can't be attributed and
+// fail.
+func (c *callers) caller() (*mcp.ServerSession, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch len(c.inflight) {
+	case 0:
+		return nil, fmt.Errorf("plugin request can't be attributed: no tool call is in flight")
+	case 1:
+		for session := range c.inflight {
+			return session, nil
+		}
+	}
+	return nil, fmt.Errorf("plugin request can't be attributed: %d clients are calling it", len(c.inflight))
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/jsonschema-go/jsonschema"
+	"github.com/joshcarp/mcper/pkg/mcper"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// relayPlugin is a plugin whose "ask" tool asks its client for a sampled
+// message, user input and the client's roots, and reports what it got.
+func relayPlugin() *mcp.Server {
+	plugin := mcp.NewServer(&mcp.Implementation{Name: "assistant", Version: "test"}, nil)
+	mcp.AddTool(plugin, &mcp.Tool{Name: "ask", InputSchema: &jsonschema.Schema{Type: "object"}}, func(ctx context.Context, req *mcp.CallToolRequest, input map[string]any) (*mcp.CallToolResult, any, error) {
+		var out []string
+		if msg, err := req.Session.CreateMessage(ctx, &mcp.CreateMessageParams{MaxTokens: 10, Messages: []*mcp.SamplingMessage{{Role: "user", Content: &mcp.TextContent{Text: "hi"}}}}); err != nil {
+			out = append(out, "sampling failed")
+		} else {
+			out = append(out, "sampled "+msg.Content.(*mcp.TextContent).Text)
+		}
+		if answer, err := req.Session.Elicit(ctx, &mcp.ElicitParams{Message: "name?", RequestedSchema: &jsonschema.Schema{Type: "object"}}); err != nil {
+			out = append(out, "elicitation failed")
+		} else {
+			out = append(out, "elicited "+answer.Action)
+		}
+		if roots, err := req.Session.ListRoots(ctx, nil); err != nil {
+			out = append(out, "roots failed: "+err.Error())
+		} else {
+			for _, root := range roots.Roots {
+				out = append(out, "root "+root.URI)
+			}
+		}
+		return textResult(strings.Join(out, "\n")), nil, nil
+	})
+	return plugin
+}
+
+func TestPluginScope_RelaysClientRequests(t *testing.T) {
+	tests := []struct {
+		name        string
+		sampling    bool
+		elicitation bool
+		want        []string
+	}{
+		{"both allowed", true, true, []string{"sampled from the client", "elicited accept", "root file:///project"}},
+		{"sampling not allowed", false, true, []string{"sampling failed", "elicited accept", "root file:///project"}},
+		{"elicitation not allowed", true, false, []string{"sampled from the client", "elicitation failed", "root file:///project"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			server := mcp.NewServer(&mcp.Implementation{Name: "mcper", Version: "test"}, nil)
+			scope := &pluginScope{server: server, progress: &progressRelay{}, sampling: tt.sampling, elicitation: tt.elicitation}
+
+			serverTransport, clientTransport := mcp.NewInMemoryTransports()
+			if _, err := relayPlugin().Connect(ctx, serverTransport, nil); err != nil {
+				t.Fatal(err)
+			}
+			pluginSession, err := scope.newClient(&mcp.Implementation{Name: "WASM-plugin-0", Version: "test"}).Connect(ctx, clientTransport, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer pluginSession.Close()
+			registerForwardedTool(scope, &pluginConn{session: pluginSession}, namespaceWASM, "assistant", "Tool call failed", &mcp.Tool{Name: "ask"}, mcper.ApprovalAllow, nil)
+
+			client := mcp.NewClient(&mcp.Implementation{Name: "client", Version: "test"}, &mcp.ClientOptions{
+				CreateMessageHandler: func(context.Context, *mcp.CreateMessageRequest) (*mcp.CreateMessageResult, error) {
+					return &mcp.CreateMessageResult{Role: "assistant", Model: "test", Content: &mcp.TextContent{Text: "from the client"}}, nil
+				},
+				ElicitationHandler: func(context.Context, *mcp.ElicitRequest) (*mcp.ElicitResult, error) {
+					return &mcp.ElicitResult{Action: "accept"}, nil
+				},
+			})
+			client.AddRoots(&mcp.Root{URI: "file:///project"})
+			serverTransport, clientTransport = mcp.NewInMemoryTransports()
+			if _, err := server.Connect(ctx, serverTransport, nil); err != nil {
+				t.Fatal(err)
+			}
+			session, err := client.Connect(ctx, clientTransport, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer session.Close()
+
+			result, err := session.CallTool(ctx, &mcp.CallToolParams{Name: "wasm_assistant_ask", Arguments: map[string]any{}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := result.Content[0].(*mcp.TextContent).Text
+			if got != strings.Join(tt.want, "\n") {
+				t.Errorf("plugin saw:\n%s\nwant:\n%s", got, strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestCallers_Caller(t *testing.T) {
+	a, b := &mcp.ServerSession{}, &mcp.ServerSession{}
+	var c callers
+
+	if _, err := c.caller(); err == nil {
+		t.Error("caller with no call in flight succeeded")
+	}
+
+	leaveA := c.enter(a)
+	if got, err := c.caller(); err != nil || got != a {
+		t.Errorf("caller during a's call = %p, %v; want a", got, err)
+	}
+	leaveB := c.enter(b)
+	if _, err := c.caller(); err == nil {
+		t.Error("caller with two clients calling succeeded")
+	}
+	leaveB()
+	if got, err := c.caller(); err != nil || got != a {
+		t.Errorf("caller after b finished = %p, %v; want a", got, err)
+	}
+	leaveA()
+	if got, err := c.caller(); err == nil {
+		t.Errorf("caller after every call finished = %p; want an error", got)
+	}
+}
@@ -5,15 +5,16 @@ go 1.25.0
 require (
 	github.com/breml/rootcerts v0.3.0
 	github.com/google/jsonschema-go v0.4.3
+	github.com/google/uuid v1.6.0
 	github.com/modelcontextprotocol/go-sdk v0.1.0
 	github.com/spf13/cobra v1.8.0
 	github.com/stealthrocket/net v0.2.1
 	github.com/stealthrocket/wasi-go v0.8.0
 	github.com/tetratelabs/wazero v1.9.0
+	golang.org/x/sys v0.41.0
 )
 
 require (
-	github.com/google/uuid v1.6.0 // indirect
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
 	github.com/segmentio/asm v1.1.3 // indirect
 	github.com/segmentio/encoding v0.5.4 // indirect
@@ -21,7 +22,6 @@ require (
 	github.com/stealthrocket/wazergo v0.19.1 // indirect
 	github.com/yosida95/uritemplate/v3 v3.0.2 // indirect
 	golang.org/x/oauth2 v0.35.0 // indirect
-	golang.org/x/sys v0.41.0 // indirect
 	golang.org/x/time v0.15.0 // indirect
 )
 
@@ -0,0 +1,239 @@
+package mcper
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// AuditFile is the filename of the tool call audit log
+const AuditFile = "audit.jsonl"
+
+// auditTailSize is how much of the log lastAuditHash reads at a time,
+// working back from the end until it holds the whole last entry.
+const auditTailSize = 64 * 1024
+
+// auditMaxEntry is the longest entry Append writes, and so the longest
+// line readAudit has to buffer.
+const auditMaxEntry = 1024 * 1024
+
+// AuditEntry records one forwarded tool call. Each entry carries the hash
+// of the one before it, so editing or deleting an entry breaks the chain
+// from there on.
+type AuditEntry struct {
+	Time         time.Time `json:"time"`
+	InvocationID string    `json:"invocation_id"`
+	Plugin       string    `json:"plugin"`                // the plugin's source
+	Tool         string    `json:"tool"`                  // as exposed to clients
+	PluginTool   string    `json:"plugin_tool,omitempty"` // the plugin's own name for it
+	ArgsDigest   string    `json:"args_digest"`           // sha256 of the JSON arguments
+	Cap          string    `json:"cap,omitempty"`         // cap mint outcome, in cap proxy mode
+	Approval     string    `json:"approval"`              // e.g. "allowed", "approved", "declined"
+	DurationMS   int64     `json:"duration_ms"`
+	Error        string    `json:"error,omitempty"`
+	Prev         string    `json:"prev"` // hash of the previous entry, empty for the first
+	Hash         string    `json:"hash,omitempty"`
+}
+
+// ArgsDigest returns the digest of tool call arguments recorded in the
+// audit log. Arguments themselves are not logged; they may hold secrets.
+func ArgsDigest(args map[string]any) string {
+	data, err := json.Marshal(args) // map keys are sorted, so this is canonical
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// hash returns the entry's hash: the SHA-256 of its JSON without Hash.
+func (e AuditEntry) hash() (string, error) {
+	e.Hash = ""
+	data, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// GetAuditPath returns the path to the audit log
+func GetAuditPath() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get home directory: %w", err)
+	}
+	return filepath.Join(homeDir, ".mcper", AuditFile), nil
+}
+
+// AuditLog appends hash-chained entries to a JSONL file.
+type AuditLog struct {
+	path string
+	mu   sync.Mutex
+}
+
+// OpenAuditLog returns an audit log writing to `path`, creating its
+// directory if needed.
+func OpenAuditLog(path string) (*AuditLog, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create audit log directory: %w", err)
+	}
+	return &AuditLog{path: path}, nil
+}
+
+// Append chains `entry` to the last one in the log and writes it. Time is
+// set if empty.
+func (l *AuditLog) Append(entry AuditEntry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	unlock, err := lockFile(l.path + ".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer f.Close()
+
+	prev, err := lastAuditHash(f)
+	if err != nil {
+		return err
+	}
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+	entry.Time = entry.Time.UTC()
+	entry.Prev = prev
+	if entry.Hash, err = entry.hash(); err != nil {
+		return err
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if len(line) >= auditMaxEntry {
+		return fmt.Errorf("audit entry for %s is too large (%d bytes)", entry.Tool, len(line))
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write audit log: %w", err)
+	}
+	return nil
+}
+
+// lastAuditHash returns the hash of the last entry in the log, or "" if
+// it is empty.
+func lastAuditHash(f *os.File) (string, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	var last []byte
+	for end := info.Size(); ; {
+		offset := max(end-auditTailSize, 0)
+		chunk := make([]byte, end-offset)
+		if _, err := f.ReadAt(chunk, offset); err != nil && !errors.Is(err, io.EOF) {
+			return "", fmt.Errorf("failed to read audit log: %w", err)
+		}
+		last = append(chunk, last...)
+		end = offset
+		trimmed := bytes.TrimRight(last, "\n")
+		if i := bytes.LastIndexByte(trimmed, '\n'); i >= 0 || end == 0 {
+			last = trimmed[i+1:]
+			break
+		}
+	}
+	if len(last) == 0 {
+		return "", nil
+	}
+	var entry AuditEntry
+	if err := json.Unmarshal(last, &entry); err != nil {
+		return "", fmt.Errorf("audit log ends in a malformed entry: %w", err)
+	}
+	return entry.Hash, nil
+}
+
+// lockFile takes an exclusive OS lock on `path`, creating it if needed,
+// and returns a func that releases it. The lock keeps serve processes
+// sharing the log from forking the hash chain; the OS drops it if its
+// holder dies, so a crash can't leave the log locked.
+func lockFile(path string) (func(), error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock audit log: %w", err)
+	}
+	if err := lockExclusive(f); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to lock audit log: %w", err)
+	}
+	return func() {
+		unlockFile(f)
+		f.Close()
+	}, nil
+}
+
+// ReadAuditLog calls fn with each entry in the log at `path`, in order.
+func ReadAuditLog(path string, fn func(AuditEntry) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return readAudit(f, func(_ int, entry AuditEntry) error { return fn(entry) })
+}
+
+func readAudit(r io.Reader, fn func(line int, entry AuditEntry) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, auditTailSize), auditMaxEntry)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return fmt.Errorf("line %d: malformed entry: %w", line, err)
+		}
+		if err := fn(line, entry); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// VerifyAudit checks the hash chain of an audit log, returning how many
+// entries it holds. An edited entry fails its own hash and a deleted one
+// breaks the link from the entry after it. Entries cut from the end of the
+// log can't be detected this way.
+func VerifyAudit(r io.Reader) (int, error) {
+	count := 0
+	prev := ""
+	err := readAudit(r, func(line int, entry AuditEntry) error {
+		if entry.Prev != prev {
+			return fmt.Errorf("line %d: chain broken: previous hash is %q, entry expects %q", line, prev, entry.Prev)
+		}
+		want, err := entry.hash()
+		if err != nil {
+			return err
+		}
+		if entry.Hash != want {
+			return fmt.Errorf("line %d: entry was modified: hash is %q, contents hash to %q", line, entry.Hash, want)
+		}
+		prev = entry.Hash
+		count++
+		return nil
+	})
+	return count, err
+}
@@ -0,0 +1,158 @@
+package mcper
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeAuditLog(t *testing.T, tools ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), AuditFile)
+	log, err := OpenAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tool := range tools {
+		if err := log.Append(AuditEntry{Plugin: "github.wasm", Tool: tool, Approval: "allowed"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return path
+}
+
+func TestAuditLog_Chain(t *testing.T) {
+	path := writeAuditLog(t, "a", "b", "c")
+
+	var entries []AuditEntry
+	if err := ReadAuditLog(path, func(e AuditEntry) error {
+		entries = append(entries, e)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("got %d entries, want 3", len(entries))
+	}
+	if entries[0].Prev != "" {
+		t.Errorf("first entry chains to %q", entries[0].Prev)
+	}
+	for i := 1; i < len(entries); i++ {
+		if entries[i].Prev != entries[i-1].Hash {
+			t.Errorf("entry %d chains to %q, want %q", i, entries[i].Prev, entries[i-1].Hash)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := VerifyAudit(bytes.NewReader(data))
+	if err != nil || n != 3 {
+		t.Errorf("VerifyAudit = %d, %v; want 3, nil", n, err)
+	}
+}
+
+func TestAuditLog_LongEntries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), AuditFile)
+	log, err := OpenAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// An entry longer than auditTailSize, then one appended after it.
+	long := AuditEntry{Plugin: "github.wasm", Tool: "a", Approval: "allowed", Error: strings.Repeat("x", 2*auditTailSize)}
+	for _, entry := range []AuditEntry{long, {Plugin: "github.wasm", Tool: "b", Approval: "allowed"}} {
+		if err := log.Append(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := VerifyAudit(bytes.NewReader(data)); err != nil || n != 2 {
+		t.Errorf("VerifyAudit = %d, %v; want 2, nil", n, err)
+	}
+
+	long.Error = strings.Repeat("x", auditMaxEntry)
+	if err := log.Append(long); err == nil {
+		t.Error("appended an entry longer than auditMaxEntry")
+	}
+}
+
+func TestLockFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), AuditFile+".lock")
+	// A lock file left behind by an earlier process doesn't hold the lock.
+	if err := os.WriteFile(path, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	unlock, err := lockFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	locked := make(chan func())
+	go func() {
+		unlock, err := lockFile(path)
+		if err != nil {
+			t.Error(err)
+			unlock = func() {}
+		}
+		locked <- unlock
+	}()
+	select {
+	case <-locked:
+		t.Fatal("lock taken twice")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlock()
+	select {
+	case unlock := <-locked:
+		unlock()
+	case <-time.After(5 * time.Second):
+		t.Fatal("lock not taken after release")
+	}
+}
+
+func TestVerifyAudit_Tampering(t *testing.T) {
+	data, err := os.ReadFile(writeAuditLog(t, "a", "b", "c"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.SplitAfter(string(data), "\n")
+
+	tests := []struct {
+		name    string
+		log     string
+		wantErr string
+	}{
+		{"edited", lines[0] + strings.Replace(lines[1], `"tool":"b"`, `"tool":"x"`, 1) + lines[2], "line 2: entry was modified"},
+		{"deleted", lines[0] + lines[2], "line 2: chain broken"},
+		{"reordered", lines[1] + lines[0] + lines[2], "line 1: chain broken"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := VerifyAudit(strings.NewReader(tt.log))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("VerifyAudit error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestArgsDigest(t *testing.T) {
+	a := ArgsDigest(map[string]any{"repo": "mcper", "title": "bug"})
+	b := ArgsDigest(map[string]any{"title": "bug", "repo": "mcper"})
+	if a != b {
+		t.Errorf("digest depends on key order: %s != %s", a, b)
+	}
+	if !strings.HasPrefix(a, "sha256:") {
+		t.Errorf("digest %q has no algorithm prefix", a)
+	}
+	if ArgsDigest(map[string]any{"repo": "other"}) == a {
+		t.Error("different arguments have the same digest")
+	}
+}
@@ -213,3 +213,101 @@ func CleanCache() error {
 
 	return nil
 }
+
+// CompiledCacheDir returns the directory holding compiled WASM code
+// (~/.mcper/cache/compiled). Each module compiles into a subdirectory named
+// after its SHA256, the same hash recorded in CacheMetadata.
+func CompiledCacheDir() (string, error) {
+	cacheDir, err := DefaultCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(cacheDir, "compiled"), nil
+}
+
+// CompiledCacheEntry is one module's compiled code.
+type CompiledCacheEntry struct {
+	SHA256   string
+	Path     string
+	Size     int64
+	LastUsed time.Time
+	Source   string // source of the cached plugin with this hash, if any
+}
+
+// ListCompiledCache lists the compiled cache, matching entries to cached
+// plugins by SHA256. Local plugins are never in the plugin cache, so their
+// entries have no Source.
+func ListCompiledCache() ([]CompiledCacheEntry, error) {
+	compiledDir, err := CompiledCacheDir()
+	if err != nil {
+		return nil, err
+	}
+	dirs, err := os.ReadDir(compiledDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list compiled cache: %w", err)
+	}
+
+	plugins, err := ListCachedPlugins()
+	if err != nil {
+		return nil, err
+	}
+	sources := make(map[string]string)
+	for _, p := range plugins {
+		if p.Metadata != nil {
+			sources[p.Metadata.SHA256] = p.Metadata.Source
+		}
+	}
+
+	var entries []CompiledCacheEntry
+	for _, d := range dirs {
+		if !d.IsDir() {
+			continue
+		}
+		info, err := d.Info()
+		if err != nil {
+			continue
+		}
+		entry := CompiledCacheEntry{
+			SHA256:   d.Name(),
+			Path:     filepath.Join(compiledDir, d.Name()),
+			LastUsed: info.ModTime(),
+			Source:   sources[d.Name()],
+		}
+		filepath.Walk(entry.Path, func(_ string, fi os.FileInfo, err error) error {
+			if err == nil && !fi.IsDir() {
+				entry.Size += fi.Size()
+			}
+			return nil
+		})
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// PruneCompiledCache removes compiled code no cached plugin refers to (for
+// example, superseded plugin versions), or every entry when all is set.
+// When olderThan is non-zero, only entries unused for that long are removed.
+// It returns the removed entries.
+func PruneCompiledCache(all bool, olderThan time.Duration) ([]CompiledCacheEntry, error) {
+	entries, err := ListCompiledCache()
+	if err != nil {
+		return nil, err
+	}
+	var removed []CompiledCacheEntry
+	for _, entry := range entries {
+		if !all && entry.Source != "" {
+			continue
+		}
+		if olderThan > 0 && time.Since(entry.LastUsed) < olderThan {
+			continue
+		}
+		if err := os.RemoveAll(entry.Path); err != nil {
+			return removed, fmt.Errorf("failed to remove %s: %w", entry.Path, err)
+		}
+		removed = append(removed, entry)
+	}
+	return removed, nil
+}
@@ -0,0 +1,74 @@
+package mcper
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCompiledCache_ListAndPrune(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	plugin, err := ParsePluginSource("https://storage.googleapis.com/mcper-releases/v1.0.0/plugin-github.wasm")
+	if err != nil {
+		t.Fatalf("ParsePluginSource: %v", err)
+	}
+	entry, err := SaveToCache(plugin, []byte("\x00asm\x01\x00\x00\x00"), nil, nil)
+	if err != nil {
+		t.Fatalf("SaveToCache: %v", err)
+	}
+
+	compiledDir, err := CompiledCacheDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sha := range []string{entry.Metadata.SHA256, "deadbeef", "cafef00d"} {
+		dir := filepath.Join(compiledDir, sha)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "code"), []byte("compiled"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := time.Now().Add(-48 * time.Hour)
+	os.Chtimes(filepath.Join(compiledDir, "deadbeef"), old, old)
+
+	entries, err := ListCompiledCache()
+	if err != nil {
+		t.Fatalf("ListCompiledCache: %v", err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("got %d entries, want 3", len(entries))
+	}
+	for _, e := range entries {
+		if wantSource := e.SHA256 == entry.Metadata.SHA256; (e.Source != "") != wantSource {
+			t.Errorf("entry %s Source = %q", e.SHA256, e.Source)
+		}
+		if e.Size != int64(len("compiled")) {
+			t.Errorf("entry %s Size = %d", e.SHA256, e.Size)
+		}
+	}
+
+	removed, err := PruneCompiledCache(false, 24*time.Hour)
+	if err != nil {
+		t.Fatalf("PruneCompiledCache: %v", err)
+	}
+	if len(removed) != 1 || removed[0].SHA256 != "deadbeef" {
+		t.Errorf("prune --older-than removed %+v, want only deadbeef", removed)
+	}
+
+	removed, err = PruneCompiledCache(false, 0)
+	if err != nil {
+		t.Fatalf("PruneCompiledCache: %v", err)
+	}
+	if len(removed) != 1 || removed[0].SHA256 != "cafef00d" {
+		t.Errorf("prune removed %+v, want only cafef00d", removed)
+	}
+
+	if removed, _ = PruneCompiledCache(true, 0); len(removed) != 1 {
+		t.Errorf("prune --all removed %d entries, want 1", len(removed))
+	}
+}
@@ -57,15 +57,22 @@ type PendingApproval struct {
 // CloudClient is the cap-mint client. Authenticates with the CLI's
 // stored API key bearer token.
 type CloudClient struct {
-	creds  *Credentials
-	http   *http.Client
+	creds func() *Credentials // read on every request
+	http  *http.Client
 }
 
 // NewCloudClient returns a client for the given credentials. http.Client
 // defaults to 60s timeout.
 func NewCloudClient(creds *Credentials) *CloudClient {
+	return NewCloudClientFrom(func() *Credentials { return creds })
+}
+
+// NewCloudClientFrom returns a client that asks `current` for the
+// credentials on each request, e.g. KeyRefresher.Credentials so a renewed
+// API key is used at once.
+func NewCloudClientFrom(current func() *Credentials) *CloudClient {
 	return &CloudClient{
-		creds: creds,
+		creds: current,
 		http: &http.Client{
 			Timeout: 60 * time.Second,
 		},
@@ -92,12 +99,14 @@ func (c *CloudClient) MintCap(ctx context.Context, req *CapMintRequest) (*Cap, *
 	if err != nil {
 		return nil, nil, err
 	}
-	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.creds.CloudURL+"/api/cap/mint", bytes.NewReader(body))
+	creds := c.creds()
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, creds.CloudURL+"/api/cap/mint", bytes.NewReader(body))
 	if err != nil {
 		return nil, nil, err
 	}
 	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("Authorization", "Bearer "+c.creds.APIKey)
+	httpReq.Header.Set("Authorization", "Bearer "+creds.APIKey)
+	InjectTraceparent(ctx, httpReq.Header)
 	resp, err := c.http.Do(httpReq)
 	if err != nil {
 		return nil, nil, err
@@ -136,11 +145,13 @@ func (c *CloudClient) MintCap(ctx context.Context, req *CapMintRequest) (*Cap, *
 func (c *CloudClient) PollCap(ctx context.Context, pending *PendingApproval) (*Cap, error) {
 	backoff := 1 * time.Second
 	for {
-		req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.creds.CloudURL+pending.PollURL, nil)
+		creds := c.creds()
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, creds.CloudURL+pending.PollURL, nil)
 		if err != nil {
 			return nil, err
 		}
-		req.Header.Set("Authorization", "Bearer "+c.creds.APIKey)
+		req.Header.Set("Authorization", "Bearer "+creds.APIKey)
+		InjectTraceparent(ctx, req.Header)
 		resp, err := c.http.Do(req)
 		if err != nil {
 			return nil, err
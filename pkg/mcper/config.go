@@ -9,6 +9,7 @@ import (
 // Config represents the mcper configuration (embedded in serve.sh)
 type Config struct {
 	Plugins []PluginConfig `json:"plugins"`
+	Profile string         `json:"profile,omitempty"` // mcper-cloud profile pinned for the project
 }
 
 // PluginConfig represents a single plugin configuration
@@ -16,14 +17,20 @@ type PluginConfig struct {
 	Source           string            `json:"source"`
 	Env              map[string]string `json:"env,omitempty"`
 	Permissions      *Permissions      `json:"permissions,omitempty"`
-	IsCloud          bool              `json:"-"` // Internal: true for plugins fetched from mcper-cloud
+	Limits           *Limits           `json:"limits,omitempty"`
+	Pool             *PoolConfig       `json:"pool,omitempty"`
+	IdleTimeout      Duration          `json:"idle_timeout,omitempty"`       // stop WASM instances after this long without calls
+	Tools            *ToolRules        `json:"tools,omitempty"`              // which tools to forward and what to call them
+	IsCloud          bool              `json:"-"`                            // Internal: true for plugins fetched from mcper-cloud
 	ForceLegacyProxy bool              `json:"force_legacy_proxy,omitempty"` // PR 7: per-plugin emergency rollback to /api/forward
 }
 
 // Permissions defines what a plugin is allowed to do
 type Permissions struct {
-	Network    []string `json:"network,omitempty"`    // Allowed hosts
-	Filesystem []string `json:"filesystem,omitempty"` // Allowed paths
+	Network     []string `json:"network,omitempty"`     // Allowed hosts
+	Filesystem  []string `json:"filesystem,omitempty"`  // Allowed paths
+	Sampling    bool     `json:"sampling,omitempty"`    // May ask the client's model for completions
+	Elicitation bool     `json:"elicitation,omitempty"` // May ask the user for input
 }
 
 // ParseConfig parses a JSON config string into a Config struct
@@ -2,6 +2,7 @@ package mcper
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
@@ -16,6 +17,10 @@ type Credentials struct {
 	UserID    string    `json:"user_id"`
 	CloudURL  string    `json:"cloud_url"`
 	ExpiresAt time.Time `json:"expires_at"`
+
+	// Profile is the profile the credentials belong to, set when they're
+	// loaded and used to save them; empty means DefaultProfile.
+	Profile string `json:"-"`
 }
 
 // DefaultCloudURL is the default mcper-cloud server URL
@@ -29,10 +34,10 @@ func (c *Credentials) GetCapProxyURL() string {
 	return c.CloudURL + "/proxy_v2"
 }
 
-// CredentialsFile is the filename for stored credentials
+// CredentialsFile is the filename for credentials stored in plaintext
 const CredentialsFile = "credentials.json"
 
-// GetCredentialsPath returns the path to the credentials file
+// GetCredentialsPath returns the path to the plaintext credentials file
 func GetCredentialsPath() (string, error) {
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
@@ -42,22 +47,42 @@ func GetCredentialsPath() (string, error) {
 	return filepath.Join(homeDir, ".mcper", CredentialsFile), nil
 }
 
-// LoadCredentials loads credentials from the default location
+// LoadCredentials loads the credentials of the profile selected by
+// $MCPER_PROFILE, or of DefaultProfile
 func LoadCredentials() (*Credentials, error) {
-	path, err := GetCredentialsPath()
+	profile, err := SelectProfile("", "")
 	if err != nil {
 		return nil, err
 	}
 
-	return LoadCredentialsFromPath(path)
+	return LoadProfileCredentials(profile)
+}
+
+// LoadProfileCredentials loads a profile's credentials from its credential
+// store
+func LoadProfileCredentials(profile string) (*Credentials, error) {
+	store, err := CredentialStoreForProfile(profile)
+	if err != nil {
+		return nil, err
+	}
+
+	creds, err := store.Load()
+	if err != nil {
+		if profile != DefaultProfile && errors.Is(err, ErrNotLoggedIn) {
+			return nil, fmt.Errorf("%w to profile %s", ErrNotLoggedIn, profile)
+		}
+		return nil, err
+	}
+	creds.Profile = profile
+	return creds, nil
 }
 
-// LoadCredentialsFromPath loads credentials from a specific path
+// LoadCredentialsFromPath loads plaintext credentials from a specific path
 func LoadCredentialsFromPath(path string) (*Credentials, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return nil, fmt.Errorf("not logged in: credentials file not found")
+			return nil, fmt.Errorf("%w: credentials file not found", ErrNotLoggedIn)
 		}
 		return nil, fmt.Errorf("failed to read credentials: %w", err)
 	}
@@ -70,49 +95,42 @@ func LoadCredentialsFromPath(path string) (*Credentials, error) {
 	return &creds, nil
 }
 
-// SaveCredentials saves credentials to the default location
+// SaveCredentials saves credentials to the credential store of their
+// profile
 func SaveCredentials(creds *Credentials) error {
-	path, err := GetCredentialsPath()
+	profile := creds.Profile
+	if profile == "" {
+		profile = DefaultProfile
+	}
+	store, err := CredentialStoreForProfile(profile)
 	if err != nil {
 		return err
 	}
 
-	return SaveCredentialsToPath(creds, path)
+	return store.Save(creds)
 }
 
-// SaveCredentialsToPath saves credentials to a specific path
+// SaveCredentialsToPath saves credentials in plaintext to a specific path
 func SaveCredentialsToPath(creds *Credentials, path string) error {
-	// Ensure the directory exists
-	dir := filepath.Dir(path)
-	if err := os.MkdirAll(dir, 0700); err != nil {
-		return fmt.Errorf("failed to create credentials directory: %w", err)
-	}
-
 	data, err := json.MarshalIndent(creds, "", "  ")
 	if err != nil {
 		return fmt.Errorf("failed to marshal credentials: %w", err)
 	}
 
-	// Write with restricted permissions (owner read/write only)
-	if err := os.WriteFile(path, data, 0600); err != nil {
-		return fmt.Errorf("failed to write credentials: %w", err)
-	}
-
-	return nil
+	// Replace atomically, with restricted permissions (owner read/write
+	// only), so a running mcper serve never reads a half-written key
+	return writeFileAtomic(path, data, 0600)
 }
 
-// DeleteCredentials removes the credentials file
-func DeleteCredentials() error {
-	path, err := GetCredentialsPath()
+// DeleteCredentials removes a profile's credentials from its credential
+// store, and any left in plaintext
+func DeleteCredentials(profile string) error {
+	store, err := CredentialStoreForProfile(profile)
 	if err != nil {
 		return err
 	}
 
-	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
-		return fmt.Errorf("failed to delete credentials: %w", err)
-	}
-
-	return nil
+	return store.Delete()
 }
 
 // IsValid checks if the credentials are valid and not expired
@@ -138,9 +156,10 @@ func (c *Credentials) GetProxyURL() string {
 	return c.CloudURL + "/api/forward"
 }
 
-// IsLoggedIn checks if the user is logged in with valid credentials
-func IsLoggedIn() bool {
-	creds, err := LoadCredentials()
+// IsLoggedIn checks if the user is logged in to `profile` with valid
+// credentials. Commands pass the profile SelectProfile resolved for them.
+func IsLoggedIn(profile string) bool {
+	creds, err := LoadProfileCredentials(profile)
 	if err != nil {
 		return false
 	}
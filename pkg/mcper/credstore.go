@@ -0,0 +1,414 @@
+package mcper
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// CredentialStoreEnv selects where LoadCredentials and SaveCredentials keep
+// credentials: CredentialStoreFile (the default), CredentialStoreKeyring
+// or CredentialStorePlaintext.
+const CredentialStoreEnv = "MCPER_CREDENTIAL_STORE"
+
+// Credential store kinds.
+const (
+	CredentialStoreFile      = "file"      // ~/.mcper/credentials.enc, encrypted
+	CredentialStoreKeyring   = "keyring"   // the OS keyring
+	CredentialStorePlaintext = "plaintext" // ~/.mcper/credentials.json, as before
+)
+
+// CredentialsPassphraseEnv, when set, encrypts the credentials file with a
+// key derived from this passphrase rather than from the machine's ID.
+const CredentialsPassphraseEnv = "MCPER_CREDENTIALS_PASSPHRASE"
+
+// EncryptedCredentialsFile is the filename of the encrypted credentials.
+const EncryptedCredentialsFile = "credentials.enc"
+
+// ErrNotLoggedIn is returned when a credential store holds no credentials.
+var ErrNotLoggedIn = errors.New("not logged in")
+
+// Keyring service and account of the credentials in the OS keyring.
+const (
+	credentialsKeyringService = "mcper"
+	credentialsKeyringAccount = "cloud-credentials"
+)
+
+// CredentialStore keeps the mcper-cloud credentials.
+type CredentialStore interface {
+	// Load returns the stored credentials, or an error wrapping
+	// ErrNotLoggedIn if there are none.
+	Load() (*Credentials, error)
+	Save(creds *Credentials) error
+	// Delete removes the credentials; it is not an error if there are none.
+	Delete() error
+	// String says where the credentials are kept, for messages.
+	String() string
+}
+
+// CredentialStoreForProfile returns the store of a profile's credentials,
+// of the kind selected by $MCPER_CREDENTIAL_STORE. Unless that is
+// CredentialStorePlaintext, credentials found in plaintext (e.g.
+// ~/.mcper/credentials.json) are moved into the store the first time
+// they're loaded.
+func CredentialStoreForProfile(profile string) (CredentialStore, error) {
+	if err := ValidateProfile(profile); err != nil {
+		return nil, err
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get home directory: %w", err)
+	}
+	dir := filepath.Join(homeDir, ".mcper")
+	legacy := &PlaintextCredentialStore{Path: filepath.Join(dir, profileFilename(CredentialsFile, profile))}
+
+	var store CredentialStore
+	switch kind := os.Getenv(CredentialStoreEnv); kind {
+	case "", CredentialStoreFile:
+		store = &EncryptedCredentialStore{
+			Path:       filepath.Join(dir, profileFilename(EncryptedCredentialsFile, profile)),
+			Passphrase: os.Getenv(CredentialsPassphraseEnv),
+		}
+	case CredentialStoreKeyring:
+		keyring := osKeyring()
+		if path := os.Getenv(KeyringFileEnv); path != "" {
+			keyring = &FileKeyring{Path: path}
+		}
+		if keyring == nil {
+			return nil, fmt.Errorf("%s=%s: no OS keyring available (need security on macOS or secret-tool on Linux)", CredentialStoreEnv, kind)
+		}
+		store = &KeyringCredentialStore{Keyring: keyring, Profile: profile}
+	case CredentialStorePlaintext:
+		return legacy, nil
+	default:
+		return nil, fmt.Errorf("invalid %s %q (want %s, %s or %s)", CredentialStoreEnv, kind, CredentialStoreFile, CredentialStoreKeyring, CredentialStorePlaintext)
+	}
+	return &migratingCredentialStore{store: store, legacy: legacy}, nil
+}
+
+// migratingCredentialStore moves plaintext credentials into `store`.
+type migratingCredentialStore struct {
+	store  CredentialStore
+	legacy *PlaintextCredentialStore
+}
+
+func (m *migratingCredentialStore) Load() (*Credentials, error) {
+	creds, err := m.store.Load()
+	if !errors.Is(err, ErrNotLoggedIn) {
+		return creds, err
+	}
+	creds, legacyErr := m.legacy.Load()
+	if legacyErr != nil {
+		return nil, err
+	}
+	// The plaintext file is only removed once the credentials are safely
+	// in the new store; otherwise they're still usable and the move is
+	// retried next time.
+	if err := m.store.Save(creds); err == nil {
+		m.legacy.Delete()
+	}
+	return creds, nil
+}
+
+func (m *migratingCredentialStore) Save(creds *Credentials) error {
+	if err := m.store.Save(creds); err != nil {
+		return err
+	}
+	return m.legacy.Delete()
+}
+
+func (m *migratingCredentialStore) Delete() error {
+	if err := m.store.Delete(); err != nil {
+		return err
+	}
+	return m.legacy.Delete()
+}
+
+func (m *migratingCredentialStore) String() string {
+	return m.store.String()
+}
+
+// PlaintextCredentialStore keeps credentials as JSON in a file readable
+// only by its owner.
+type PlaintextCredentialStore struct {
+	Path string
+}
+
+func (s *PlaintextCredentialStore) Load() (*Credentials, error) {
+	return LoadCredentialsFromPath(s.Path)
+}
+
+func (s *PlaintextCredentialStore) Save(creds *Credentials) error {
+	return SaveCredentialsToPath(creds, s.Path)
+}
+
+func (s *PlaintextCredentialStore) Delete() error {
+	if err := os.Remove(s.Path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete credentials: %w", err)
+	}
+	return nil
+}
+
+func (s *PlaintextCredentialStore) String() string {
+	return s.Path
+}
+
+// KeyringCredentialStore keeps a profile's credentials in a keyring.
+type KeyringCredentialStore struct {
+	Keyring Keyring
+	Profile string // empty for DefaultProfile
+}
+
+func (s *KeyringCredentialStore) account() string {
+	if s.Profile == "" || s.Profile == DefaultProfile {
+		return credentialsKeyringAccount
+	}
+	return credentialsKeyringAccount + "-" + s.Profile
+}
+
+func (s *KeyringCredentialStore) Load() (*Credentials, error) {
+	data, err := s.Keyring.Get(credentialsKeyringService, s.account())
+	if errors.Is(err, ErrSecretNotFound) {
+		return nil, fmt.Errorf("%w: no credentials in keyring", ErrNotLoggedIn)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read credentials from keyring: %w", err)
+	}
+	var creds Credentials
+	if err := json.Unmarshal([]byte(data), &creds); err != nil {
+		return nil, fmt.Errorf("failed to parse credentials: %w", err)
+	}
+	return &creds, nil
+}
+
+func (s *KeyringCredentialStore) Save(creds *Credentials) error {
+	data, err := json.Marshal(creds)
+	if err != nil {
+		return fmt.Errorf("failed to marshal credentials: %w", err)
+	}
+	if err := s.Keyring.Set(credentialsKeyringService, s.account(), string(data)); err != nil {
+		return fmt.Errorf("failed to write credentials to keyring: %w", err)
+	}
+	return nil
+}
+
+func (s *KeyringCredentialStore) Delete() error {
+	err := s.Keyring.Delete(credentialsKeyringService, s.account())
+	if err != nil && !errors.Is(err, ErrSecretNotFound) {
+		return fmt.Errorf("failed to delete credentials from keyring: %w", err)
+	}
+	return nil
+}
+
+func (s *KeyringCredentialStore) String() string {
+	return "the OS keyring"
+}
+
+// Key derivation of the encrypted credentials file.
+const (
+	kdfMachine    = "hkdf-sha256-machine-id"
+	kdfPassphrase = "pbkdf2-sha256"
+
+	// passphraseIterations follows OWASP's recommendation for
+	// PBKDF2-HMAC-SHA256.
+	passphraseIterations = 600_000
+
+	credentialsKeyInfo = "mcper credentials v1"
+)
+
+// encryptedCredentials is the on-disk form of EncryptedCredentialStore:
+// the credentials JSON sealed with AES-256-GCM.
+type encryptedCredentials struct {
+	Version    int    `json:"version"`
+	KDF        string `json:"kdf"`
+	Iterations int    `json:"iterations,omitempty"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// EncryptedCredentialStore keeps credentials in a file encrypted with a key
+// derived from Passphrase, or if that is empty from the machine's ID and
+// the user's uid. Neither is in the home directory, so a copy of it alone
+// doesn't reveal the API key.
+type EncryptedCredentialStore struct {
+	Path       string
+	Passphrase string
+
+	// machineID overrides readMachineID in tests.
+	machineID func() (string, error)
+}
+
+func (s *EncryptedCredentialStore) key(kdf string, salt []byte, iterations int) ([]byte, error) {
+	switch kdf {
+	case kdfPassphrase:
+		if s.Passphrase == "" {
+			return nil, fmt.Errorf("credentials are protected by a passphrase; set %s", CredentialsPassphraseEnv)
+		}
+		return pbkdf2.Key(sha256.New, s.Passphrase, salt, iterations, 32)
+	case kdfMachine:
+		readID := s.machineID
+		if readID == nil {
+			readID = readMachineID
+		}
+		id, err := readID()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read machine ID (set %s to use a passphrase instead): %w", CredentialsPassphraseEnv, err)
+		}
+		secret := id + "\x00" + strconv.Itoa(os.Getuid())
+		return hkdf.Key(sha256.New, []byte(secret), salt, credentialsKeyInfo, 32)
+	}
+	return nil, fmt.Errorf("unknown credentials key derivation %q", kdf)
+}
+
+func (s *EncryptedCredentialStore) Load() (*Credentials, error) {
+	data, err := os.ReadFile(s.Path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: credentials file not found", ErrNotLoggedIn)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read credentials: %w", err)
+	}
+	var sealed encryptedCredentials
+	if err := json.Unmarshal(data, &sealed); err != nil {
+		return nil, fmt.Errorf("failed to parse credentials: %w", err)
+	}
+	if sealed.Version != 1 {
+		return nil, fmt.Errorf("unsupported credentials file version %d", sealed.Version)
+	}
+	key, err := s.key(sealed.KDF, sealed.Salt, sealed.Iterations)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newCredentialsAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := aead.Open(nil, sealed.Nonce, sealed.Ciphertext, []byte(credentialsKeyInfo))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt credentials (wrong passphrase, or copied from another machine?); run mcper login again")
+	}
+	var creds Credentials
+	if err := json.Unmarshal(plaintext, &creds); err != nil {
+		return nil, fmt.Errorf("failed to parse credentials: %w", err)
+	}
+	return &creds, nil
+}
+
+func (s *EncryptedCredentialStore) Save(creds *Credentials) error {
+	plaintext, err := json.Marshal(creds)
+	if err != nil {
+		return fmt.Errorf("failed to marshal credentials: %w", err)
+	}
+	sealed := encryptedCredentials{Version: 1, KDF: kdfMachine, Salt: make([]byte, 16)}
+	if s.Passphrase != "" {
+		sealed.KDF, sealed.Iterations = kdfPassphrase, passphraseIterations
+	}
+	rand.Read(sealed.Salt)
+	key, err := s.key(sealed.KDF, sealed.Salt, sealed.Iterations)
+	if err != nil {
+		return err
+	}
+	aead, err := newCredentialsAEAD(key)
+	if err != nil {
+		return err
+	}
+	sealed.Nonce = make([]byte, aead.NonceSize())
+	rand.Read(sealed.Nonce)
+	sealed.Ciphertext = aead.Seal(nil, sealed.Nonce, plaintext, []byte(credentialsKeyInfo))
+
+	data, err := json.MarshalIndent(sealed, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal credentials: %w", err)
+	}
+	return writeFileAtomic(s.Path, data, 0600)
+}
+
+func (s *EncryptedCredentialStore) Delete() error {
+	if err := os.Remove(s.Path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete credentials: %w", err)
+	}
+	return nil
+}
+
+func (s *EncryptedCredentialStore) String() string {
+	return s.Path + " (encrypted)"
+}
+
+func newCredentialsAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// writeFileAtomic writes data to a temporary file beside path and renames
+// it into place, so readers never see a partial file.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create credentials directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to write credentials: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write credentials: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write credentials: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write credentials: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write credentials: %w", err)
+	}
+	return nil
+}
+
+var ioregUUID = regexp.MustCompile(`"IOPlatformUUID" = "([^"]+)"`)
+
+// readMachineID returns an ID that is stable for this machine and kept
+// outside the home directory.
+func readMachineID() (string, error) {
+	switch runtime.GOOS {
+	case "linux":
+		for _, path := range []string{"/etc/machine-id", "/var/lib/dbus/machine-id"} {
+			if data, err := os.ReadFile(path); err == nil {
+				if id := strings.TrimSpace(string(data)); id != "" {
+					return id, nil
+				}
+			}
+		}
+		return "", fmt.Errorf("no /etc/machine-id")
+	case "darwin":
+		out, err := exec.Command("ioreg", "-rd1", "-c", "IOPlatformExpertDevice").Output()
+		if err != nil {
+			return "", fmt.Errorf("ioreg failed: %w", err)
+		}
+		m := ioregUUID.FindSubmatch(out)
+		if m == nil {
+			return "", fmt.Errorf("no IOPlatformUUID in ioreg output")
+		}
+		return string(m[1]), nil
+	}
+	return "", fmt.Errorf("machine ID not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,188 @@
+package mcper
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testCredentials() *Credentials {
+	return &Credentials{
+		APIKey:    "mcper_live_0123456789abcdef",
+		UserEmail: "test@example.com",
+		UserID:    "user-123",
+		CloudURL:  "https://cloud.example",
+		ExpiresAt: time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+}
+
+func fixedMachineID(id string) func() (string, error) {
+	return func() (string, error) { return id, nil }
+}
+
+func TestEncryptedCredentialStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), EncryptedCredentialsFile)
+	want := testCredentials()
+
+	tests := []struct {
+		name  string
+		store *EncryptedCredentialStore
+		// other must not be able to decrypt what store saved
+		other *EncryptedCredentialStore
+	}{
+		{
+			name:  "machine key",
+			store: &EncryptedCredentialStore{Path: path, machineID: fixedMachineID("machine-a")},
+			other: &EncryptedCredentialStore{Path: path, machineID: fixedMachineID("machine-b")},
+		},
+		{
+			name:  "passphrase",
+			store: &EncryptedCredentialStore{Path: path, Passphrase: "correct horse"},
+			other: &EncryptedCredentialStore{Path: path, Passphrase: "battery staple"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.store.Save(want); err != nil {
+				t.Fatal(err)
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Contains(data, []byte(want.APIKey)) || bytes.Contains(data, []byte(want.UserEmail)) {
+				t.Errorf("credentials file contains plaintext:\n%s", data)
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Mode().Perm() != 0600 {
+				t.Errorf("credentials mode = %v, want 0600", info.Mode().Perm())
+			}
+
+			got, err := tt.store.Load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *got != *want {
+				t.Errorf("Load = %+v, want %+v", got, want)
+			}
+			if _, err := tt.other.Load(); err == nil {
+				t.Error("credentials decrypted with the wrong key")
+			}
+		})
+	}
+
+	noPassphrase := &EncryptedCredentialStore{Path: path, machineID: fixedMachineID("machine-a")}
+	if _, err := noPassphrase.Load(); err == nil {
+		t.Error("passphrase-protected credentials loaded without a passphrase")
+	}
+
+	if err := noPassphrase.Delete(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := noPassphrase.Load(); !errors.Is(err, ErrNotLoggedIn) {
+		t.Errorf("Load after Delete = %v, want ErrNotLoggedIn", err)
+	}
+	if err := noPassphrase.Delete(); err != nil {
+		t.Errorf("Delete of missing credentials = %v", err)
+	}
+}
+
+func TestKeyringCredentialStore(t *testing.T) {
+	store := &KeyringCredentialStore{Keyring: &FileKeyring{Path: filepath.Join(t.TempDir(), KeyringFile)}}
+	if _, err := store.Load(); !errors.Is(err, ErrNotLoggedIn) {
+		t.Fatalf("Load of empty keyring = %v, want ErrNotLoggedIn", err)
+	}
+	want := testCredentials()
+	if err := store.Save(want); err != nil {
+		t.Fatal(err)
+	}
+	got, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *got != *want {
+		t.Errorf("Load = %+v, want %+v", got, want)
+	}
+	if err := store.Delete(); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete(); err != nil {
+		t.Errorf("Delete of missing credentials = %v", err)
+	}
+}
+
+func TestCredentialStoreMigratesPlaintext(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv(CredentialStoreEnv, CredentialStoreKeyring)
+	t.Setenv(KeyringFileEnv, filepath.Join(home, KeyringFile))
+	t.Setenv(ProfileEnv, "")
+
+	legacyPath := filepath.Join(home, ".mcper", CredentialsFile)
+	want := testCredentials()
+	if err := SaveCredentialsToPath(want, legacyPath); err != nil {
+		t.Fatal(err)
+	}
+	want.Profile = DefaultProfile // set on load, not stored
+
+	got, err := LoadCredentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *got != *want {
+		t.Errorf("LoadCredentials = %+v, want %+v", got, want)
+	}
+	if _, err := os.Stat(legacyPath); !os.IsNotExist(err) {
+		t.Errorf("plaintext credentials still present after migration: %v", err)
+	}
+
+	// Now served from the keyring.
+	got, err = LoadCredentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *got != *want {
+		t.Errorf("LoadCredentials after migration = %+v, want %+v", got, want)
+	}
+
+	if err := DeleteCredentials(DefaultProfile); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadCredentials(); !errors.Is(err, ErrNotLoggedIn) {
+		t.Errorf("LoadCredentials after DeleteCredentials = %v, want ErrNotLoggedIn", err)
+	}
+}
+
+func TestCredentialStoreKinds(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	t.Setenv(CredentialStoreEnv, CredentialStorePlaintext)
+	store, err := CredentialStoreForProfile(DefaultProfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.(*PlaintextCredentialStore); !ok {
+		t.Errorf("plaintext store = %T", store)
+	}
+
+	t.Setenv(CredentialStoreEnv, "")
+	store, err = CredentialStoreForProfile(DefaultProfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(home, ".mcper", EncryptedCredentialsFile) + " (encrypted)"; store.String() != want {
+		t.Errorf("default store = %s, want %s", store, want)
+	}
+
+	t.Setenv(CredentialStoreEnv, "vault")
+	if _, err := CredentialStoreForProfile(DefaultProfile); err == nil {
+		t.Error("invalid store kind accepted")
+	}
+}
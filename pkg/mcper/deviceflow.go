@@ -0,0 +1,214 @@
+package mcper
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// DeviceCodeGrantType is the RFC 8628 grant type sent when polling for the
+// API key.
+const DeviceCodeGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+// Device flow endpoints on mcper-cloud.
+const (
+	deviceCodePath  = "/api/cli/device/code"
+	deviceTokenPath = "/api/cli/device/token"
+)
+
+// Device flow polling: the interval used when the cloud doesn't give one,
+// how much slow_down adds to it (both per RFC 8628), and the longest wait
+// while backing off from an unreachable cloud.
+const (
+	defaultDeviceInterval = 5 * time.Second
+	deviceSlowDown        = 5 * time.Second
+	maxDeviceInterval     = time.Minute
+)
+
+var (
+	// ErrDeviceFlowUnsupported is returned by DeviceLogin.Start when the
+	// cloud has no device flow endpoints.
+	ErrDeviceFlowUnsupported = errors.New("mcper-cloud does not support device login")
+	// ErrDeviceAccessDenied is returned when the user denies the login.
+	ErrDeviceAccessDenied = errors.New("login was denied")
+	// ErrDeviceCodeExpired is returned when the user code expires before
+	// the login is approved.
+	ErrDeviceCodeExpired = errors.New("login code expired")
+)
+
+// DeviceAuthorization is mcper-cloud's response to a device code request:
+// the code the user enters at VerificationURI, and how to poll for the
+// result.
+type DeviceAuthorization struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete,omitempty"` // with the user code filled in
+	ExpiresIn               int    `json:"expires_in"`                          // seconds
+	Interval                int    `json:"interval,omitempty"`                  // seconds between polls
+}
+
+// deviceTokenResponse is the body of a device token poll: the API key once
+// approved, or an RFC 8628 error code.
+type deviceTokenResponse struct {
+	APIKey    string `json:"api_key"`
+	UserID    string `json:"user_id"`
+	Email     string `json:"email"`
+	ExpiresAt string `json:"expires_at"`
+
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// DeviceLogin logs the CLI in to mcper-cloud with the RFC 8628 device
+// authorization flow, for terminals that can't open a browser.
+type DeviceLogin struct {
+	CloudURL   string
+	ClientInfo string // e.g. "mcper-cli/1.2.0 on host (linux)"
+	HTTP       *http.Client
+
+	// sleep waits between polls; tests replace it.
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+// NewDeviceLogin returns a device login against `cloudURL`.
+func NewDeviceLogin(cloudURL, clientInfo string) *DeviceLogin {
+	return &DeviceLogin{
+		CloudURL:   cloudURL,
+		ClientInfo: clientInfo,
+		HTTP:       &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (d *DeviceLogin) post(ctx context.Context, path string, body any) (int, []byte, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return 0, nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.CloudURL+path, bytes.NewReader(data))
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := d.HTTP.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(resp.Body)
+	return resp.StatusCode, raw, err
+}
+
+// Start requests a device code. The caller shows the user code and
+// verification URI, then calls Wait.
+func (d *DeviceLogin) Start(ctx context.Context) (*DeviceAuthorization, error) {
+	status, raw, err := d.post(ctx, deviceCodePath, map[string]string{"client_info": d.ClientInfo})
+	if err != nil {
+		return nil, fmt.Errorf("failed to request login code: %w", err)
+	}
+	switch {
+	case status == http.StatusNotFound || status == http.StatusMethodNotAllowed:
+		return nil, ErrDeviceFlowUnsupported
+	case status != http.StatusOK:
+		return nil, fmt.Errorf("failed to request login code (status %d)", status)
+	}
+	var auth DeviceAuthorization
+	if err := json.Unmarshal(raw, &auth); err != nil {
+		return nil, fmt.Errorf("failed to parse login code response: %w", err)
+	}
+	if auth.DeviceCode == "" || auth.UserCode == "" || auth.VerificationURI == "" {
+		return nil, fmt.Errorf("incomplete login code response")
+	}
+	return &auth, nil
+}
+
+// Wait polls until the user approves the login, returning credentials for
+// CloudURL, or until they deny it, the code expires or ctx is done. The
+// poll interval grows on slow_down, and backs off exponentially while the
+// cloud can't be reached.
+func (d *DeviceLogin) Wait(ctx context.Context, auth *DeviceAuthorization) (*Credentials, error) {
+	sleep := d.sleep
+	if sleep == nil {
+		sleep = sleepContext
+	}
+	interval := time.Duration(auth.Interval) * time.Second
+	if interval <= 0 {
+		interval = defaultDeviceInterval
+	}
+	wait := interval
+	// Time spent sleeping stands in for the clock, so the expiry holds
+	// however long each poll takes.
+	remaining := time.Duration(auth.ExpiresIn) * time.Second
+
+	for {
+		if auth.ExpiresIn > 0 && remaining <= 0 {
+			return nil, ErrDeviceCodeExpired
+		}
+		if err := sleep(ctx, wait); err != nil {
+			return nil, err
+		}
+		remaining -= wait
+
+		status, raw, err := d.post(ctx, deviceTokenPath, map[string]string{
+			"grant_type":  DeviceCodeGrantType,
+			"device_code": auth.DeviceCode,
+		})
+		if err != nil || status >= http.StatusInternalServerError {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			wait = min(wait*2, maxDeviceInterval)
+			continue
+		}
+
+		var resp deviceTokenResponse
+		if err := json.Unmarshal(raw, &resp); err != nil {
+			return nil, fmt.Errorf("failed to parse login response (status %d): %w", status, err)
+		}
+		if status == http.StatusOK && resp.APIKey != "" {
+			creds := &Credentials{
+				APIKey:    resp.APIKey,
+				UserEmail: resp.Email,
+				UserID:    resp.UserID,
+				CloudURL:  d.CloudURL,
+			}
+			if resp.ExpiresAt != "" {
+				creds.ExpiresAt, _ = time.Parse(time.RFC3339, resp.ExpiresAt)
+			}
+			return creds, nil
+		}
+
+		wait = interval
+		switch resp.Error {
+		case "authorization_pending":
+		case "slow_down":
+			interval += deviceSlowDown
+			wait = interval
+		case "access_denied":
+			return nil, ErrDeviceAccessDenied
+		case "expired_token":
+			return nil, ErrDeviceCodeExpired
+		default:
+			if resp.ErrorDescription != "" {
+				return nil, fmt.Errorf("login failed: %s: %s", resp.Error, resp.ErrorDescription)
+			}
+			return nil, fmt.Errorf("login failed (status %d): %s", status, resp.Error)
+		}
+	}
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
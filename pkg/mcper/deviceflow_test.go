@@ -0,0 +1,160 @@
+package mcper
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeDeviceCloud stands in for mcper-cloud's device flow endpoints. Each
+// token poll gets the next of `polls`, an RFC 8628 error code or "ok".
+type fakeDeviceCloud struct {
+	t     *testing.T
+	polls []string
+
+	mu       sync.Mutex
+	polled   int
+	clientID string
+}
+
+func (f *fakeDeviceCloud) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var body map[string]string
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		f.t.Errorf("%s: bad body: %v", r.URL.Path, err)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch r.URL.Path {
+	case deviceCodePath:
+		f.clientID = body["client_info"]
+		json.NewEncoder(w).Encode(DeviceAuthorization{
+			DeviceCode:      "device-123",
+			UserCode:        "WDJB-MJHT",
+			VerificationURI: "https://cloud.example/device",
+			ExpiresIn:       600,
+			Interval:        5,
+		})
+	case deviceTokenPath:
+		if body["grant_type"] != DeviceCodeGrantType || body["device_code"] != "device-123" {
+			f.t.Errorf("token poll body = %v", body)
+		}
+		result := f.polls[f.polled]
+		f.polled++
+		switch result {
+		case "ok":
+			json.NewEncoder(w).Encode(map[string]string{
+				"api_key":    "mcper_live_device",
+				"user_id":    "user-1",
+				"email":      "dev@example.com",
+				"expires_at": "2030-01-01T00:00:00Z",
+			})
+		case "unavailable":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": result})
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestDeviceLogin(t *testing.T) {
+	cloud := &fakeDeviceCloud{t: t, polls: []string{"authorization_pending", "slow_down", "unavailable", "authorization_pending", "ok"}}
+	server := httptest.NewServer(cloud)
+	defer server.Close()
+
+	var waits []time.Duration
+	login := NewDeviceLogin(server.URL, "mcper-cli/test")
+	login.sleep = func(ctx context.Context, d time.Duration) error {
+		waits = append(waits, d)
+		return nil
+	}
+
+	ctx := context.Background()
+	auth, err := login.Start(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auth.UserCode != "WDJB-MJHT" || auth.VerificationURI != "https://cloud.example/device" {
+		t.Errorf("Start = %+v", auth)
+	}
+	if cloud.clientID != "mcper-cli/test" {
+		t.Errorf("client_info = %q", cloud.clientID)
+	}
+
+	creds, err := login.Wait(ctx, auth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Credentials{
+		APIKey:    "mcper_live_device",
+		UserEmail: "dev@example.com",
+		UserID:    "user-1",
+		CloudURL:  server.URL,
+		ExpiresAt: time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	if *creds != want {
+		t.Errorf("Wait = %+v, want %+v", creds, want)
+	}
+
+	// 5s, +5s after slow_down, doubled while the cloud is down, and back
+	// to the interval once it answers.
+	wantWaits := []time.Duration{5 * time.Second, 5 * time.Second, 10 * time.Second, 20 * time.Second, 10 * time.Second}
+	if !slices.Equal(waits, wantWaits) {
+		t.Errorf("poll waits = %v, want %v", waits, wantWaits)
+	}
+}
+
+func TestDeviceLoginFailures(t *testing.T) {
+	tests := []struct {
+		polls []string
+		want  error
+	}{
+		{[]string{"authorization_pending", "access_denied"}, ErrDeviceAccessDenied},
+		{[]string{"expired_token"}, ErrDeviceCodeExpired},
+	}
+	for _, tt := range tests {
+		server := httptest.NewServer(&fakeDeviceCloud{t: t, polls: tt.polls})
+		login := NewDeviceLogin(server.URL, "mcper-cli/test")
+		login.sleep = func(context.Context, time.Duration) error { return nil }
+		auth, err := login.Start(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := login.Wait(context.Background(), auth); !errors.Is(err, tt.want) {
+			t.Errorf("polls %v: Wait = %v, want %v", tt.polls, err, tt.want)
+		}
+		server.Close()
+	}
+}
+
+func TestDeviceLoginExpiresLocally(t *testing.T) {
+	polls := make([]string, 200)
+	for i := range polls {
+		polls[i] = "authorization_pending"
+	}
+	server := httptest.NewServer(&fakeDeviceCloud{t: t, polls: polls})
+	defer server.Close()
+	login := NewDeviceLogin(server.URL, "mcper-cli/test")
+	login.sleep = func(context.Context, time.Duration) error { return nil }
+
+	auth := &DeviceAuthorization{DeviceCode: "device-123", ExpiresIn: 12, Interval: 5}
+	if _, err := login.Wait(context.Background(), auth); !errors.Is(err, ErrDeviceCodeExpired) {
+		t.Errorf("Wait = %v, want ErrDeviceCodeExpired", err)
+	}
+}
+
+func TestDeviceLoginUnsupported(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+	if _, err := NewDeviceLogin(server.URL, "").Start(context.Background()); !errors.Is(err, ErrDeviceFlowUnsupported) {
+		t.Errorf("Start = %v, want ErrDeviceFlowUnsupported", err)
+	}
+}
@@ -0,0 +1,227 @@
+package mcper
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// KeyringFile is the filename of the file-backed keyring used when the OS
+// has no keyring mcper can reach.
+const KeyringFile = "keyring.json"
+
+// KeyringFileEnv, when set, makes DefaultKeyring use a file-backed keyring
+// at that path instead of the OS keyring, e.g. in tests or on headless
+// machines.
+const KeyringFileEnv = "MCPER_KEYRING_FILE"
+
+// ErrSecretNotFound is returned by a Keyring with no secret for a service
+// and account.
+var ErrSecretNotFound = errors.New("secret not found in keyring")
+
+// Keyring stores secrets by service and account.
+type Keyring interface {
+	Get(service, account string) (string, error)
+	Set(service, account, secret string) error
+	Delete(service, account string) error
+}
+
+// DefaultKeyring returns the OS keyring (the macOS keychain, or the Secret
+// Service via secret-tool on Linux), falling back to ~/.mcper/keyring.json
+// when neither is available.
+func DefaultKeyring() (Keyring, error) {
+	if path := os.Getenv(KeyringFileEnv); path != "" {
+		return &FileKeyring{Path: path}, nil
+	}
+	if keyring := osKeyring(); keyring != nil {
+		return keyring, nil
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get home directory: %w", err)
+	}
+	return &FileKeyring{Path: filepath.Join(homeDir, ".mcper", KeyringFile)}, nil
+}
+
+// osKeyring returns the OS keyring, or nil if there is none mcper can use.
+func osKeyring() Keyring {
+	switch runtime.GOOS {
+	case "darwin":
+		if _, err := exec.LookPath("security"); err == nil {
+			return macKeyring{}
+		}
+	case "linux":
+		if _, err := exec.LookPath("secret-tool"); err == nil {
+			return secretToolKeyring{}
+		}
+	}
+	return nil
+}
+
+// FileKeyring keeps secrets in a JSON file readable only by its owner. It
+// is not encrypted; it stands in for an OS keyring where there is none.
+type FileKeyring struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+func keyringKey(service, account string) string {
+	return service + "/" + account
+}
+
+func (k *FileKeyring) load() (map[string]string, error) {
+	data, err := os.ReadFile(k.Path)
+	if os.IsNotExist(err) {
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keyring: %w", err)
+	}
+	secrets := map[string]string{}
+	if err := json.Unmarshal(data, &secrets); err != nil {
+		return nil, fmt.Errorf("failed to parse keyring %s: %w", k.Path, err)
+	}
+	return secrets, nil
+}
+
+func (k *FileKeyring) save(secrets map[string]string) error {
+	if err := os.MkdirAll(filepath.Dir(k.Path), 0700); err != nil {
+		return fmt.Errorf("failed to create keyring directory: %w", err)
+	}
+	data, err := json.MarshalIndent(secrets, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := k.Path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write keyring: %w", err)
+	}
+	if err := os.Rename(tmp, k.Path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write keyring: %w", err)
+	}
+	return nil
+}
+
+// Get returns the secret stored for service and account.
+func (k *FileKeyring) Get(service, account string) (string, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	secrets, err := k.load()
+	if err != nil {
+		return "", err
+	}
+	secret, ok := secrets[keyringKey(service, account)]
+	if !ok {
+		return "", ErrSecretNotFound
+	}
+	return secret, nil
+}
+
+// Set stores a secret for service and account, replacing any existing one.
+func (k *FileKeyring) Set(service, account, secret string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	secrets, err := k.load()
+	if err != nil {
+		return err
+	}
+	secrets[keyringKey(service, account)] = secret
+	return k.save(secrets)
+}
+
+// Delete removes the secret for service and account.
+func (k *FileKeyring) Delete(service, account string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	secrets, err := k.load()
+	if err != nil {
+		return err
+	}
+	key := keyringKey(service, account)
+	if _, ok := secrets[key]; !ok {
+		return ErrSecretNotFound
+	}
+	delete(secrets, key)
+	return k.save(secrets)
+}
+
+// runKeyringTool runs a keyring CLI with `stdin`, returning its trimmed
+// output. A non-zero exit is reported as ErrSecretNotFound when
+// `notFound` says so.
+func runKeyringTool(stdin string, notFound func(code int) bool, name string, args ...string) (string, error) {
+	cmd := exec.Command(name, args...)
+	cmd.Stdin = strings.NewReader(stdin)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && notFound != nil && notFound(exitErr.ExitCode()) {
+			return "", ErrSecretNotFound
+		}
+		return "", fmt.Errorf("%s failed: %w: %s", name, err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimRight(stdout.String(), "\r\n"), nil
+}
+
+// macKeyring uses the login keychain through security(1). Secrets are
+// passed on stdin in interactive mode so they don't show up in ps.
+type macKeyring struct{}
+
+// macItemNotFound is security's exit code for a missing keychain item.
+const macItemNotFound = 44
+
+func isMacNotFound(code int) bool { return code == macItemNotFound }
+
+// shellQuote quotes s for security's interactive command parser.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func (macKeyring) Get(service, account string) (string, error) {
+	return runKeyringTool("", isMacNotFound, "security", "find-generic-password", "-s", service, "-a", account, "-w")
+}
+
+func (macKeyring) Set(service, account, secret string) error {
+	command := fmt.Sprintf("add-generic-password -U -s %s -a %s -w %s\n", shellQuote(service), shellQuote(account), shellQuote(secret))
+	_, err := runKeyringTool(command, nil, "security", "-i")
+	return err
+}
+
+func (macKeyring) Delete(service, account string) error {
+	_, err := runKeyringTool("", isMacNotFound, "security", "delete-generic-password", "-s", service, "-a", account)
+	return err
+}
+
+// secretToolKeyring uses the freedesktop Secret Service (GNOME Keyring,
+// KWallet) through secret-tool(1), which exits 1 for a missing secret.
+type secretToolKeyring struct{}
+
+func isSecretToolNotFound(code int) bool { return code == 1 }
+
+func (secretToolKeyring) Get(service, account string) (string, error) {
+	secret, err := runKeyringTool("", isSecretToolNotFound, "secret-tool", "lookup", "service", service, "account", account)
+	if err == nil && secret == "" {
+		return "", ErrSecretNotFound
+	}
+	return secret, err
+}
+
+func (secretToolKeyring) Set(service, account, secret string) error {
+	label := "mcper: " + keyringKey(service, account)
+	_, err := runKeyringTool(secret, nil, "secret-tool", "store", "--label", label, "service", service, "account", account)
+	return err
+}
+
+func (secretToolKeyring) Delete(service, account string) error {
+	_, err := runKeyringTool("", isSecretToolNotFound, "secret-tool", "clear", "service", service, "account", account)
+	return err
+}
@@ -0,0 +1,67 @@
+package mcper
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Limits bounds the resources a WASM plugin may consume. Zero fields mean
+// no limit.
+type Limits struct {
+	MaxMemoryPages uint32   `json:"max_memory_pages,omitempty"` // 64 KiB wasm pages
+	CallTimeout    Duration `json:"call_timeout,omitempty"`     // per tool call, e.g. "30s"
+	Lifetime       Duration `json:"lifetime,omitempty"`         // total instance lifetime, e.g. "8h"
+}
+
+// PoolConfig bounds how many instances of a WASM plugin serve runs, so
+// concurrent tool calls are not queued behind a single instance.
+type PoolConfig struct {
+	Min int `json:"min"`
+	Max int `json:"max"`
+}
+
+// Bounds returns the pool size bounds. A nil PoolConfig means exactly one
+// instance, and an unset Max defaults to Min.
+func (p *PoolConfig) Bounds() (min, max int, err error) {
+	if p == nil {
+		return 1, 1, nil
+	}
+	min, max = p.Min, p.Max
+	if max == 0 {
+		max = min
+	}
+	if min < 0 || max < 1 {
+		return 0, 0, fmt.Errorf("invalid pool %d..%d: need min >= 0 and max >= 1", p.Min, p.Max)
+	}
+	if max < min {
+		return 0, 0, fmt.Errorf("invalid pool %d..%d: max is below min", p.Min, p.Max)
+	}
+	return min, max, nil
+}
+
+// Duration is a time.Duration that reads and writes JSON as a Go duration
+// string such as "30s" or "1h30m".
+type Duration time.Duration
+
+// MarshalJSON encodes the duration as a string.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON decodes a duration string. Negative durations are rejected.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"30s\": %w", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q: %w", s, err)
+	}
+	if v < 0 {
+		return fmt.Errorf("invalid duration %q: must not be negative", s)
+	}
+	*d = Duration(v)
+	return nil
+}
@@ -0,0 +1,78 @@
+package mcper
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseConfig_Limits(t *testing.T) {
+	cfg, err := ParseConfig([]byte(`{"plugins":[{"source":"p.wasm","limits":{"max_memory_pages":512,"call_timeout":"30s","lifetime":"8h"}}]}`))
+	if err != nil {
+		t.Fatalf("ParseConfig: %v", err)
+	}
+	l := cfg.Plugins[0].Limits
+	if l == nil {
+		t.Fatal("limits not parsed")
+	}
+	if l.MaxMemoryPages != 512 {
+		t.Errorf("MaxMemoryPages = %d, want 512", l.MaxMemoryPages)
+	}
+	if time.Duration(l.CallTimeout) != 30*time.Second {
+		t.Errorf("CallTimeout = %v, want 30s", time.Duration(l.CallTimeout))
+	}
+	if time.Duration(l.Lifetime) != 8*time.Hour {
+		t.Errorf("Lifetime = %v, want 8h", time.Duration(l.Lifetime))
+	}
+
+	out, err := cfg.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON: %v", err)
+	}
+	again, err := ParseConfig(out)
+	if err != nil {
+		t.Fatalf("round trip: %v", err)
+	}
+	if *again.Plugins[0].Limits != *l {
+		t.Errorf("round trip = %+v, want %+v", *again.Plugins[0].Limits, *l)
+	}
+}
+
+func TestParseConfig_InvalidLimits(t *testing.T) {
+	for _, limits := range []string{
+		`{"call_timeout":"soon"}`,
+		`{"call_timeout":30}`,
+		`{"lifetime":"-1h"}`,
+	} {
+		if _, err := ParseConfig([]byte(`{"plugins":[{"source":"p.wasm","limits":` + limits + `}]}`)); err == nil {
+			t.Errorf("ParseConfig(limits=%s) succeeded, want error", limits)
+		}
+	}
+}
+
+func TestPoolConfig_Bounds(t *testing.T) {
+	tests := []struct {
+		pool     *PoolConfig
+		min, max int
+		wantErr  bool
+	}{
+		{pool: nil, min: 1, max: 1},
+		{pool: &PoolConfig{Min: 1, Max: 4}, min: 1, max: 4},
+		{pool: &PoolConfig{Min: 2}, min: 2, max: 2},
+		{pool: &PoolConfig{Min: 0, Max: 3}, min: 0, max: 3},
+		{pool: &PoolConfig{}, wantErr: true},
+		{pool: &PoolConfig{Min: 4, Max: 2}, wantErr: true},
+		{pool: &PoolConfig{Min: -1, Max: 2}, wantErr: true},
+	}
+	for _, tt := range tests {
+		min, max, err := tt.pool.Bounds()
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%+v.Bounds() = %d, %d, want error", tt.pool, min, max)
+			}
+			continue
+		}
+		if err != nil || min != tt.min || max != tt.max {
+			t.Errorf("%+v.Bounds() = %d, %d, %v, want %d, %d", tt.pool, min, max, err, tt.min, tt.max)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package mcper
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// LogFile is the filename of mcper serve's log
+const LogFile = "mcper.log"
+
+// Log rotation defaults: mcper.log is rotated once it reaches
+// DefaultLogMaxSize, keeping DefaultLogBackups old files as mcper.log.1,
+// mcper.log.2 and so on.
+const (
+	DefaultLogMaxSize = 10 << 20
+	DefaultLogBackups = 3
+)
+
+// minSecretLen is the shortest registered secret that is redacted. Shorter
+// values would redact ordinary words.
+const minSecretLen = 6
+
+// secretPatterns redact credentials ScrubBearerTokens doesn't cover: API
+// keys and tokens assigned to a likely-named key, and well-known token
+// formats.
+var secretPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)\b((?:api[_-]?key|access[_-]?token|auth[_-]?token|token|secret|password|pat)"?\s*[:=]\s*"?)[^\s",}]+`),
+	regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{20,}|github_pat_[A-Za-z0-9_]{20,})`),
+	regexp.MustCompile(`\bxox[abprs]-[A-Za-z0-9-]{10,}`),
+}
+
+var (
+	secretsMu sync.RWMutex
+	secrets   = make(map[string]bool)
+)
+
+// RegisterSecret makes Scrub redact `value` wherever it appears, e.g. an
+// env var passed to a plugin or the cloud API key.
+func RegisterSecret(value string) {
+	if len(value) < minSecretLen {
+		return
+	}
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	secrets[value] = true
+}
+
+// Scrub redacts bearer tokens, API keys and registered secrets from a log
+// line.
+func Scrub(line string) string {
+	line = ScrubBearerTokens(line)
+	for i, p := range secretPatterns {
+		if i == 0 {
+			line = p.ReplaceAllString(line, "${1}<REDACTED>")
+		} else {
+			line = p.ReplaceAllString(line, "<REDACTED>")
+		}
+	}
+	secretsMu.RLock()
+	defer secretsMu.RUnlock()
+	for secret := range secrets {
+		line = strings.ReplaceAll(line, secret, "<REDACTED>")
+	}
+	return line
+}
+
+// RotatingFile is a log file that is rotated once it grows past a size.
+type RotatingFile struct {
+	path    string
+	maxSize int64
+	backups int
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+// OpenRotatingFile opens `path` for appending. Once it would grow past
+// maxSize it is renamed to path.1, older files shifting up to
+// path.<backups> and the oldest being removed.
+func OpenRotatingFile(path string, maxSize int64, backups int) (*RotatingFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %w", err)
+	}
+	r := &RotatingFile{path: path, maxSize: maxSize, backups: backups}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *RotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f, r.size = f, info.Size()
+	return nil
+}
+
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *RotatingFile) rotate() error {
+	r.f.Close()
+	for i := r.backups; i > 0; i-- {
+		from := r.path
+		if i > 1 {
+			from = fmt.Sprintf("%s.%d", r.path, i-1)
+		}
+		os.Rename(from, fmt.Sprintf("%s.%d", r.path, i))
+	}
+	if r.backups == 0 {
+		os.Remove(r.path)
+	}
+	return r.open()
+}
+
+// Close closes the current file.
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.f.Close()
+}
+
+// LogConfig configures NewLogger.
+type LogConfig struct {
+	Level  slog.Level
+	JSON   bool      // JSON records instead of key=value text
+	Stderr io.Writer // nil to not log to stderr
+	File   string    // log file path; empty for none
+	// MaxSize and Backups control rotation of File; zero values use
+	// DefaultLogMaxSize and DefaultLogBackups.
+	MaxSize int64
+	Backups int
+}
+
+// NewLogger returns a logger writing to stderr and the log file, with
+// secrets scrubbed from both. The returned closer closes the log file.
+func NewLogger(cfg LogConfig) (*slog.Logger, io.Closer, error) {
+	var sinks []io.Writer
+	if cfg.Stderr != nil {
+		sinks = append(sinks, cfg.Stderr)
+	}
+	var closer io.Closer = io.NopCloser(nil)
+	if cfg.File != "" {
+		maxSize, backups := cfg.MaxSize, cfg.Backups
+		if maxSize == 0 {
+			maxSize = DefaultLogMaxSize
+		}
+		if backups == 0 {
+			backups = DefaultLogBackups
+		}
+		file, err := OpenRotatingFile(cfg.File, maxSize, backups)
+		if err != nil {
+			return nil, nil, err
+		}
+		sinks = append(sinks, file)
+		closer = file
+	}
+	// Handlers write a whole record, newline included, at a time, so
+	// ScrubWriter passes each straight through.
+	w := NewScrubWriter(io.MultiWriter(sinks...))
+	opts := &slog.HandlerOptions{Level: cfg.Level}
+	var handler slog.Handler
+	if cfg.JSON {
+		handler = slog.NewJSONHandler(w, opts)
+	} else {
+		handler = slog.NewTextHandler(w, opts)
+	}
+	return slog.New(handler), closer, nil
+}
+
+// ParseLogLevel parses debug, info, warn or error.
+func ParseLogLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("invalid log level %q (want debug, info, warn or error)", s)
+	}
+	return level, nil
+}
+
+// StdLogWriter adapts `logger` as the output of the standard log package,
+// so log.Printf calls, e.g. from pkg/wasmhost, are logged at info level.
+func StdLogWriter(logger *slog.Logger) io.Writer {
+	return stdLogWriter{logger}
+}
+
+type stdLogWriter struct {
+	logger *slog.Logger
+}
+
+func (w stdLogWriter) Write(p []byte) (int, error) {
+	w.logger.Info(strings.TrimSuffix(string(p), "\n"))
+	return len(p), nil
+}
@@ -1,9 +1,10 @@
 package mcper
 
 import (
-	"bufio"
+	"bytes"
 	"io"
 	"regexp"
+	"sync"
 )
 
 // scrubPatterns redacts cap tokens and bearer secrets from log lines.
@@ -25,7 +26,7 @@ var scrubPatterns = []*regexp.Regexp{
 }
 
 // ScrubBearerTokens replaces likely secrets with "<REDACTED>" sentinels.
-// Used by RunModuleWithLogging when MCPER_USE_CAP_PROXY is on.
+// Scrub applies it to every log sink of mcper serve.
 func ScrubBearerTokens(line string) string {
 	// Two passes: first JWT/bearer (whole-token), then header-shaped patterns.
 	// Header patterns need ${1} to keep the key visible; JWT/bearer redact entirely.
@@ -40,31 +41,52 @@ func ScrubBearerTokens(line string) string {
 	return line
 }
 
-// ScrubWriter wraps an io.Writer with the scrubber applied line-by-line.
+// maxScrubLine is the longest line ScrubWriter holds back waiting for its
+// newline; longer lines are scrubbed and written in pieces.
+const maxScrubLine = 1 << 20
+
+// ScrubWriter wraps an io.Writer with Scrub applied line-by-line.
 type ScrubWriter struct {
+	mu    sync.Mutex
 	inner io.Writer
-	buf   *bufio.Scanner
-	pipe  *io.PipeWriter
+	buf   []byte // an unfinished line
 }
 
 // NewScrubWriter returns a writer that scrubs each line written to it
-// before forwarding to `inner`. Buffer up to 1 MiB per line.
+// before forwarding to `inner`. Close writes out an unfinished last line.
 func NewScrubWriter(inner io.Writer) io.WriteCloser {
-	pr, pw := io.Pipe()
-	scanner := bufio.NewScanner(pr)
-	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
-	sw := &ScrubWriter{inner: inner, buf: scanner, pipe: pw}
-	go func() {
-		for sw.buf.Scan() {
-			_, _ = inner.Write([]byte(ScrubBearerTokens(sw.buf.Text()) + "\n"))
-		}
-	}()
-	return &writerCloser{Writer: pw, closer: pw}
+	return &ScrubWriter{inner: inner}
 }
 
-type writerCloser struct {
-	io.Writer
-	closer io.Closer
+func (w *ScrubWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 && len(w.buf) < maxScrubLine {
+			return len(p), nil
+		}
+		if i < 0 {
+			i = len(w.buf) - 1
+		}
+		line := w.buf[:i+1]
+		if _, err := io.WriteString(w.inner, Scrub(string(line))); err != nil {
+			w.buf = w.buf[:0]
+			return 0, err
+		}
+		w.buf = w.buf[i+1:]
+	}
 }
 
-func (wc *writerCloser) Close() error { return wc.closer.Close() }
+// Close writes out an unfinished last line.
+func (w *ScrubWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.buf) == 0 {
+		return nil
+	}
+	_, err := io.WriteString(w.inner, Scrub(string(w.buf)))
+	w.buf = nil
+	return err
+}
@@ -1,6 +1,7 @@
 package mcper
 
 import (
+	"bytes"
 	"strings"
 	"testing"
 )
@@ -52,3 +53,23 @@ func TestScrubBearerTokens(t *testing.T) {
 		}
 	}
 }
+
+func TestScrubWriter(t *testing.T) {
+	var out bytes.Buffer
+	w := NewScrubWriter(&out)
+	// A secret split across writes is still redacted.
+	for _, p := range []string{"ok\nAuthorization: Bea", "rer abc.def\n", "MCPER_AUTH_TOKEN=mk_live"} {
+		if _, err := w.Write([]byte(p)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := out.String(), "ok\nAuthorization: <REDACTED>\n"; got != want {
+		t.Errorf("before Close: %q, want %q", got, want)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := out.String(), "ok\nAuthorization: <REDACTED>\nMCPER_AUTH_TOKEN=<REDACTED>"; got != want {
+		t.Errorf("after Close: %q, want %q", got, want)
+	}
+}
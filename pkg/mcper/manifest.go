@@ -138,6 +138,26 @@ func (pi *PluginInfoV2) FindTool(toolName string) *ToolDecl {
 	return nil
 }
 
+// EgressHosts returns every host the manifest declares, at plugin and tool
+// level, deduplicated in declaration order.
+func (pi *PluginInfoV2) EgressHosts() []string {
+	seen := make(map[string]bool)
+	var hosts []string
+	add := func(decls []EgressDecl) {
+		for _, e := range decls {
+			if !seen[e.Host] {
+				seen[e.Host] = true
+				hosts = append(hosts, e.Host)
+			}
+		}
+	}
+	add(pi.Egress)
+	for _, t := range pi.Tools {
+		add(t.Egress)
+	}
+	return hosts
+}
+
 // FetchedManifest bundles a parsed PluginInfoV2 with the raw bytes that were
 // hashed. The cap-proxy path needs all three (parsed for policy lookup, raw
 // for cross-repo agreement, hash for the cap-mint request).
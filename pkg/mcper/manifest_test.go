@@ -88,6 +88,28 @@ func TestParseManifestV2_RejectsBadEgress(t *testing.T) {
 	}
 }
 
+func TestPluginInfoV2_EgressHosts(t *testing.T) {
+	m, err := ParseManifestV2([]byte(`{"name":"x",
+		"egress":[{"host":"a.com"}],
+		"tools":[
+			{"name":"t1","egress":[{"host":"a.com","path_prefix":"/v1"},{"host":"b.com"}]},
+			{"name":"t2","egress":[{"host":"c.com"}]}
+		]}`))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	got := m.EgressHosts()
+	want := []string{"a.com", "b.com", "c.com"}
+	if len(got) != len(want) {
+		t.Fatalf("EgressHosts() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("EgressHosts()[%d] = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
 // repoRoot walks up from cwd until it finds go.mod (works whether tests run
 // from pkg/mcper/ or repo root).
 func repoRoot() (string, error) {
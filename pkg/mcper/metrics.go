@@ -0,0 +1,273 @@
+package mcper
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Metric types, as written in # TYPE lines.
+const (
+	MetricCounter   = "counter"
+	MetricGauge     = "gauge"
+	MetricHistogram = "histogram"
+)
+
+// DefaultBuckets are histogram bucket upper bounds, in seconds, suited to
+// tool calls: from a fast local plugin up to a long approval wait.
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300}
+
+// Metrics is a registry of metrics written in the Prometheus text
+// exposition format. It covers what mcper serve needs and no more: labelled
+// counters and histograms, and values collected when scraped.
+type Metrics struct {
+	mu       sync.Mutex
+	families map[string]metricFamily
+}
+
+// metricFamily is one named metric and all its label combinations.
+type metricFamily interface {
+	write(w io.Writer, name string) error
+}
+
+// NewMetrics returns an empty registry.
+func NewMetrics() *Metrics {
+	return &Metrics{families: make(map[string]metricFamily)}
+}
+
+func (m *Metrics) register(name string, family metricFamily) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.families[name]; ok {
+		panic(fmt.Sprintf("metric %s registered twice", name))
+	}
+	m.families[name] = family
+}
+
+// Counter registers a counter with the given label names.
+func (m *Metrics) Counter(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{help: help, labels: labels, values: make(map[string]float64)}
+	m.register(name, c)
+	return c
+}
+
+// Histogram registers a histogram with the given bucket upper bounds, in
+// ascending order, and label names.
+func (m *Metrics) Histogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{help: help, buckets: buckets, labels: labels, values: make(map[string]*histogramValue)}
+	m.register(name, h)
+	return h
+}
+
+// Collect registers a metric whose values are read when scraped: collect
+// calls emit once per label combination. kind is MetricCounter or
+// MetricGauge.
+func (m *Metrics) Collect(name, help, kind string, labels []string, collect func(emit func(value float64, labelValues ...string))) {
+	m.register(name, &collectedFamily{help: help, kind: kind, labels: labels, collect: collect})
+}
+
+// WriteText writes every metric in the text exposition format, sorted by
+// name.
+func (m *Metrics) WriteText(w io.Writer) error {
+	m.mu.Lock()
+	names := make([]string, 0, len(m.families))
+	for name := range m.families {
+		names = append(names, name)
+	}
+	families := make([]metricFamily, len(names))
+	sort.Strings(names)
+	for i, name := range names {
+		families[i] = m.families[name]
+	}
+	m.mu.Unlock()
+
+	for i, family := range families {
+		if err := family.write(w, names[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CounterVec is a counter split by labels. A nil CounterVec discards
+// what is added to it.
+type CounterVec struct {
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]float64 // by formatted labels
+}
+
+// Inc adds one to the counter for `labelValues`, given in the order the
+// label names were registered.
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds v to the counter for `labelValues`.
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	if c == nil {
+		return
+	}
+	key := formatLabels(c.labels, labelValues)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[key] += v
+}
+
+func (c *CounterVec) write(w io.Writer, name string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := writeHeader(w, name, c.help, MetricCounter); err != nil {
+		return err
+	}
+	for _, key := range sortedKeys(c.values) {
+		if _, err := fmt.Fprintf(w, "%s%s %s\n", name, key, formatValue(c.values[key])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// HistogramVec is a histogram split by labels. A nil HistogramVec
+// discards what it observes.
+type HistogramVec struct {
+	help    string
+	buckets []float64
+	labels  []string
+
+	mu     sync.Mutex
+	values map[string]*histogramValue // by formatted labels
+}
+
+type histogramValue struct {
+	labelValues []string
+	counts      []uint64 // per bucket, not cumulative
+	count       uint64
+	sum         float64
+}
+
+// Observe records v for `labelValues`.
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	if h == nil {
+		return
+	}
+	key := formatLabels(h.labels, labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	value, ok := h.values[key]
+	if !ok {
+		value = &histogramValue{labelValues: slices.Clone(labelValues), counts: make([]uint64, len(h.buckets))}
+		h.values[key] = value
+	}
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		value.counts[i]++
+	}
+	value.count++
+	value.sum += v
+}
+
+func (h *HistogramVec) write(w io.Writer, name string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := writeHeader(w, name, h.help, MetricHistogram); err != nil {
+		return err
+	}
+	labels := append(slices.Clone(h.labels), "le")
+	for _, key := range sortedKeys(h.values) {
+		value := h.values[key]
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += value.counts[i]
+			bucket := formatLabels(labels, append(slices.Clone(value.labelValues), formatValue(bound)))
+			if _, err := fmt.Fprintf(w, "%s_bucket%s %d\n", name, bucket, cumulative); err != nil {
+				return err
+			}
+		}
+		inf := formatLabels(labels, append(slices.Clone(value.labelValues), "+Inf"))
+		if _, err := fmt.Fprintf(w, "%s_bucket%s %d\n%s_sum%s %s\n%s_count%s %d\n",
+			name, inf, value.count, name, key, formatValue(value.sum), name, key, value.count); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// collectedFamily reads its values when scraped.
+type collectedFamily struct {
+	help    string
+	kind    string
+	labels  []string
+	collect func(emit func(value float64, labelValues ...string))
+}
+
+func (c *collectedFamily) write(w io.Writer, name string) error {
+	if err := writeHeader(w, name, c.help, c.kind); err != nil {
+		return err
+	}
+	values := make(map[string]float64)
+	c.collect(func(value float64, labelValues ...string) {
+		values[formatLabels(c.labels, labelValues)] += value
+	})
+	for _, key := range sortedKeys(values) {
+		if _, err := fmt.Fprintf(w, "%s%s %s\n", name, key, formatValue(values[key])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeHeader(w io.Writer, name, help, kind string) error {
+	help = strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+	_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	return err
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatLabels renders label pairs as {a="1",b="2"}, or "" if there are
+// none. Missing values are empty.
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		sb.WriteString(name)
+		sb.WriteString(`="`)
+		sb.WriteString(labelEscaper.Replace(value))
+		sb.WriteByte('"')
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
+
+func formatValue(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,52 @@
+package mcper
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMetrics_WriteText(t *testing.T) {
+	m := NewMetrics()
+	calls := m.Counter("mcper_tool_calls_total", "Tool calls.", "plugin", "tool")
+	latency := m.Histogram("mcper_tool_call_duration_seconds", "Tool call latency.", []float64{0.1, 1}, "plugin")
+	m.Collect("mcper_wasm_memory_bytes", "Guest memory.", MetricGauge, []string{"module"}, func(emit func(float64, ...string)) {
+		emit(65536, "plugin-0")
+	})
+
+	calls.Inc("github", "wasm_github_create_issue")
+	calls.Inc("github", "wasm_github_create_issue")
+	calls.Inc("azdo", `say "hi"`)
+	latency.Observe(0.05, "github")
+	latency.Observe(0.5, "github")
+	latency.Observe(5, "github")
+
+	var sb strings.Builder
+	if err := m.WriteText(&sb); err != nil {
+		t.Fatal(err)
+	}
+	want := `# HELP mcper_tool_call_duration_seconds Tool call latency.
+# TYPE mcper_tool_call_duration_seconds histogram
+mcper_tool_call_duration_seconds_bucket{plugin="github",le="0.1"} 1
+mcper_tool_call_duration_seconds_bucket{plugin="github",le="1"} 2
+mcper_tool_call_duration_seconds_bucket{plugin="github",le="+Inf"} 3
+mcper_tool_call_duration_seconds_sum{plugin="github"} 5.55
+mcper_tool_call_duration_seconds_count{plugin="github"} 3
+# HELP mcper_tool_calls_total Tool calls.
+# TYPE mcper_tool_calls_total counter
+mcper_tool_calls_total{plugin="azdo",tool="say \"hi\""} 1
+mcper_tool_calls_total{plugin="github",tool="wasm_github_create_issue"} 2
+# HELP mcper_wasm_memory_bytes Guest memory.
+# TYPE mcper_wasm_memory_bytes gauge
+mcper_wasm_memory_bytes{module="plugin-0"} 65536
+`
+	if got := sb.String(); got != want {
+		t.Errorf("WriteText:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestMetrics_NilDiscards(t *testing.T) {
+	var calls *CounterVec
+	var latency *HistogramVec
+	calls.Inc("github")
+	latency.Observe(1, "github")
+}
@@ -0,0 +1,125 @@
+package mcper
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Mount is a host directory exposed to a WASM plugin, parsed from a
+// Permissions.Filesystem entry.
+type Mount struct {
+	HostPath  string
+	GuestPath string
+	ReadOnly  bool
+}
+
+// String renders the mount in the same host:guest:mode form it was parsed from.
+func (m Mount) String() string {
+	mode := "rw"
+	if m.ReadOnly {
+		mode = "ro"
+	}
+	return fmt.Sprintf("%s:%s:%s", m.HostPath, m.GuestPath, mode)
+}
+
+// ParseMount parses a filesystem permission entry. Supported forms:
+//
+//	/host/dir              read-write, visible to the guest at the same path
+//	/host/dir:ro           read-only
+//	/host/dir:/guest       read-write, remapped to /guest
+//	/host/dir:/guest:ro    read-only, remapped to /guest
+//
+// A leading "~" expands to the user's home directory and relative host paths
+// are resolved against baseDir (the project directory).
+func ParseMount(spec, baseDir string) (Mount, error) {
+	parts := strings.Split(spec, ":")
+	m := Mount{}
+	switch last := parts[len(parts)-1]; last {
+	case "ro", "rw":
+		m.ReadOnly = last == "ro"
+		parts = parts[:len(parts)-1]
+	}
+
+	switch len(parts) {
+	case 1:
+	case 2:
+		m.GuestPath = parts[1]
+	default:
+		return Mount{}, fmt.Errorf("invalid filesystem permission %q (expected host[:guest][:ro|rw])", spec)
+	}
+	if parts[0] == "" {
+		return Mount{}, fmt.Errorf("invalid filesystem permission %q: empty host path", spec)
+	}
+
+	host, err := expandPath(parts[0], baseDir)
+	if err != nil {
+		return Mount{}, err
+	}
+	m.HostPath = host
+
+	if m.GuestPath == "" {
+		m.GuestPath = host
+	} else if !strings.HasPrefix(m.GuestPath, "/") {
+		return Mount{}, fmt.Errorf("invalid filesystem permission %q: guest path must be absolute", spec)
+	} else {
+		m.GuestPath = filepath.Clean(m.GuestPath)
+	}
+	return m, nil
+}
+
+// Mounts parses every Filesystem entry. A nil Permissions yields no mounts.
+// The same host directory may only be mounted once.
+func (p *Permissions) Mounts(baseDir string) ([]Mount, error) {
+	if p == nil {
+		return nil, nil
+	}
+	seen := make(map[string]bool)
+	var mounts []Mount
+	for _, spec := range p.Filesystem {
+		m, err := ParseMount(spec, baseDir)
+		if err != nil {
+			return nil, err
+		}
+		if seen[m.HostPath] {
+			return nil, fmt.Errorf("filesystem permission %q mounts %s more than once", spec, m.HostPath)
+		}
+		seen[m.HostPath] = true
+		mounts = append(mounts, m)
+	}
+	return mounts, nil
+}
+
+// IsBroad reports whether the mount exposes far more than a project needs:
+// the filesystem root, a top-level system directory such as /etc, or the
+// home directory (or one of its ancestors).
+func (m Mount) IsBroad(homeDir string) bool {
+	host := filepath.Clean(m.HostPath)
+	if host == string(filepath.Separator) {
+		return true
+	}
+	if filepath.Dir(host) == string(filepath.Separator) {
+		return true
+	}
+	if homeDir != "" {
+		if rel, err := filepath.Rel(host, filepath.Clean(homeDir)); err == nil && !strings.HasPrefix(rel, "..") {
+			return true
+		}
+	}
+	return false
+}
+
+func expandPath(path, baseDir string) (string, error) {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("failed to get home directory: %w", err)
+		}
+		path = filepath.Join(home, strings.TrimPrefix(path, "~"))
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(baseDir, path)
+	}
+	return filepath.Clean(path), nil
+}
@@ -0,0 +1,80 @@
+package mcper
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseMount(t *testing.T) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		t.Skipf("no home directory: %v", err)
+	}
+	tests := []struct {
+		spec    string
+		want    Mount
+		wantErr bool
+	}{
+		{spec: "/data", want: Mount{HostPath: "/data", GuestPath: "/data"}},
+		{spec: "/data:ro", want: Mount{HostPath: "/data", GuestPath: "/data", ReadOnly: true}},
+		{spec: "/data:rw", want: Mount{HostPath: "/data", GuestPath: "/data"}},
+		{spec: "/data:/work", want: Mount{HostPath: "/data", GuestPath: "/work"}},
+		{spec: "/data:/work:ro", want: Mount{HostPath: "/data", GuestPath: "/work", ReadOnly: true}},
+		{spec: "./docs:/docs:ro", want: Mount{HostPath: "/project/docs", GuestPath: "/docs", ReadOnly: true}},
+		{spec: "~/notes", want: Mount{HostPath: filepath.Join(home, "notes"), GuestPath: filepath.Join(home, "notes")}},
+		{spec: "/data:work", wantErr: true},
+		{spec: "/a:/b:/c", wantErr: true},
+		{spec: ":ro", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := ParseMount(tt.spec, "/project")
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("ParseMount(%q) = %+v, want error", tt.spec, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseMount(%q): %v", tt.spec, err)
+			}
+			if got != tt.want {
+				t.Errorf("ParseMount(%q) = %+v, want %+v", tt.spec, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPermissions_MountsRejectsDuplicates(t *testing.T) {
+	p := &Permissions{Filesystem: []string{"/data:ro", "/data:/other"}}
+	if _, err := p.Mounts("/project"); err == nil {
+		t.Errorf("expected error for duplicate host path")
+	}
+
+	var nilPerms *Permissions
+	mounts, err := nilPerms.Mounts("/project")
+	if err != nil || len(mounts) != 0 {
+		t.Errorf("nil permissions: mounts=%v err=%v, want none", mounts, err)
+	}
+}
+
+func TestMount_IsBroad(t *testing.T) {
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{"/", true},
+		{"/etc", true},
+		{"/home", true},
+		{"/home/alex", true},
+		{"/home/alex/project", false},
+		{"/srv/data/cache", false},
+	}
+	for _, tt := range tests {
+		m := Mount{HostPath: tt.host, GuestPath: tt.host}
+		if got := m.IsBroad("/home/alex"); got != tt.want {
+			t.Errorf("Mount{%s}.IsBroad() = %v, want %v", tt.host, got, tt.want)
+		}
+	}
+}
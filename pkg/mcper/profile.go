@@ -0,0 +1,55 @@
+package mcper
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ProfileEnv selects the mcper-cloud profile when --profile isn't given.
+const ProfileEnv = "MCPER_PROFILE"
+
+// DefaultProfile is used when no profile is selected. Its credentials are
+// kept where they were before profiles existed.
+const DefaultProfile = "default"
+
+var profileNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// ValidateProfile checks a profile name: letters, digits, _ and -, as it
+// becomes part of a filename.
+func ValidateProfile(name string) error {
+	if !profileNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid profile name %q (use letters, digits, _ and -)", name)
+	}
+	return nil
+}
+
+// SelectProfile returns the profile to use: `flag` (from --profile) if set,
+// else $MCPER_PROFILE, else `pinned` (the project's Config.Profile), else
+// DefaultProfile.
+func SelectProfile(flag, pinned string) (string, error) {
+	profile := DefaultProfile
+	for _, p := range []string{flag, os.Getenv(ProfileEnv), pinned} {
+		if p != "" {
+			profile = p
+			break
+		}
+	}
+	if err := ValidateProfile(profile); err != nil {
+		return "", err
+	}
+	return profile, nil
+}
+
+// profileFilename returns the name of a profile's copy of `file`: `file`
+// itself for DefaultProfile, so credentials.json becomes
+// credentials-staging.json for profile staging.
+func profileFilename(file, profile string) string {
+	if profile == DefaultProfile {
+		return file
+	}
+	ext := filepath.Ext(file)
+	return strings.TrimSuffix(file, ext) + "-" + profile + ext
+}
@@ -0,0 +1,91 @@
+package mcper
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestSelectProfile(t *testing.T) {
+	tests := []struct {
+		flag, env, pinned string
+		want              string
+	}{
+		{"", "", "", DefaultProfile},
+		{"", "", "staging", "staging"},
+		{"", "personal", "staging", "personal"},
+		{"prod", "personal", "staging", "prod"},
+	}
+	for _, tt := range tests {
+		t.Setenv(ProfileEnv, tt.env)
+		got, err := SelectProfile(tt.flag, tt.pinned)
+		if err != nil {
+			t.Errorf("SelectProfile(%q, %q) with %s=%q: %v", tt.flag, tt.pinned, ProfileEnv, tt.env, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("SelectProfile(%q, %q) with %s=%q = %q, want %q", tt.flag, tt.pinned, ProfileEnv, tt.env, got, tt.want)
+		}
+	}
+
+	t.Setenv(ProfileEnv, "")
+	for _, bad := range []string{"../prod", "a b", "prod/x"} {
+		if _, err := SelectProfile(bad, ""); err == nil {
+			t.Errorf("SelectProfile(%q) accepted an invalid name", bad)
+		}
+	}
+}
+
+func TestProfileCredentials(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv(CredentialStoreEnv, CredentialStorePlaintext)
+	t.Setenv(ProfileEnv, "")
+
+	prod := &Credentials{APIKey: "prod-key", CloudURL: "https://prod.example"}
+	staging := &Credentials{APIKey: "staging-key", CloudURL: "https://staging.example", Profile: "staging"}
+	if err := SaveCredentials(prod); err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveCredentials(staging); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadCredentialsFromPath(filepath.Join(home, ".mcper", "credentials-staging.json")); err != nil {
+		t.Errorf("staging credentials not in credentials-staging.json: %v", err)
+	}
+
+	got, err := LoadCredentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.APIKey != "prod-key" || got.Profile != DefaultProfile {
+		t.Errorf("default profile = %+v", got)
+	}
+
+	t.Setenv(ProfileEnv, "staging")
+	got, err = LoadCredentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.APIKey != "staging-key" || got.CloudURL != "https://staging.example" || got.Profile != "staging" {
+		t.Errorf("staging profile = %+v", got)
+	}
+
+	t.Setenv(ProfileEnv, "")
+	if !IsLoggedIn("staging") {
+		t.Errorf("IsLoggedIn(staging) = false with %s unset", ProfileEnv)
+	}
+
+	if err := DeleteCredentials("staging"); err != nil {
+		t.Fatal(err)
+	}
+	if IsLoggedIn("staging") {
+		t.Errorf("IsLoggedIn(staging) = true after delete")
+	}
+	if _, err := LoadProfileCredentials("staging"); !errors.Is(err, ErrNotLoggedIn) {
+		t.Errorf("staging after delete = %v, want ErrNotLoggedIn", err)
+	}
+	if _, err := LoadProfileCredentials(DefaultProfile); err != nil {
+		t.Errorf("deleting staging removed the default profile: %v", err)
+	}
+}
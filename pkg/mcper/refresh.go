@@ -0,0 +1,225 @@
+package mcper
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// KeyRefreshLead is how long before ExpiresAt the API key is renewed. It
+// leaves time to retry before IsValid's five minute margin rejects the key.
+const KeyRefreshLead = 15 * time.Minute
+
+// keyRefreshPath is mcper-cloud's key rotation endpoint: it takes the
+// current key as bearer token and returns a new one.
+const keyRefreshPath = "/api/cli/refresh"
+
+// Retrying a failed refresh: the first wait, doubling up to the longest.
+// The first wait is also the least time between two refreshes, for keys
+// issued with less than KeyRefreshLead to live.
+const (
+	minKeyRefreshRetry = 30 * time.Second
+	maxKeyRefreshRetry = 5 * time.Minute
+)
+
+var (
+	// ErrKeyRefreshUnsupported is returned when the cloud has no key
+	// rotation endpoint.
+	ErrKeyRefreshUnsupported = errors.New("mcper-cloud does not support API key refresh")
+	// ErrKeyRefreshDenied is returned when the cloud no longer accepts the
+	// key being renewed; the user has to log in again.
+	ErrKeyRefreshDenied = errors.New("API key was revoked or expired, run mcper login")
+)
+
+type keyRefreshResponse struct {
+	APIKey    string `json:"api_key"`
+	ExpiresAt string `json:"expires_at"`
+}
+
+// RefreshAPIKey exchanges the API key in `creds` for a new one, returning a
+// copy of `creds` with the new key and expiry. It doesn't save them.
+func RefreshAPIKey(ctx context.Context, client *http.Client, creds *Credentials) (*Credentials, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, creds.CloudURL+keyRefreshPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+creds.APIKey)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to refresh API key: %w", err)
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to refresh API key: %w", err)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound, http.StatusMethodNotAllowed:
+		return nil, ErrKeyRefreshUnsupported
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return nil, ErrKeyRefreshDenied
+	default:
+		return nil, fmt.Errorf("failed to refresh API key (status %d)", resp.StatusCode)
+	}
+
+	var body keyRefreshResponse
+	if err := json.Unmarshal(raw, &body); err != nil {
+		return nil, fmt.Errorf("failed to parse API key refresh response: %w", err)
+	}
+	if body.APIKey == "" {
+		return nil, fmt.Errorf("API key refresh response has no key")
+	}
+	fresh := *creds
+	fresh.APIKey = body.APIKey
+	fresh.ExpiresAt = time.Time{}
+	if body.ExpiresAt != "" {
+		if fresh.ExpiresAt, err = time.Parse(time.RFC3339, body.ExpiresAt); err != nil {
+			return nil, fmt.Errorf("failed to parse API key expiry: %w", err)
+		}
+	}
+	return &fresh, nil
+}
+
+// KeyRefresher holds the credentials of a long running process and renews
+// their API key shortly before it expires, saving the new key to the
+// profile's credential store. Anything that reads the key through
+// Credentials or APIKey on each request picks up the new one.
+type KeyRefresher struct {
+	mu    sync.RWMutex
+	creds *Credentials
+
+	http      *http.Client
+	onRefresh func(*Credentials)
+	onError   func(error)
+
+	// load and save read and write the stored credentials, and now and
+	// sleep stand in for the clock; tests replace them.
+	load  func() (*Credentials, error)
+	save  func(*Credentials) error
+	now   func() time.Time
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+// NewKeyRefresher returns a refresher for `creds`. `onRefresh` is called
+// with the credentials after each renewal and `onError` when one fails;
+// either may be nil.
+func NewKeyRefresher(creds *Credentials, onRefresh func(*Credentials), onError func(error)) *KeyRefresher {
+	profile := creds.Profile
+	if profile == "" {
+		profile = DefaultProfile
+	}
+	return &KeyRefresher{
+		creds:     creds,
+		http:      &http.Client{Timeout: 30 * time.Second},
+		onRefresh: onRefresh,
+		onError:   onError,
+		load:      func() (*Credentials, error) { return LoadProfileCredentials(profile) },
+		save:      SaveCredentials,
+		now:       time.Now,
+		sleep:     sleepContext,
+	}
+}
+
+// Credentials returns the current credentials, nil for a nil refresher.
+// Callers must not modify them.
+func (r *KeyRefresher) Credentials() *Credentials {
+	if r == nil {
+		return nil
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.creds
+}
+
+// APIKey returns the current API key, "" for a nil refresher.
+func (r *KeyRefresher) APIKey() string {
+	creds := r.Credentials()
+	if creds == nil {
+		return ""
+	}
+	return creds.APIKey
+}
+
+func (r *KeyRefresher) set(creds *Credentials) {
+	r.mu.Lock()
+	r.creds = creds
+	r.mu.Unlock()
+	if r.onRefresh != nil {
+		r.onRefresh(creds)
+	}
+}
+
+// Refresh renews the API key now. If the stored credentials already hold
+// a newer key, e.g. from another mcper process or a fresh mcper login, it
+// takes that one instead of asking the cloud.
+func (r *KeyRefresher) Refresh(ctx context.Context) error {
+	current := r.Credentials()
+	if stored, err := r.load(); err == nil && stored.APIKey != current.APIKey &&
+		stored.CloudURL == current.CloudURL && stored.ExpiresAt.After(current.ExpiresAt) {
+		r.set(stored)
+		return nil
+	}
+
+	fresh, err := RefreshAPIKey(ctx, r.http, current)
+	if err != nil {
+		return err
+	}
+	// The old key may already be gone, so use the new one even if it
+	// can't be saved.
+	saveErr := r.save(fresh)
+	r.set(fresh)
+	if saveErr != nil {
+		return fmt.Errorf("renewed API key but failed to save it: %w", saveErr)
+	}
+	return nil
+}
+
+// Run renews the API key KeyRefreshLead before each expiry until ctx is
+// done, at once if the key is already that close to expiring. Failed
+// renewals are retried with exponential backoff. It returns at once for
+// keys that don't expire, and when the cloud doesn't support refreshing
+// them or no longer accepts the key.
+func (r *KeyRefresher) Run(ctx context.Context) {
+	retry := minKeyRefreshRetry
+	var minWait time.Duration
+	for {
+		creds := r.Credentials()
+		if creds.ExpiresAt.IsZero() {
+			return
+		}
+		wait := max(creds.ExpiresAt.Sub(r.now())-KeyRefreshLead, minWait)
+		if wait > 0 {
+			if err := r.sleep(ctx, wait); err != nil {
+				return
+			}
+		}
+
+		err := r.Refresh(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			retry = minKeyRefreshRetry
+			minWait = minKeyRefreshRetry
+			continue
+		}
+		if r.onError != nil {
+			r.onError(err)
+		}
+		if errors.Is(err, ErrKeyRefreshUnsupported) || errors.Is(err, ErrKeyRefreshDenied) {
+			return
+		}
+		if err := r.sleep(ctx, retry); err != nil {
+			return
+		}
+		retry = min(retry*2, maxKeyRefreshRetry)
+		minWait = 0
+	}
+}
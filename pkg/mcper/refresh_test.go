@@ -0,0 +1,242 @@
+package mcper
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeKeyCloud stands in for mcper-cloud's key rotation and cap mint
+// endpoints. Each rotation revokes the old key and issues one that lives
+// an hour from `now`.
+type fakeKeyCloud struct {
+	now func() time.Time
+
+	mu        sync.Mutex
+	key       string
+	rotations int
+	mintKeys  []string // bearer keys seen by /api/cap/mint
+}
+
+func (f *fakeKeyCloud) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	bearer := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	switch r.URL.Path {
+	case keyRefreshPath:
+		if bearer != f.key {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		f.rotations++
+		f.key = fmt.Sprintf("mcper_live_%d", f.rotations)
+		json.NewEncoder(w).Encode(map[string]string{
+			"api_key":    f.key,
+			"expires_at": f.now().Add(time.Hour).Format(time.RFC3339),
+		})
+	case "/api/cap/mint":
+		f.mintKeys = append(f.mintKeys, bearer)
+		json.NewEncoder(w).Encode(Cap{Cap: "cap-1"})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// fakeClock is advanced by the refresher's sleeps; it stops Run by
+// cancelling once `stopAfter` sleeps have happened.
+type fakeClock struct {
+	now       time.Time
+	waits     []time.Duration
+	stopAfter int
+	cancel    context.CancelFunc
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) Sleep(ctx context.Context, d time.Duration) error {
+	if len(c.waits) == c.stopAfter {
+		c.cancel()
+		return ctx.Err()
+	}
+	c.waits = append(c.waits, d)
+	c.now = c.now.Add(d)
+	return nil
+}
+
+func TestKeyRefresherRun(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	clock := &fakeClock{now: time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC), stopAfter: 2, cancel: cancel}
+	cloud := &fakeKeyCloud{now: clock.Now, key: "mcper_live_0"}
+	server := httptest.NewServer(cloud)
+	defer server.Close()
+
+	creds := &Credentials{APIKey: "mcper_live_0", CloudURL: server.URL, ExpiresAt: clock.now.Add(time.Hour)}
+	var saved, refreshed []string
+	r := NewKeyRefresher(creds, func(c *Credentials) { refreshed = append(refreshed, c.APIKey) }, func(err error) {
+		t.Errorf("refresh failed: %v", err)
+	})
+	r.load = func() (*Credentials, error) { return nil, ErrNotLoggedIn }
+	r.save = func(c *Credentials) error {
+		saved = append(saved, c.APIKey)
+		return nil
+	}
+	r.now = clock.Now
+	r.sleep = clock.Sleep
+
+	client := NewCloudClientFrom(r.Credentials)
+	if _, _, err := client.MintCap(ctx, &CapMintRequest{Plugin: "p"}); err != nil {
+		t.Fatal(err)
+	}
+
+	r.Run(ctx)
+
+	if want := []time.Duration{time.Hour - KeyRefreshLead, time.Hour - KeyRefreshLead}; !slices.Equal(clock.waits, want) {
+		t.Errorf("waits = %v, want %v", clock.waits, want)
+	}
+	want := []string{"mcper_live_1", "mcper_live_2"}
+	if !slices.Equal(saved, want) {
+		t.Errorf("saved keys = %v, want %v", saved, want)
+	}
+	if !slices.Equal(refreshed, want) {
+		t.Errorf("refreshed keys = %v, want %v", refreshed, want)
+	}
+	if got := r.APIKey(); got != "mcper_live_2" {
+		t.Errorf("APIKey = %q, want mcper_live_2", got)
+	}
+	if want := clock.now.Add(time.Hour); !r.Credentials().ExpiresAt.Equal(want) {
+		t.Errorf("ExpiresAt = %v, want %v", r.Credentials().ExpiresAt, want)
+	}
+	if creds.APIKey != "mcper_live_0" {
+		t.Error("refresh modified the original credentials")
+	}
+
+	// The same client now sends the renewed key.
+	if _, _, err := client.MintCap(context.Background(), &CapMintRequest{Plugin: "p"}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"mcper_live_0", "mcper_live_2"}; !slices.Equal(cloud.mintKeys, want) {
+		t.Errorf("cap mint keys = %v, want %v", cloud.mintKeys, want)
+	}
+}
+
+func TestKeyRefresherAdoptsStoredKey(t *testing.T) {
+	cloud := &fakeKeyCloud{now: time.Now, key: "mcper_live_0"}
+	server := httptest.NewServer(cloud)
+	defer server.Close()
+
+	expires := time.Now().Add(10 * time.Minute)
+	creds := &Credentials{APIKey: "mcper_live_0", CloudURL: server.URL, ExpiresAt: expires}
+	stored := &Credentials{APIKey: "mcper_live_other", CloudURL: server.URL, ExpiresAt: expires.Add(time.Hour)}
+	r := NewKeyRefresher(creds, nil, nil)
+	r.load = func() (*Credentials, error) { return stored, nil }
+	r.save = func(*Credentials) error {
+		t.Error("adopted credentials were saved again")
+		return nil
+	}
+
+	if err := r.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if r.Credentials() != stored {
+		t.Errorf("Credentials = %+v, want the stored ones", r.Credentials())
+	}
+	if cloud.rotations != 0 {
+		t.Errorf("rotated %d times, want 0", cloud.rotations)
+	}
+}
+
+func TestKeyRefresherErrors(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc(keyRefreshPath, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+	revoking := httptest.NewServer(mux)
+	defer revoking.Close()
+	old := httptest.NewServer(http.NotFoundHandler())
+	defer old.Close()
+
+	tests := []struct {
+		name     string
+		cloudURL string
+		want     error
+	}{
+		{"revoked", revoking.URL, ErrKeyRefreshDenied},
+		{"unsupported", old.URL, ErrKeyRefreshUnsupported},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			creds := &Credentials{APIKey: "mcper_live_0", CloudURL: tt.cloudURL, ExpiresAt: time.Now().Add(time.Minute)}
+			_, err := RefreshAPIKey(context.Background(), http.DefaultClient, creds)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("RefreshAPIKey = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	// Run gives up on a cloud that can't refresh keys, or won't refresh
+	// this one, keeping the key.
+	for _, tt := range tests {
+		t.Run("run "+tt.name, func(t *testing.T) {
+			creds := &Credentials{APIKey: "mcper_live_0", CloudURL: tt.cloudURL, ExpiresAt: time.Now().Add(time.Minute)}
+			var errs []error
+			r := NewKeyRefresher(creds, nil, func(err error) { errs = append(errs, err) })
+			r.load = func() (*Credentials, error) { return nil, ErrNotLoggedIn }
+			r.sleep = func(context.Context, time.Duration) error {
+				t.Error("Run waited to retry")
+				return context.Canceled
+			}
+			r.Run(context.Background())
+			if len(errs) != 1 || !errors.Is(errs[0], tt.want) {
+				t.Errorf("errors = %v, want one %v", errs, tt.want)
+			}
+			if r.Credentials() != creds {
+				t.Error("credentials changed after a failed refresh")
+			}
+		})
+	}
+}
+
+func TestKeyRefresherRefreshesNearExpiryAtOnce(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	clock := &fakeClock{now: time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC), cancel: cancel}
+	cloud := &fakeKeyCloud{now: clock.Now, key: "mcper_live_0"}
+	server := httptest.NewServer(cloud)
+	defer server.Close()
+
+	// Already past IsValid's margin, but not yet expired.
+	creds := &Credentials{APIKey: "mcper_live_0", CloudURL: server.URL, ExpiresAt: clock.now.Add(2 * time.Minute)}
+	r := NewKeyRefresher(creds, nil, func(err error) { t.Errorf("refresh failed: %v", err) })
+	r.load = func() (*Credentials, error) { return nil, ErrNotLoggedIn }
+	r.save = func(*Credentials) error { return nil }
+	r.now = clock.Now
+	r.sleep = clock.Sleep
+
+	r.Run(ctx)
+
+	if cloud.rotations != 1 {
+		t.Errorf("rotated %d times, want 1", cloud.rotations)
+	}
+	if len(clock.waits) != 0 {
+		t.Errorf("waited %v before refreshing", clock.waits)
+	}
+	if !r.Credentials().IsValid() {
+		t.Errorf("credentials not valid after refresh: %+v", r.Credentials())
+	}
+}
+
+func TestNilKeyRefresher(t *testing.T) {
+	var r *KeyRefresher
+	if r.Credentials() != nil || r.APIKey() != "" {
+		t.Errorf("nil refresher has credentials %+v", r.Credentials())
+	}
+}
@@ -0,0 +1,131 @@
+package mcper
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Secret reference schemes accepted as PluginConfig.Env values. A value
+// without a scheme names a host env var, as env: does.
+const (
+	SecretFile    = "file:"    // file:~/.secrets/azdo reads the file
+	SecretCmd     = "cmd:"     // cmd:pass show azdo runs the command
+	SecretKeyring = "keyring:" // keyring:mcper/azdo reads service mcper, account azdo
+	SecretEnv     = "env:"     // env:AZURE_DEVOPS_PAT reads the host env var
+)
+
+// secretCmdTimeout bounds a cmd: reference, e.g. one waiting on a
+// passphrase prompt nobody will answer.
+const secretCmdTimeout = 30 * time.Second
+
+// defaultKeyringService is the service of keyring references that only
+// name an account.
+const defaultKeyringService = "mcper"
+
+// SecretEnvName returns the host env var a PluginConfig.Env value reads,
+// if it reads one: a bare name or an env: reference.
+func SecretEnvName(ref string) (string, bool) {
+	if name, ok := strings.CutPrefix(ref, SecretEnv); ok {
+		return name, true
+	}
+	if strings.Contains(ref, ":") {
+		return "", false
+	}
+	return ref, true
+}
+
+// ParseKeyringRef splits the part of a keyring: reference after the scheme
+// into a service and account. "azdo" is account azdo of service mcper.
+func ParseKeyringRef(s string) (service, account string) {
+	if service, account, ok := strings.Cut(s, "/"); ok {
+		return service, account
+	}
+	return defaultKeyringService, s
+}
+
+// ResolveSecret returns the value `ref` refers to. keyring: references are
+// looked up in `keyring`, which may be nil if there are none. A host env
+// var that is unset resolves to ""; every other kind of reference must
+// resolve to a non-empty value. Errors never contain the secret.
+func ResolveSecret(ctx context.Context, ref string, keyring Keyring) (string, error) {
+	if name, ok := SecretEnvName(ref); ok {
+		return os.Getenv(name), nil
+	}
+	value, err := resolveSecretRef(ctx, ref, keyring)
+	if err != nil {
+		return "", err
+	}
+	if value == "" {
+		return "", fmt.Errorf("secret %s is empty", ref)
+	}
+	return value, nil
+}
+
+func resolveSecretRef(ctx context.Context, ref string, keyring Keyring) (string, error) {
+	switch {
+	case strings.HasPrefix(ref, SecretFile):
+		path, err := expandHome(strings.TrimPrefix(ref, SecretFile))
+		if err != nil {
+			return "", err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read secret file: %w", err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+
+	case strings.HasPrefix(ref, SecretCmd):
+		return runSecretCmd(ctx, strings.TrimPrefix(ref, SecretCmd))
+
+	case strings.HasPrefix(ref, SecretKeyring):
+		if keyring == nil {
+			return "", fmt.Errorf("secret %s: no keyring available", ref)
+		}
+		service, account := ParseKeyringRef(strings.TrimPrefix(ref, SecretKeyring))
+		value, err := keyring.Get(service, account)
+		if err != nil {
+			return "", fmt.Errorf("secret %s: %w", ref, err)
+		}
+		return value, nil
+	}
+	scheme, _, _ := strings.Cut(ref, ":")
+	return "", fmt.Errorf("unknown secret reference scheme %q (want file:, cmd:, keyring: or env:)", scheme)
+}
+
+// runSecretCmd runs `command` through the shell and returns its output.
+// Its stderr, where tools like pass report problems, is left out of the
+// error in case it echoes the secret.
+func runSecretCmd(ctx context.Context, command string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, secretCmdTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	if err := cmd.Run(); err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return "", fmt.Errorf("secret command %q timed out after %s", command, secretCmdTimeout)
+		}
+		return "", fmt.Errorf("secret command %q failed: %w", command, err)
+	}
+	return strings.TrimRight(stdout.String(), "\r\n"), nil
+}
+
+// expandHome expands a leading ~/ to the user's home directory.
+func expandHome(path string) (string, error) {
+	rest, ok := strings.CutPrefix(path, "~/")
+	if !ok {
+		return path, nil
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get home directory: %w", err)
+	}
+	return filepath.Join(homeDir, rest), nil
+}
@@ -0,0 +1,144 @@
+package mcper
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResolveSecret(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("HOME", dir)
+	if err := os.WriteFile(filepath.Join(dir, "azdo"), []byte("file-secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("MCPER_TEST_PAT", "env-secret")
+	keyring := &FileKeyring{Path: filepath.Join(dir, KeyringFile)}
+	if err := keyring.Set("mcper", "azdo", "keyring-secret"); err != nil {
+		t.Fatal(err)
+	}
+	if err := keyring.Set("team", "github", "team-secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		ref  string
+		want string
+	}{
+		{"MCPER_TEST_PAT", "env-secret"},
+		{"env:MCPER_TEST_PAT", "env-secret"},
+		{"MCPER_TEST_UNSET", ""},
+		{"file:~/azdo", "file-secret"},
+		{"file:" + filepath.Join(dir, "azdo"), "file-secret"},
+		{"cmd:echo cmd-secret", "cmd-secret"},
+		{"keyring:azdo", "keyring-secret"},
+		{"keyring:mcper/azdo", "keyring-secret"},
+		{"keyring:team/github", "team-secret"},
+	}
+	for _, tt := range tests {
+		got, err := ResolveSecret(context.Background(), tt.ref, keyring)
+		if err != nil {
+			t.Errorf("ResolveSecret(%q): %v", tt.ref, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ResolveSecret(%q) = %q, want %q", tt.ref, got, tt.want)
+		}
+	}
+
+	for _, ref := range []string{
+		"file:~/missing",
+		"cmd:exit 3",
+		"cmd:true",
+		"keyring:mcper/missing",
+		"vault:secret/azdo",
+	} {
+		if _, err := ResolveSecret(context.Background(), ref, keyring); err == nil {
+			t.Errorf("ResolveSecret(%q) succeeded, want error", ref)
+		}
+	}
+	if _, err := ResolveSecret(context.Background(), "keyring:azdo", nil); err == nil {
+		t.Error("keyring reference resolved without a keyring")
+	}
+}
+
+func TestResolveSecretErrorOmitsOutput(t *testing.T) {
+	_, err := ResolveSecret(context.Background(), "cmd:echo out-$((40+2)); echo err-$((40+2)) >&2; exit 1", nil)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if strings.Contains(err.Error(), "-42") {
+		t.Errorf("error includes command output: %v", err)
+	}
+}
+
+func TestSecretEnvName(t *testing.T) {
+	tests := []struct {
+		ref  string
+		name string
+		ok   bool
+	}{
+		{"GITHUB_TOKEN", "GITHUB_TOKEN", true},
+		{"env:GITHUB_TOKEN", "GITHUB_TOKEN", true},
+		{"file:~/.secrets/azdo", "", false},
+		{"keyring:mcper/azdo", "", false},
+	}
+	for _, tt := range tests {
+		name, ok := SecretEnvName(tt.ref)
+		if name != tt.name || ok != tt.ok {
+			t.Errorf("SecretEnvName(%q) = %q, %v, want %q, %v", tt.ref, name, ok, tt.name, tt.ok)
+		}
+	}
+}
+
+func TestFileKeyring(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sub", KeyringFile)
+	keyring := &FileKeyring{Path: path}
+
+	if _, err := keyring.Get("mcper", "azdo"); !errors.Is(err, ErrSecretNotFound) {
+		t.Fatalf("Get on empty keyring = %v, want ErrSecretNotFound", err)
+	}
+	if err := keyring.Set("mcper", "azdo", "first"); err != nil {
+		t.Fatal(err)
+	}
+	if err := keyring.Set("mcper", "azdo", "second"); err != nil {
+		t.Fatal(err)
+	}
+	got, err := keyring.Get("mcper", "azdo")
+	if err != nil || got != "second" {
+		t.Fatalf("Get = %q, %v, want second", got, err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("keyring mode = %v, want 0600", info.Mode().Perm())
+	}
+
+	if err := keyring.Delete("mcper", "azdo"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := keyring.Get("mcper", "azdo"); !errors.Is(err, ErrSecretNotFound) {
+		t.Errorf("Get after Delete = %v, want ErrSecretNotFound", err)
+	}
+	if err := keyring.Delete("mcper", "azdo"); !errors.Is(err, ErrSecretNotFound) {
+		t.Errorf("second Delete = %v, want ErrSecretNotFound", err)
+	}
+}
+
+func TestDefaultKeyringFileEnv(t *testing.T) {
+	path := filepath.Join(t.TempDir(), KeyringFile)
+	t.Setenv(KeyringFileEnv, path)
+	keyring, err := DefaultKeyring()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fk, ok := keyring.(*FileKeyring); !ok || fk.Path != path {
+		t.Errorf("DefaultKeyring() = %#v, want FileKeyring at %s", keyring, path)
+	}
+}
@@ -0,0 +1,155 @@
+package mcper
+
+import (
+	"fmt"
+	"path"
+)
+
+// Approval modes for a tool, declared by a plugin manifest's approval_mode
+// or by ToolRules.Approval.
+const (
+	ApprovalAllow = "allow" // forward calls as they come
+	ApprovalPre   = "pre"   // ask the user before forwarding each call
+	ApprovalDeny  = "deny"  // don't forward the tool at all
+)
+
+// ToolRules chooses which of a plugin's tools serve forwards and what they
+// are called. Forwarded tools are named "<prefix>_<tool>", where the prefix
+// defaults to "<namespace>_<plugin>".
+type ToolRules struct {
+	Alias   string                  `json:"alias,omitempty"`   // replaces the default prefix, e.g. "gmail"
+	Include []string                `json:"include,omitempty"` // glob patterns; if set, only matching tools are forwarded
+	Exclude []string                `json:"exclude,omitempty"` // glob patterns of tools not to forward
+	Rename  map[string]ToolOverride `json:"rename,omitempty"`  // keyed by the plugin's own tool name
+
+	// Approval maps tool names or glob patterns to an approval mode. It
+	// can make the mode the plugin's manifest declares stricter, not looser.
+	Approval map[string]string `json:"approval,omitempty"`
+}
+
+// ToolOverride renames one tool or replaces its description.
+type ToolOverride struct {
+	Name        string `json:"name,omitempty"` // replaces the tool's own name; the prefix still applies
+	Description string `json:"description,omitempty"`
+}
+
+// Validate checks that every pattern is a valid glob and every approval
+// mode is known.
+func (r *ToolRules) Validate() error {
+	if r == nil {
+		return nil
+	}
+	patterns := append(append([]string(nil), r.Include...), r.Exclude...)
+	for pattern, mode := range r.Approval {
+		switch mode {
+		case ApprovalAllow, ApprovalPre, ApprovalDeny:
+		default:
+			return fmt.Errorf("invalid approval mode %q for %q (expected allow, pre or deny)", mode, pattern)
+		}
+		patterns = append(patterns, pattern)
+	}
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid tool pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// ApprovalMode returns the approval mode of the plugin tool named `tool`,
+// given the mode its manifest declares, if any. Among the rules, an entry
+// for the exact tool name wins; otherwise the strictest matching pattern
+// does. The rules can only make the declared mode stricter, so a plugin's
+// "deny" or "pre" can't be turned into "allow" by config. With neither a
+// rule nor a declared mode the tool is allowed.
+func (r *ToolRules) ApprovalMode(tool, declared string) string {
+	mode := declared
+	if mode == "" {
+		mode = ApprovalAllow
+	}
+	if configured := r.configuredMode(tool); approvalRank(configured) > approvalRank(mode) {
+		mode = configured
+	}
+	return mode
+}
+
+// configuredMode returns the mode the rules give `tool`, or "" if none.
+func (r *ToolRules) configuredMode(tool string) string {
+	if r == nil {
+		return ""
+	}
+	if mode, ok := r.Approval[tool]; ok {
+		return mode
+	}
+	matched := ""
+	for pattern, mode := range r.Approval {
+		if ok, _ := path.Match(pattern, tool); ok && approvalRank(mode) > approvalRank(matched) {
+			matched = mode
+		}
+	}
+	return matched
+}
+
+func approvalRank(mode string) int {
+	switch mode {
+	case ApprovalAllow:
+		return 1
+	case ApprovalPre:
+		return 2
+	case ApprovalDeny:
+		return 3
+	}
+	return 0
+}
+
+// Forwards reports whether the plugin tool named `tool` is forwarded:
+// it matches an include pattern, if there are any, and no exclude pattern.
+func (r *ToolRules) Forwards(tool string) bool {
+	if r == nil {
+		return true
+	}
+	if len(r.Include) > 0 && !matchAny(r.Include, tool) {
+		return false
+	}
+	return !matchAny(r.Exclude, tool)
+}
+
+// Prefix returns the prefix forwarded names start with, given the default.
+func (r *ToolRules) Prefix(prefix string) string {
+	if r != nil && r.Alias != "" {
+		return r.Alias
+	}
+	return prefix
+}
+
+// ToolName returns the forwarded name of the plugin tool named `tool`,
+// given the default prefix.
+func (r *ToolRules) ToolName(prefix, tool string) string {
+	name := tool
+	if r != nil {
+		if override := r.Rename[tool].Name; override != "" {
+			name = override
+		}
+	}
+	return r.Prefix(prefix) + "_" + name
+}
+
+// Description returns the forwarded description of the plugin tool named
+// `tool`, whose own description is `description`.
+func (r *ToolRules) Description(tool, description string) string {
+	if r != nil {
+		if override := r.Rename[tool].Description; override != "" {
+			return override
+		}
+	}
+	return description
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
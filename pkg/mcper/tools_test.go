@@ -0,0 +1,103 @@
+package mcper
+
+import "testing"
+
+func TestToolRules_Forwards(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules *ToolRules
+		tool  string
+		want  bool
+	}{
+		{"no rules", nil, "send", true},
+		{"empty rules", &ToolRules{}, "send", true},
+		{"included", &ToolRules{Include: []string{"list_*", "get_*"}}, "get_message", true},
+		{"not included", &ToolRules{Include: []string{"list_*", "get_*"}}, "send", false},
+		{"excluded", &ToolRules{Exclude: []string{"*merge*"}}, "merge_pull_request", false},
+		{"not excluded", &ToolRules{Exclude: []string{"*merge*"}}, "list_pull_requests", true},
+		{"exclude beats include", &ToolRules{Include: []string{"*"}, Exclude: []string{"send"}}, "send", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.rules.Forwards(tt.tool); got != tt.want {
+				t.Errorf("Forwards(%q) = %v, want %v", tt.tool, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestToolRules_ToolName(t *testing.T) {
+	rules := &ToolRules{
+		Alias:  "mail",
+		Rename: map[string]ToolOverride{"send_message": {Name: "send", Description: "Send an email"}},
+	}
+	tests := []struct {
+		name  string
+		rules *ToolRules
+		tool  string
+		want  string
+	}{
+		{"no rules", nil, "send_message", "wasm_gmail_send_message"},
+		{"alias", rules, "list_messages", "mail_list_messages"},
+		{"alias and rename", rules, "send_message", "mail_send"},
+		{"rename only", &ToolRules{Rename: rules.Rename}, "send_message", "wasm_gmail_send"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.rules.ToolName("wasm_gmail", tt.tool); got != tt.want {
+				t.Errorf("ToolName(%q) = %q, want %q", tt.tool, got, tt.want)
+			}
+		})
+	}
+
+	if got := rules.Description("send_message", "Send"); got != "Send an email" {
+		t.Errorf("Description = %q, want the override", got)
+	}
+	if got := rules.Description("list_messages", "List"); got != "List" {
+		t.Errorf("Description = %q, want the plugin's own", got)
+	}
+}
+
+func TestToolRules_Validate(t *testing.T) {
+	if err := (&ToolRules{Include: []string{"get_*"}, Exclude: []string{"[a-z]*"}}).Validate(); err != nil {
+		t.Errorf("Validate: %v", err)
+	}
+	if err := (&ToolRules{Exclude: []string{"[oops"}}).Validate(); err == nil {
+		t.Error("Validate succeeded with a malformed pattern, want error")
+	}
+}
+
+func TestToolRules_ApprovalMode(t *testing.T) {
+	rules := &ToolRules{Approval: map[string]string{
+		"create_*":       ApprovalPre,
+		"*_pull_request": ApprovalDeny,
+		"create_issue":   ApprovalAllow,
+	}}
+	tests := []struct {
+		name     string
+		rules    *ToolRules
+		tool     string
+		declared string
+		want     string
+	}{
+		{"no rules or manifest", nil, "list_issues", "", ApprovalAllow},
+		{"manifest", nil, "create_issue", ApprovalPre, ApprovalPre},
+		{"exact name wins over patterns", rules, "create_issue", "", ApprovalAllow},
+		{"can't loosen manifest deny", rules, "create_issue", ApprovalDeny, ApprovalDeny},
+		{"can't loosen manifest pre", &ToolRules{Approval: map[string]string{"*": ApprovalAllow}}, "merge", ApprovalPre, ApprovalPre},
+		{"pattern tightens manifest", rules, "create_label", ApprovalAllow, ApprovalPre},
+		{"strictest pattern wins", rules, "create_pull_request", "", ApprovalDeny},
+		{"unmatched falls back to manifest", rules, "list_issues", ApprovalPre, ApprovalPre},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.rules.ApprovalMode(tt.tool, tt.declared); got != tt.want {
+				t.Errorf("ApprovalMode(%q, %q) = %q, want %q", tt.tool, tt.declared, got, tt.want)
+			}
+		})
+	}
+
+	if err := (&ToolRules{Approval: map[string]string{"send": "ask"}}).Validate(); err == nil {
+		t.Error("Validate accepted approval mode ask, want error")
+	}
+}
@@ -0,0 +1,144 @@
+package mcper
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	const valid = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, err := ParseTraceparent(valid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := sc.Traceparent(); got != valid {
+		t.Errorf("round trip = %q, want %q", got, valid)
+	}
+
+	for _, bad := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473z-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+	} {
+		if _, err := ParseTraceparent(bad); err == nil {
+			t.Errorf("ParseTraceparent(%q) succeeded", bad)
+		}
+	}
+}
+
+// readOTLPSpans decodes spans from OTLP/JSON export requests.
+func readOTLPSpans(t *testing.T, data []byte) []otlpSpan {
+	t.Helper()
+	var spans []otlpSpan
+	dec := json.NewDecoder(bytes.NewReader(data))
+	for dec.More() {
+		var req otlpTraces
+		if err := dec.Decode(&req); err != nil {
+			t.Fatal(err)
+		}
+		for _, rs := range req.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				spans = append(spans, ss.Spans...)
+			}
+		}
+	}
+	return spans
+}
+
+func TestTracer_FileExporter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traces.jsonl")
+	exporter, err := NewTraceExporter("", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracer := NewTracer(exporter, nil)
+
+	parent, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx, root := tracer.Start(ContextWithSpanContext(context.Background(), parent), "tools/call", SpanKindServer, "mcp.tool", "wasm_github_create_issue")
+	_, child := tracer.Start(ctx, "cap.mint", SpanKindClient)
+	child.SetError("cap mint denied")
+	child.End()
+	root.End()
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spans := readOTLPSpans(t, data)
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(spans))
+	}
+	mint, call := spans[0], spans[1]
+	if call.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || call.ParentSpanID != "00f067aa0ba902b7" {
+		t.Errorf("root span not a child of the client's: %+v", call)
+	}
+	if mint.TraceID != call.TraceID || mint.ParentSpanID != call.SpanID {
+		t.Errorf("cap.mint not a child of tools/call: %+v", mint)
+	}
+	if mint.Status.Code != 2 || mint.Status.Message != "cap mint denied" {
+		t.Errorf("cap.mint status = %+v", mint.Status)
+	}
+	if len(call.Attributes) != 1 || call.Attributes[0].Value["stringValue"] != "wasm_github_create_issue" {
+		t.Errorf("tools/call attributes = %+v", call.Attributes)
+	}
+}
+
+func TestTracer_OTLPExporter(t *testing.T) {
+	var got []otlpSpan
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		var req otlpTraces
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		got = append(got, req.ResourceSpans[0].ScopeSpans[0].Spans...)
+	}))
+	defer collector.Close()
+
+	var exportErr error
+	tracer := NewTracer(NewOTLPExporter(collector.URL), func(err error) { exportErr = err })
+	_, span := tracer.Start(context.Background(), "tools/call", SpanKindServer)
+	span.End()
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if exportErr != nil {
+		t.Fatal(exportErr)
+	}
+	if len(got) != 1 || got[0].Name != "tools/call" || got[0].ParentSpanID != "" {
+		t.Errorf("collector got %+v", got)
+	}
+}
+
+func TestTracer_Nil(t *testing.T) {
+	var tracer *Tracer
+	ctx, span := tracer.Start(context.Background(), "tools/call", SpanKindServer)
+	span.SetAttributes("k", "v")
+	span.SetError("boom")
+	span.End()
+	if _, ok := SpanContextFromContext(ctx); ok {
+		t.Error("nil tracer put a span in the context")
+	}
+	header := http.Header{}
+	InjectTraceparent(ctx, header)
+	if header.Get(TraceparentKey) != "" {
+		t.Error("traceparent injected without a span")
+	}
+}
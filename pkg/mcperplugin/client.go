@@ -4,6 +4,8 @@
 // from MCP _meta and build an HTTP client that:
 //   - rewrites https://<targetHost>/... → <proxyURL>/<targetHost>/...
 //   - attaches X-MCPER-Cap header
+//   - attaches the W3C traceparent from _meta to requests to mcper-cloud,
+//     so they join the trace; upstream APIs never see it
 //   - keeps a fresh client per CallToolRequest (no package-level retention)
 //
 // Plugins MUST construct clients per CallToolRequest. Package-level
@@ -17,22 +19,33 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"os"
 	"strings"
 
 	"github.com/modelcontextprotocol/go-sdk/mcp"
 )
 
+// traceparentKey is the W3C trace context header, and the _meta key
+// mcper serve passes it in.
+const traceparentKey = "traceparent"
+
+// legacyProxyEnv is the variable mcper serve gives plugins the mcper-cloud
+// proxy in when it doesn't give them caps.
+const legacyProxyEnv = "MCPER_PROXY_URL"
+
 // CapInfo carries the per-invocation cap context.
 type CapInfo struct {
 	Cap          string
 	InvocationID string
 	ProxyURL     string
+	Traceparent  string // W3C trace context of the tool call, if traced
 }
 
-// CapFromRequest extracts cap/invocation_id/proxy_url from MCP _meta.
-// Returns (zero, false) if any field is missing — caller may then fall
-// back to legacy direct HTTP (when MCPER_USE_CAP_PROXY is off CLI-side)
-// or refuse the call (compile-time enforcement mode).
+// CapFromRequest extracts cap/invocation_id/proxy_url/traceparent from MCP
+// _meta. Returns false if the cap or proxy URL is missing, with only
+// Traceparent set — caller may then fall back to legacy direct HTTP (when
+// MCPER_USE_CAP_PROXY is off CLI-side) or refuse the call (compile-time
+// enforcement mode).
 func CapFromRequest(req *mcp.CallToolRequest) (CapInfo, bool) {
 	if req == nil || req.Params == nil || req.Params.Meta == nil {
 		return CapInfo{}, false
@@ -49,9 +62,10 @@ func CapFromRequest(req *mcp.CallToolRequest) (CapInfo, bool) {
 		Cap:          get("mcper_cap"),
 		InvocationID: get("mcper_invocation_id"),
 		ProxyURL:     get("mcper_proxy_url"),
+		Traceparent:  get(traceparentKey),
 	}
 	if c.Cap == "" || c.ProxyURL == "" {
-		return CapInfo{}, false
+		return CapInfo{Traceparent: c.Traceparent}, false
 	}
 	return c, true
 }
@@ -61,28 +75,53 @@ func CapFromRequest(req *mcp.CallToolRequest) (CapInfo, bool) {
 // endpoint with the cap attached.
 //
 // `cap` and `proxyURL` come from CapFromRequest. If both are empty, the
-// helper returns a passthrough http.DefaultClient (legacy mode); plugins
-// that REQUIRE cap-proxy enforcement should refuse to call this with
-// empty inputs.
+// helper returns a passthrough client (legacy mode): http.DefaultClient,
+// or, when the call is traced, one that adds the traceparent header to
+// requests to the $MCPER_PROXY_URL host and leaves the rest untouched.
+// Plugins that REQUIRE cap-proxy enforcement should refuse to call this
+// with empty inputs.
 func NewProxyAwareClient(targetHost string, info CapInfo) *http.Client {
 	if info.Cap == "" || info.ProxyURL == "" {
-		return http.DefaultClient
+		proxy, err := url.Parse(os.Getenv(legacyProxyEnv))
+		if info.Traceparent == "" || err != nil || proxy.Host == "" {
+			return http.DefaultClient
+		}
+		return &http.Client{
+			Transport: &traceparentTransport{base: http.DefaultTransport, proxyHost: strings.ToLower(proxy.Host), traceparent: info.Traceparent},
+		}
 	}
 	return &http.Client{
 		Transport: &proxyRewriteTransport{
-			base:       http.DefaultTransport,
-			targetHost: strings.ToLower(targetHost),
-			proxyURL:   strings.TrimRight(info.ProxyURL, "/"),
-			cap:        info.Cap,
+			base:        http.DefaultTransport,
+			targetHost:  strings.ToLower(targetHost),
+			proxyURL:    strings.TrimRight(info.ProxyURL, "/"),
+			cap:         info.Cap,
+			traceparent: info.Traceparent,
 		},
 	}
 }
 
+type traceparentTransport struct {
+	base        http.RoundTripper
+	proxyHost   string // the only host that gets the traceparent
+	traceparent string
+}
+
+func (t *traceparentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if strings.ToLower(req.URL.Host) != t.proxyHost {
+		return t.base.RoundTrip(req)
+	}
+	out := req.Clone(req.Context())
+	out.Header.Set(traceparentKey, t.traceparent)
+	return t.base.RoundTrip(out)
+}
+
 type proxyRewriteTransport struct {
-	base       http.RoundTripper
-	targetHost string
-	proxyURL   string
-	cap        string
+	base        http.RoundTripper
+	targetHost  string
+	proxyURL    string
+	cap         string
+	traceparent string
 }
 
 func (t *proxyRewriteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
@@ -103,6 +142,9 @@ func (t *proxyRewriteTransport) RoundTrip(req *http.Request) (*http.Response, er
 	out.URL = parsed
 	out.Host = ""
 	out.Header.Set("X-MCPER-Cap", t.cap)
+	if t.traceparent != "" {
+		out.Header.Set(traceparentKey, t.traceparent)
+	}
 	// Don't leak the plugin's intention to provide its own Authorization;
 	// /proxy strips it, but stripping client-side first prevents
 	// accidental logging.
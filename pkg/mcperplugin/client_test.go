@@ -23,6 +23,7 @@ func TestProxyRewriteTransport(t *testing.T) {
 		Cap:          "test-cap-xyz",
 		InvocationID: "inv-1",
 		ProxyURL:     upstream.URL,
+		Traceparent:  "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
 	}
 	client := NewProxyAwareClient("api.github.com", info)
 
@@ -48,6 +49,9 @@ func TestProxyRewriteTransport(t *testing.T) {
 	if seen.Header.Get("Authorization") != "" {
 		t.Errorf("Authorization should be stripped: %q", seen.Header.Get("Authorization"))
 	}
+	if seen.Header.Get("traceparent") != info.Traceparent {
+		t.Errorf("traceparent = %q, want %q", seen.Header.Get("traceparent"), info.Traceparent)
+	}
 }
 
 func TestProxyRewriteRefusesOtherHost(t *testing.T) {
@@ -69,3 +73,33 @@ func TestEmptyCapPassthrough(t *testing.T) {
 		t.Error("empty cap should return http.DefaultClient (passthrough)")
 	}
 }
+
+func TestTraceparentPassthrough(t *testing.T) {
+	var seen []string
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = append(seen, r.Header.Get("traceparent"))
+	})
+	proxy := httptest.NewServer(handler)
+	defer proxy.Close()
+	upstream := httptest.NewServer(handler)
+	defer upstream.Close()
+	t.Setenv("MCPER_PROXY_URL", proxy.URL)
+
+	info := CapInfo{Traceparent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}
+	client := NewProxyAwareClient("api.github.com", info)
+	for _, u := range []string{proxy.URL + "/api.github.com/user", upstream.URL} {
+		resp, err := client.Get(u)
+		if err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+		resp.Body.Close()
+	}
+	if len(seen) != 2 || seen[0] != info.Traceparent || seen[1] != "" {
+		t.Errorf("traceparent at proxy, upstream = %q, want only the proxy to get %q", seen, info.Traceparent)
+	}
+
+	t.Setenv("MCPER_PROXY_URL", "")
+	if NewProxyAwareClient("api.github.com", info) != http.DefaultClient {
+		t.Error("traced call without a proxy should return http.DefaultClient")
+	}
+}
@@ -0,0 +1,321 @@
+package wasmhost
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/stealthrocket/wasi-go"
+	"github.com/stealthrocket/wasi-go/imports/wasi_http/default_http"
+	"github.com/stealthrocket/wasi-go/imports/wasi_http/streams"
+	"github.com/stealthrocket/wasi-go/imports/wasi_http/types"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+)
+
+// EgressPolicy restricts which hosts a module may reach through the
+// wasmedgev2 sockets extension and the wasi_http import. A nil policy
+// leaves egress unrestricted; a policy with no hosts denies everything.
+//
+// Hosts are matched case-insensitively and may be:
+//   - an exact hostname ("api.github.com")
+//   - a leading wildcard ("*.googleapis.com", which does not match the apex)
+//   - an IP literal ("10.0.0.5")
+//   - "*" to allow any host
+type EgressPolicy struct {
+	module string
+	hosts  []string
+
+	mu       sync.Mutex
+	resolved map[string]string // IP -> hostname it was resolved from
+}
+
+// NewEgressPolicy returns a policy for `module` allowing only `hosts`.
+// Ports in host entries are ignored.
+func NewEgressPolicy(module string, hosts []string) *EgressPolicy {
+	p := &EgressPolicy{
+		module:   module,
+		resolved: make(map[string]string),
+	}
+	for _, h := range hosts {
+		h = normalizeHost(h)
+		if h != "" {
+			p.hosts = append(p.hosts, h)
+		}
+	}
+	return p
+}
+
+// Hosts returns the normalized allowlist.
+func (p *EgressPolicy) Hosts() []string {
+	return append([]string(nil), p.hosts...)
+}
+
+// AllowsHost reports whether `host` (optionally with a port) is in the
+// allowlist.
+func (p *EgressPolicy) AllowsHost(host string) bool {
+	host = normalizeHost(host)
+	if host == "" || strings.ContainsAny(host, "/?#@\\") {
+		return false
+	}
+	for _, allowed := range p.hosts {
+		switch {
+		case allowed == "*":
+			return true
+		case strings.HasPrefix(allowed, "*."):
+			if strings.HasSuffix(host, allowed[1:]) {
+				return true
+			}
+		case allowed == host:
+			return true
+		}
+	}
+	return false
+}
+
+// allowsIP reports whether a socket connect to `ip` is permitted: either
+// the IP is listed directly, or it was returned by an allowed DNS lookup
+// made by this module.
+func (p *EgressPolicy) allowsIP(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	if p.AllowsHost(ip.String()) {
+		return true
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, ok := p.resolved[ip.String()]
+	return ok
+}
+
+// remember records that `ip` was resolved from the allowed `host`, so a
+// later connect to that address is attributed to it.
+func (p *EgressPolicy) remember(ip net.IP, host string) {
+	if ip == nil {
+		return
+	}
+	p.mu.Lock()
+	p.resolved[ip.String()] = host
+	p.mu.Unlock()
+}
+
+// deny logs a refused egress attempt.
+func (p *EgressPolicy) deny(kind, target string) {
+	log.Printf("[WASM EGRESS] Denied %s to %s for module %s (allowed: %s)",
+		kind, target, p.module, strings.Join(p.hosts, ", "))
+}
+
+func normalizeHost(host string) string {
+	host = strings.ToLower(strings.TrimSpace(host))
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(strings.Trim(host, "[]"), ".")
+}
+
+// socketIP extracts the IP from an inet socket address, or nil for any
+// other address family.
+func socketIP(addr wasi.SocketAddress) net.IP {
+	switch a := addr.(type) {
+	case *wasi.Inet4Address:
+		return net.IP(a.Addr[:])
+	case *wasi.Inet6Address:
+		return net.IP(a.Addr[:])
+	}
+	return nil
+}
+
+// egressSystem wraps a wasi.System and refuses DNS lookups and outbound
+// connections that the policy does not allow. The guest sees EACCES.
+type egressSystem struct {
+	wasi.System
+	policy *EgressPolicy
+}
+
+func (s *egressSystem) SockAddressInfo(ctx context.Context, name, service string, hints wasi.AddressInfo, results []wasi.AddressInfo) (int, wasi.Errno) {
+	if !s.policy.AllowsHost(name) {
+		s.policy.deny("DNS lookup", name)
+		return 0, wasi.EACCES
+	}
+	n, errno := s.System.SockAddressInfo(ctx, name, service, hints, results)
+	if errno == wasi.ESUCCESS {
+		for _, r := range results[:n] {
+			s.policy.remember(socketIP(r.Address), name)
+		}
+	}
+	return n, errno
+}
+
+func (s *egressSystem) SockConnect(ctx context.Context, fd wasi.FD, addr wasi.SocketAddress) (wasi.SocketAddress, wasi.Errno) {
+	if !s.policy.allowsIP(socketIP(addr)) {
+		s.policy.deny("connect", addr.String())
+		return nil, wasi.EACCES
+	}
+	return s.System.SockConnect(ctx, fd, addr)
+}
+
+func (s *egressSystem) SockSendTo(ctx context.Context, fd wasi.FD, iovecs []wasi.IOVec, flags wasi.SIFlags, addr wasi.SocketAddress) (wasi.Size, wasi.Errno) {
+	if !s.policy.allowsIP(socketIP(addr)) {
+		s.policy.deny("sendto", addr.String())
+		return 0, wasi.EACCES
+	}
+	return s.System.SockSendTo(ctx, fd, iovecs, flags, addr)
+}
+
+// egressWrapper returns a wasi.System wrapper enforcing `policy`, for use
+// with imports.Builder.WithWrappers.
+func egressWrapper(policy *EgressPolicy) func(wasi.System) wasi.System {
+	return func(system wasi.System) wasi.System {
+		return &egressSystem{System: system, policy: policy}
+	}
+}
+
+type egressPolicyKey struct{}
+
+// withEgressPolicy binds `policy` to the context a module is instantiated
+// with. Host functions are called with that context, which is how the
+// shared wasi_http import finds the policy of the calling module.
+func withEgressPolicy(ctx context.Context, policy *EgressPolicy) context.Context {
+	if policy == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, egressPolicyKey{}, policy)
+}
+
+func egressPolicyFrom(ctx context.Context) *EgressPolicy {
+	p, _ := ctx.Value(egressPolicyKey{}).(*EgressPolicy)
+	return p
+}
+
+// instantiateWasiHTTP is wasi_http.WasiHTTP.Instantiate with the outgoing
+// handler replaced by one that consults the caller's EgressPolicy. The
+// upstream handler sends every request through http.DefaultClient.
+func instantiateWasiHTTP(ctx context.Context, rt wazero.Runtime) error {
+	s := streams.MakeStreams()
+	f := types.MakeFields()
+	r := types.MakeRequests(s, f)
+	rs := types.MakeResponses(s, f)
+	o := types.MakeOutresponses()
+
+	if err := types.Instantiate(ctx, rt, s, r, rs, f, o); err != nil {
+		return err
+	}
+	if err := streams.Instantiate(ctx, rt, s); err != nil {
+		return err
+	}
+	handler := &outgoingHTTP{requests: r, responses: rs, fields: f}
+	_, err := rt.NewHostModuleBuilder(default_http.ModuleName).
+		NewFunctionBuilder().WithFunc(outgoingRequestFn).Export("request").
+		NewFunctionBuilder().WithFunc(handler.handle).Export("handle").
+		Instantiate(ctx)
+	return err
+}
+
+type outgoingHTTP struct {
+	requests  *types.Requests
+	responses *types.Responses
+	fields    *types.FieldsCollection
+}
+
+// outgoingRequestFn mirrors default_http's unimplemented "request" export.
+func outgoingRequestFn(_ context.Context, mod api.Module, a, b, c, d, e, f, g, h, j, k, l, m, n, o uint32) int32 {
+	return 0
+}
+
+// handle performs an outgoing HTTP request for the guest. Requests to hosts
+// outside the caller's policy get a synthetic 403 so the guest sees a
+// readable error instead of an opaque failed handle.
+func (h *outgoingHTTP) handle(ctx context.Context, mod api.Module, request, b, c, d, e, f, g, i uint32) uint32 {
+	req, ok := h.requests.GetRequest(request)
+	if !ok {
+		log.Printf("[WASM HTTP] Failed to get request: %v", request)
+		return 0
+	}
+	policy := egressPolicyFrom(ctx)
+	u, err := outgoingURL(req)
+	if err != nil {
+		if policy != nil {
+			policy.deny("HTTP request", req.Authority)
+		}
+		return h.forbidden("mcper: " + err.Error())
+	}
+	if policy != nil && !policy.AllowsHost(u.Hostname()) {
+		policy.deny("HTTP request", u.Host)
+		return h.forbidden("mcper: egress to " + normalizeHost(u.Hostname()) + " is not allowed for this plugin")
+	}
+
+	var body io.Reader
+	if req.BodyBuffer != nil {
+		body = bytes.NewReader(req.BodyBuffer.Bytes())
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, req.Method, u.String(), body)
+	if err != nil {
+		log.Printf("[WASM HTTP] Request failed: %v", err)
+		return 0
+	}
+	if fields, ok := h.fields.GetFields(req.Headers); ok {
+		httpReq.Header = http.Header(fields)
+	}
+	client := &http.Client{CheckRedirect: policy.checkRedirect}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		log.Printf("[WASM HTTP] Request failed: %v", err)
+		return 0
+	}
+	return h.responses.MakeResponse(resp)
+}
+
+// forbidden returns a synthetic 403 response carrying `msg`.
+func (h *outgoingHTTP) forbidden(msg string) uint32 {
+	return h.responses.MakeResponse(&http.Response{
+		Status:        "403 Forbidden",
+		StatusCode:    http.StatusForbidden,
+		Header:        http.Header{"Content-Type": {"text/plain; charset=utf-8"}},
+		Body:          io.NopCloser(strings.NewReader(msg)),
+		ContentLength: int64(len(msg)),
+	})
+}
+
+// outgoingURL returns the URL a guest's request is sent to. wasi-go joins
+// the request's parts into a string, so an authority holding a path, query,
+// fragment or userinfo would move the real host elsewhere in the URL, e.g.
+// "evil.com/.github.com"; such authorities are refused.
+func outgoingURL(req *types.Request) (*url.URL, error) {
+	if req.Authority == "" || strings.ContainsAny(req.Authority, "/?#@\\") {
+		return nil, fmt.Errorf("invalid request authority %q", req.Authority)
+	}
+	u, err := url.Parse(req.Url())
+	if err != nil {
+		return nil, fmt.Errorf("invalid request URL: %w", err)
+	}
+	if u.Host != req.Authority {
+		return nil, fmt.Errorf("invalid request authority %q", req.Authority)
+	}
+	return u, nil
+}
+
+// maxRedirects is how many redirects a guest's HTTP request follows, as
+// with http.DefaultClient.
+const maxRedirects = 10
+
+// checkRedirect is the CheckRedirect of guests' HTTP clients. A redirect to
+// a host outside the policy isn't followed: the guest gets the redirect
+// response itself. A nil policy follows every redirect.
+func (p *EgressPolicy) checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= maxRedirects {
+		return fmt.Errorf("stopped after %d redirects", maxRedirects)
+	}
+	if p != nil && !p.AllowsHost(req.URL.Hostname()) {
+		p.deny("HTTP redirect", req.URL.Host)
+		return http.ErrUseLastResponse
+	}
+	return nil
+}
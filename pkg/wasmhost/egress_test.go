@@ -0,0 +1,201 @@
+package wasmhost
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stealthrocket/wasi-go"
+	"github.com/stealthrocket/wasi-go/imports/wasi_http/streams"
+	"github.com/stealthrocket/wasi-go/imports/wasi_http/types"
+)
+
+func TestEgressPolicy_AllowsHost(t *testing.T) {
+	p := NewEgressPolicy("plugin-0", []string{"api.github.com", "*.googleapis.com", "10.0.0.5", "Mixed.Example.com:443"})
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{"api.github.com", true},
+		{"API.GitHub.com", true},
+		{"api.github.com:443", true},
+		{"github.com", false},
+		{"evil-api.github.com", false},
+		{"gmail.googleapis.com", true},
+		{"googleapis.com", false},
+		{"10.0.0.5", true},
+		{"10.0.0.6", false},
+		{"mixed.example.com", true},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := p.AllowsHost(tt.host); got != tt.want {
+			t.Errorf("AllowsHost(%q) = %v, want %v", tt.host, got, tt.want)
+		}
+	}
+
+	if NewEgressPolicy("plugin-0", nil).AllowsHost("api.github.com") {
+		t.Errorf("empty policy should deny everything")
+	}
+	if !NewEgressPolicy("plugin-0", []string{"*"}).AllowsHost("anything.internal") {
+		t.Errorf(`"*" should allow everything`)
+	}
+}
+
+// fakeSystem resolves every name to 192.0.2.1 and accepts every connect.
+type fakeSystem struct {
+	wasi.System
+	connects int
+}
+
+func (f *fakeSystem) SockAddressInfo(ctx context.Context, name, service string, hints wasi.AddressInfo, results []wasi.AddressInfo) (int, wasi.Errno) {
+	results[0] = wasi.AddressInfo{Address: &wasi.Inet4Address{Port: 443, Addr: [4]byte{192, 0, 2, 1}}}
+	return 1, wasi.ESUCCESS
+}
+
+func (f *fakeSystem) SockConnect(ctx context.Context, fd wasi.FD, addr wasi.SocketAddress) (wasi.SocketAddress, wasi.Errno) {
+	f.connects++
+	return addr, wasi.ESUCCESS
+}
+
+func TestEgressSystem_DeniesUnlistedHosts(t *testing.T) {
+	ctx := context.Background()
+	inner := &fakeSystem{}
+	sys := egressWrapper(NewEgressPolicy("plugin-0", []string{"api.github.com"}))(inner)
+
+	results := make([]wasi.AddressInfo, 1)
+	if _, errno := sys.SockAddressInfo(ctx, "intranet.corp", "443", wasi.AddressInfo{}, results); errno != wasi.EACCES {
+		t.Errorf("lookup of unlisted host: errno = %v, want EACCES", errno)
+	}
+
+	direct := &wasi.Inet4Address{Port: 443, Addr: [4]byte{192, 0, 2, 1}}
+	if _, errno := sys.SockConnect(ctx, 3, direct); errno != wasi.EACCES {
+		t.Errorf("connect before allowed lookup: errno = %v, want EACCES", errno)
+	}
+
+	if _, errno := sys.SockAddressInfo(ctx, "api.github.com", "443", wasi.AddressInfo{}, results); errno != wasi.ESUCCESS {
+		t.Fatalf("lookup of allowed host: errno = %v", errno)
+	}
+	if _, errno := sys.SockConnect(ctx, 3, direct); errno != wasi.ESUCCESS {
+		t.Errorf("connect to resolved address: errno = %v, want ESUCCESS", errno)
+	}
+	if inner.connects != 1 {
+		t.Errorf("inner connects = %d, want 1", inner.connects)
+	}
+
+	other := &wasi.Inet6Address{Port: 443}
+	copy(other.Addr[:], net.ParseIP("2001:db8::1"))
+	if _, errno := sys.SockConnect(ctx, 3, other); errno != wasi.EACCES {
+		t.Errorf("connect to unresolved IPv6: errno = %v, want EACCES", errno)
+	}
+}
+
+// newOutgoingHTTP returns an outgoingHTTP and a context carrying an egress
+// policy allowing `hosts`.
+func newOutgoingHTTP(hosts ...string) (*outgoingHTTP, context.Context) {
+	s := streams.MakeStreams()
+	f := types.MakeFields()
+	h := &outgoingHTTP{requests: types.MakeRequests(s, f), responses: types.MakeResponses(s, f), fields: f}
+	return h, withEgressPolicy(context.Background(), NewEgressPolicy("plugin-0", hosts))
+}
+
+// send has `h` perform a guest's GET of `path` on `authority` and returns
+// the response the guest would read.
+func (h *outgoingHTTP) send(t *testing.T, ctx context.Context, authority, path string) *types.Response {
+	t.Helper()
+	id := h.requests.MakeRequest(httptest.NewRequest(http.MethodGet, path, nil))
+	req, _ := h.requests.GetRequest(id)
+	req.Scheme = "http"
+	req.Authority = authority
+	res, ok := h.responses.GetResponse(h.handle(ctx, nil, id, 0, 0, 0, 0, 0, 0, 0))
+	if !ok {
+		t.Fatalf("no response for %s", authority)
+	}
+	return res
+}
+
+func TestOutgoingHTTP_DeniesUnlistedHosts(t *testing.T) {
+	var hits int
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		io.WriteString(w, "upstream")
+	}))
+	defer upstream.Close()
+	upstreamURL, _ := url.Parse(upstream.URL)
+	h, ctx := newOutgoingHTTP(upstreamURL.Hostname())
+
+	res := h.send(t, ctx, "intranet.corp", "/")
+	body, _ := io.ReadAll(res.Body)
+	if res.StatusCode != http.StatusForbidden || !strings.Contains(string(body), "intranet.corp is not allowed") {
+		t.Errorf("unlisted host: %d %q, want a 403 naming the host", res.StatusCode, body)
+	}
+	if hits != 0 {
+		t.Errorf("denied request reached a server")
+	}
+
+	res = h.send(t, ctx, upstreamURL.Host, "/")
+	body, _ = io.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK || string(body) != "upstream" || hits != 1 {
+		t.Errorf("allowed host: %d %q after %d hits, want upstream's 200", res.StatusCode, body, hits)
+	}
+}
+
+func TestOutgoingHTTP_DeniesSmuggledAuthorities(t *testing.T) {
+	h, ctx := newOutgoingHTTP("*.github.com")
+
+	// Each authority ends in ".github.com", but the URL built from it is
+	// sent to evil.com.
+	for _, authority := range []string{
+		"evil.com/.github.com",
+		"evil.com?.github.com",
+		"evil.com#.github.com",
+		"evil.com\\.github.com",
+		"x.github.com@evil.com@x.github.com",
+	} {
+		res := h.send(t, ctx, authority, "/")
+		if res.StatusCode != http.StatusForbidden {
+			t.Errorf("%s: status %d, want 403", authority, res.StatusCode)
+		}
+	}
+}
+
+func TestOutgoingHTTP_ChecksRedirects(t *testing.T) {
+	var elsewhereHits int
+	elsewhere := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		elsewhereHits++
+	}))
+	defer elsewhere.Close()
+	elsewhereURL, _ := url.Parse(elsewhere.URL)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/away":
+			http.Redirect(w, r, "http://localhost:"+elsewhereURL.Port()+"/", http.StatusFound)
+		case "/here":
+			http.Redirect(w, r, "/final", http.StatusFound)
+		default:
+			io.WriteString(w, "final")
+		}
+	}))
+	defer upstream.Close()
+	upstreamURL, _ := url.Parse(upstream.URL)
+	h, ctx := newOutgoingHTTP(upstreamURL.Hostname())
+
+	res := h.send(t, ctx, upstreamURL.Host, "/away")
+	if res.StatusCode != http.StatusFound || elsewhereHits != 0 {
+		t.Errorf("redirect to an unlisted host: status %d after %d hits, want the 302 itself", res.StatusCode, elsewhereHits)
+	}
+
+	res = h.send(t, ctx, upstreamURL.Host, "/here")
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK || string(body) != "final" {
+		t.Errorf("redirect to an allowed host: %d %q, want it followed", res.StatusCode, body)
+	}
+}
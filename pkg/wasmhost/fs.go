@@ -0,0 +1,177 @@
+package wasmhost
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/stealthrocket/wasi-go"
+)
+
+// Mount is a host directory preopened for a module. The guest sees it at
+// GuestPath, which may differ from HostPath.
+type Mount struct {
+	HostPath  string
+	GuestPath string
+	ReadOnly  bool
+}
+
+// dirSpec returns the imports.Builder.WithDirs form of the mount. wasi-go
+// only preopens a directory under its host path, so remapping is done by
+// mountSystem.
+func (m Mount) dirSpec() string {
+	if m.ReadOnly {
+		return m.HostPath + ":ro"
+	}
+	return m.HostPath
+}
+
+// guestPath returns the path the guest sees the mount at.
+func (m Mount) guestPath() string {
+	if m.GuestPath == "" {
+		return m.HostPath
+	}
+	return m.GuestPath
+}
+
+// mutatingRights are the rights that let a guest change a directory tree.
+// wasi-go only withholds wasi.WriteRights from a read-only preopen, which
+// still lets the guest create, delete and rename files in it.
+const mutatingRights = wasi.WriteRights |
+	wasi.FDFileStatSetSizeRight | wasi.FDFileStatSetTimesRight |
+	wasi.PathCreateDirectoryRight | wasi.PathCreateFileRight |
+	wasi.PathLinkSourceRight | wasi.PathLinkTargetRight |
+	wasi.PathRenameSourceRight | wasi.PathRenameTargetRight |
+	wasi.PathFileStatSetSizeRight | wasi.PathFileStatSetTimesRight |
+	wasi.PathSymlinkRight | wasi.PathRemoveDirectoryRight | wasi.PathUnlinkFileRight
+
+// sealReadOnlyMounts drops mutatingRights from the preopens of the
+// read-only mounts in `system`, and so from everything opened beneath them.
+func sealReadOnlyMounts(ctx context.Context, system wasi.System, mounts []Mount) error {
+	readOnly := make(map[string]bool)
+	for _, m := range mounts {
+		if m.ReadOnly {
+			readOnly[m.guestPath()] = true
+		}
+	}
+	if len(readOnly) == 0 {
+		return nil
+	}
+	// Preopens are numbered from 3 up, ending at the first descriptor
+	// FDPreStatGet rejects.
+	for fd := wasi.FD(3); ; fd++ {
+		stat, errno := system.FDPreStatGet(ctx, fd)
+		if errno != wasi.ESUCCESS {
+			return nil
+		}
+		if stat.Type != wasi.PreOpenDir {
+			continue
+		}
+		name, errno := system.FDPreStatDirName(ctx, fd)
+		if errno != wasi.ESUCCESS {
+			return fmt.Errorf("preopen %d: %w", fd, errno)
+		}
+		if !readOnly[name] {
+			continue
+		}
+		fdStat, errno := system.FDStatGet(ctx, fd)
+		if errno == wasi.ESUCCESS {
+			errno = system.FDStatSetRights(ctx, fd, fdStat.RightsBase&^mutatingRights, fdStat.RightsInheriting&^mutatingRights)
+		}
+		if errno != wasi.ESUCCESS {
+			return fmt.Errorf("making %s read-only: %w", name, errno)
+		}
+	}
+}
+
+// mountSystem renames preopened directories so the guest resolves them
+// under their guest paths. File operations are relative to the preopen's
+// descriptor, so only the advertised name needs rewriting.
+//
+// sealReadOnlyMounts makes a mount read-only by withholding rights, so
+// writes under one fail with ENOTCAPABLE. mountSystem reports them as EROFS
+// instead, which guests surface as "read-only file system" rather than an
+// unexplained permission error.
+type mountSystem struct {
+	wasi.System
+	guestPaths map[string]string // host path -> guest path
+	readOnly   bool              // some mount is read-only
+}
+
+func (s *mountSystem) FDPreStatGet(ctx context.Context, fd wasi.FD) (wasi.PreStat, wasi.Errno) {
+	stat, errno := s.System.FDPreStatGet(ctx, fd)
+	if errno != wasi.ESUCCESS || stat.Type != wasi.PreOpenDir {
+		return stat, errno
+	}
+	if name, errno := s.FDPreStatDirName(ctx, fd); errno == wasi.ESUCCESS {
+		stat.PreStatDir.NameLength = wasi.Size(len(name))
+	}
+	return stat, errno
+}
+
+func (s *mountSystem) FDPreStatDirName(ctx context.Context, fd wasi.FD) (string, wasi.Errno) {
+	name, errno := s.System.FDPreStatDirName(ctx, fd)
+	if guest, ok := s.guestPaths[name]; ok && errno == wasi.ESUCCESS {
+		return guest, errno
+	}
+	return name, errno
+}
+
+// readOnlyErrno maps the ENOTCAPABLE a write under a read-only mount
+// fails with to EROFS.
+func (s *mountSystem) readOnlyErrno(errno wasi.Errno) wasi.Errno {
+	if s.readOnly && errno == wasi.ENOTCAPABLE {
+		return wasi.EROFS
+	}
+	return errno
+}
+
+func (s *mountSystem) PathOpen(ctx context.Context, fd wasi.FD, dirFlags wasi.LookupFlags, path string, openFlags wasi.OpenFlags, rightsBase, rightsInheriting wasi.Rights, fdFlags wasi.FDFlags) (wasi.FD, wasi.Errno) {
+	newFD, errno := s.System.PathOpen(ctx, fd, dirFlags, path, openFlags, rightsBase, rightsInheriting, fdFlags)
+	if openFlags&(wasi.OpenCreate|wasi.OpenTruncate) != 0 || rightsBase&wasi.WriteRights != 0 || fdFlags&wasi.Append != 0 {
+		errno = s.readOnlyErrno(errno)
+	}
+	return newFD, errno
+}
+
+func (s *mountSystem) PathCreateDirectory(ctx context.Context, fd wasi.FD, path string) wasi.Errno {
+	return s.readOnlyErrno(s.System.PathCreateDirectory(ctx, fd, path))
+}
+
+func (s *mountSystem) PathLink(ctx context.Context, oldFD wasi.FD, oldFlags wasi.LookupFlags, oldPath string, newFD wasi.FD, newPath string) wasi.Errno {
+	return s.readOnlyErrno(s.System.PathLink(ctx, oldFD, oldFlags, oldPath, newFD, newPath))
+}
+
+func (s *mountSystem) PathRemoveDirectory(ctx context.Context, fd wasi.FD, path string) wasi.Errno {
+	return s.readOnlyErrno(s.System.PathRemoveDirectory(ctx, fd, path))
+}
+
+func (s *mountSystem) PathRename(ctx context.Context, fd wasi.FD, oldPath string, newFD wasi.FD, newPath string) wasi.Errno {
+	return s.readOnlyErrno(s.System.PathRename(ctx, fd, oldPath, newFD, newPath))
+}
+
+func (s *mountSystem) PathSymlink(ctx context.Context, oldPath string, fd wasi.FD, newPath string) wasi.Errno {
+	return s.readOnlyErrno(s.System.PathSymlink(ctx, oldPath, fd, newPath))
+}
+
+func (s *mountSystem) PathUnlinkFile(ctx context.Context, fd wasi.FD, path string) wasi.Errno {
+	return s.readOnlyErrno(s.System.PathUnlinkFile(ctx, fd, path))
+}
+
+// mountWrapper returns a wasi.System wrapper for `mounts`, or nil when
+// every mount is writable and visible under its host path.
+func mountWrapper(mounts []Mount) func(wasi.System) wasi.System {
+	guestPaths := make(map[string]string)
+	readOnly := false
+	for _, m := range mounts {
+		if m.GuestPath != "" && m.GuestPath != m.HostPath {
+			guestPaths[m.HostPath] = m.GuestPath
+		}
+		readOnly = readOnly || m.ReadOnly
+	}
+	if len(guestPaths) == 0 && !readOnly {
+		return nil
+	}
+	return func(system wasi.System) wasi.System {
+		return &mountSystem{System: system, guestPaths: guestPaths, readOnly: readOnly}
+	}
+}
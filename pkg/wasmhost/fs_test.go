@@ -0,0 +1,158 @@
+package wasmhost
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stealthrocket/wasi-go"
+	"github.com/tetratelabs/wazero"
+)
+
+// emptyModule is the smallest valid WASM module.
+var emptyModule = []byte("\x00asm\x01\x00\x00\x00")
+
+// mountedSystem instantiates the WASI system a module would run against
+// with `mounts`, as RunModule does.
+func mountedSystem(t *testing.T, mounts []Mount) (context.Context, wasi.System) {
+	t.Helper()
+	ctx := context.Background()
+	runtime := wazero.NewRuntime(ctx)
+	t.Cleanup(func() { runtime.Close(ctx) })
+	compiled, err := runtime.CompileModule(ctx, emptyModule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdinR, stdinW, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdinR.Close()
+	defer stdinW.Close()
+	stdoutR, stdoutW, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdoutR.Close()
+	defer stdoutW.Close()
+
+	opts := RunOptions{Mounts: mounts}
+	ctx, system, err := opts.builder(compiled, stdinR, stdoutW).Instantiate(ctx, runtime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { system.Close(context.Background()) })
+	if err := sealReadOnlyMounts(ctx, system, mounts); err != nil {
+		t.Fatal(err)
+	}
+	return ctx, system
+}
+
+// preopens returns the guest's preopened directories by name, found the
+// way WASI libcs do: walking descriptors from 3 until FDPreStatGet fails.
+func preopens(t *testing.T, ctx context.Context, system wasi.System) map[string]wasi.FD {
+	t.Helper()
+	dirs := make(map[string]wasi.FD)
+	for fd := wasi.FD(3); ; fd++ {
+		stat, errno := system.FDPreStatGet(ctx, fd)
+		if errno != wasi.ESUCCESS {
+			return dirs
+		}
+		if stat.Type != wasi.PreOpenDir {
+			continue
+		}
+		name, errno := system.FDPreStatDirName(ctx, fd)
+		if errno != wasi.ESUCCESS {
+			t.Fatalf("FDPreStatDirName(%d) = %v", fd, errno)
+		}
+		if int(stat.PreStatDir.NameLength) != len(name) {
+			t.Errorf("preopen %q: name length %d, want %d", name, stat.PreStatDir.NameLength, len(name))
+		}
+		dirs[name] = fd
+	}
+}
+
+func TestMountSystem_ReadOnlyGuestPath(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "hello.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ctx, system := mountedSystem(t, []Mount{{HostPath: dir, GuestPath: "/docs", ReadOnly: true}})
+
+	dirs := preopens(t, ctx, system)
+	if _, ok := dirs[dir]; ok {
+		t.Errorf("guest sees the host path %s", dir)
+	}
+	docs, ok := dirs["/docs"]
+	if !ok {
+		t.Fatalf("guest preopens = %v, want /docs", dirs)
+	}
+
+	fd, errno := system.PathOpen(ctx, docs, 0, "hello.txt", 0, wasi.FDReadRight, 0, 0)
+	if errno != wasi.ESUCCESS {
+		t.Fatalf("open /docs/hello.txt = %v", errno)
+	}
+	buf := make([]byte, 16)
+	n, errno := system.FDRead(ctx, fd, []wasi.IOVec{buf})
+	if errno != wasi.ESUCCESS || string(buf[:n]) != "hello" {
+		t.Errorf("read /docs/hello.txt = %q, %v", buf[:n], errno)
+	}
+	system.FDClose(ctx, fd)
+
+	writes := []struct {
+		name   string
+		path   string
+		flags  wasi.OpenFlags
+		rights wasi.Rights
+	}{
+		{"create", "new.txt", wasi.OpenCreate, wasi.FDWriteRight},
+		{"create read-only", "new.txt", wasi.OpenCreate, wasi.FDReadRight},
+		{"overwrite", "hello.txt", wasi.OpenTruncate, wasi.FDWriteRight},
+	}
+	for _, w := range writes {
+		fd, errno := system.PathOpen(ctx, docs, 0, w.path, w.flags, w.rights, 0, 0)
+		if errno == wasi.ESUCCESS {
+			system.FDClose(ctx, fd)
+		}
+		if errno != wasi.EROFS {
+			t.Errorf("%s: open /docs/%s for writing = %v, want EROFS", w.name, w.path, errno)
+		}
+	}
+	if errno := system.PathCreateDirectory(ctx, docs, "sub"); errno != wasi.EROFS {
+		t.Errorf("mkdir /docs/sub = %v, want EROFS", errno)
+	}
+	if errno := system.PathUnlinkFile(ctx, docs, "hello.txt"); errno != wasi.EROFS {
+		t.Errorf("unlink /docs/hello.txt = %v, want EROFS", errno)
+	}
+	if errno := system.PathRename(ctx, docs, "hello.txt", docs, "moved.txt"); errno != wasi.EROFS {
+		t.Errorf("rename /docs/hello.txt = %v, want EROFS", errno)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "new.txt")); !os.IsNotExist(err) {
+		t.Errorf("read-only mount let the guest create a file: %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "hello.txt")); string(data) != "hello" {
+		t.Errorf("read-only mount let the guest truncate a file: %q", data)
+	}
+}
+
+func TestMountSystem_WritableGuestPath(t *testing.T) {
+	dir := t.TempDir()
+	ctx, system := mountedSystem(t, []Mount{{HostPath: dir, GuestPath: "/cache"}})
+
+	cache, ok := preopens(t, ctx, system)["/cache"]
+	if !ok {
+		t.Fatal("guest has no /cache preopen")
+	}
+	fd, errno := system.PathOpen(ctx, cache, 0, "out.txt", wasi.OpenCreate, wasi.FDWriteRight, 0, 0)
+	if errno != wasi.ESUCCESS {
+		t.Fatalf("create /cache/out.txt = %v", errno)
+	}
+	if _, errno := system.FDWrite(ctx, fd, []wasi.IOVec{[]byte("ok")}); errno != wasi.ESUCCESS {
+		t.Errorf("write /cache/out.txt = %v", errno)
+	}
+	system.FDClose(ctx, fd)
+	if data, err := os.ReadFile(filepath.Join(dir, "out.txt")); err != nil || string(data) != "ok" {
+		t.Errorf("host file = %q, %v; want ok", data, err)
+	}
+}
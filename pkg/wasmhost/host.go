@@ -2,209 +2,322 @@ package wasmhost
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
 	"os"
+	"path/filepath"
+	"strings"
 	"sync"
+	"time"
 
+	"github.com/stealthrocket/wasi-go"
 	"github.com/stealthrocket/wasi-go/imports"
 	"github.com/stealthrocket/wasi-go/imports/wasi_http"
 	"github.com/tetratelabs/wazero"
 	"github.com/tetratelabs/wazero/sys"
 )
 
-// WasmHost manages a single wazero runtime and a cache of compiled modules.
+// WasmHost compiles modules once and runs each instance in a runtime of its
+// own, so every instance carries its module's Limits and can be torn down
+// without affecting the others. Compiled code is shared through a
+// compilation cache, persisted to disk when the host has a cache directory.
 type WasmHost struct {
-	runtime        wazero.Runtime
-	cache          map[string]wazero.CompiledModule
-	wasiHTTPLoaded bool
-	wasiSystem     interface{} // Store the WASI system instance
-	mu             sync.RWMutex
+	compilationCache wazero.CompilationCache
+	compiledDir      string
+	modules          map[string]*hostedModule
+	mu               sync.RWMutex
+}
+
+// hostedModule is a loaded module and the limits its instances run under.
+type hostedModule struct {
+	wasm   []byte
+	limits Limits
+	cache  wazero.CompilationCache
 }
 
 // NewWasmHost creates a new host for running WASM modules.
 func NewWasmHost(ctx context.Context) *WasmHost {
-	runtime := wazero.NewRuntime(ctx)
 	return &WasmHost{
-		runtime:        runtime,
-		cache:          make(map[string]wazero.CompiledModule),
-		wasiHTTPLoaded: false,
-		wasiSystem:     nil,
+		compilationCache: wazero.NewCompilationCache(),
+		modules:          make(map[string]*hostedModule),
 	}
 }
 
 // NewLoggingWasmHost creates a new host for running WASM modules with HTTP request logging.
 func NewLoggingWasmHost(ctx context.Context) *WasmHost {
-	runtime := wazero.NewRuntime(ctx)
-	return &WasmHost{
-		runtime:        runtime,
-		cache:          make(map[string]wazero.CompiledModule),
-		wasiHTTPLoaded: false,
-		wasiSystem:     nil,
+	return NewWasmHost(ctx)
+}
+
+// NewWasmHostWithCacheDir creates a host that persists compiled code under
+// dir, in one subdirectory per module named after the SHA256 of its bytes,
+// so later runs of an unchanged module skip compilation.
+func NewWasmHostWithCacheDir(ctx context.Context, dir string) *WasmHost {
+	h := NewWasmHost(ctx)
+	h.compiledDir = dir
+	return h
+}
+
+// moduleCache returns the compilation cache for `wasmBytes`. Falling back
+// to the shared in-memory cache only costs a recompile on the next run.
+func (h *WasmHost) moduleCache(name string, wasmBytes []byte) wazero.CompilationCache {
+	if h.compiledDir == "" {
+		return h.compilationCache
+	}
+	sum := sha256.Sum256(wasmBytes)
+	dir := filepath.Join(h.compiledDir, hex.EncodeToString(sum[:]))
+	cache, err := wazero.NewCompilationCacheWithDir(dir)
+	if err != nil {
+		slog.Warn("Compiled cache unavailable, compiling in memory", "module", name, "err", err)
+		return h.compilationCache
 	}
+	// Mark the entry as used so `mcper cache prune --older-than` keeps it.
+	now := time.Now()
+	os.Chtimes(dir, now, now)
+	return cache
+}
+
+// newRuntime returns a runtime enforcing `limits` that compiles through
+// `cache`. Closing the context passed to a guest call terminates the guest,
+// which is how lifetime limits and Instance.Kill take effect.
+func newRuntime(ctx context.Context, cache wazero.CompilationCache, limits Limits) wazero.Runtime {
+	config := wazero.NewRuntimeConfig().
+		WithCompilationCache(cache).
+		WithCloseOnContextDone(true)
+	if limits.MaxMemoryPages > 0 {
+		config = config.WithMemoryLimitPages(limits.MaxMemoryPages)
+	}
+	return wazero.NewRuntimeWithConfig(ctx, config)
+}
+
+// RunOptions configures a single module instance.
+type RunOptions struct {
+	// Env is a list of KEY=VALUE pairs exposed to the guest.
+	Env []string
+	// Egress restricts outbound network access. nil means unrestricted.
+	Egress *EgressPolicy
+	// Mounts are the only host directories the guest can see. Empty means
+	// no filesystem access.
+	Mounts []Mount
+}
+
+// builder returns the WASI builder shared by RunModule and
+// RunModuleWithLogging.
+func (opts RunOptions) builder(compiledModule wazero.CompiledModule, stdin, stdout *os.File) *imports.Builder {
+	dirs := make([]string, 0, len(opts.Mounts))
+	for _, m := range opts.Mounts {
+		dirs = append(dirs, m.dirSpec())
+	}
+	var wrappers []func(wasi.System) wasi.System
+	if opts.Egress != nil {
+		wrappers = append(wrappers, egressWrapper(opts.Egress))
+	}
+	if wrap := mountWrapper(opts.Mounts); wrap != nil {
+		wrappers = append(wrappers, wrap)
+	}
+	return imports.NewBuilder().
+		WithNonBlockingStdio(true).
+		WithSocketsExtension("wasmedgev2", compiledModule).
+		WithDirs(dirs...).
+		WithStdio(int(stdin.Fd()), int(stdout.Fd()), int(os.Stderr.Fd())).
+		WithEnv(opts.Env...).
+		WithWrappers(wrappers...)
 }
 
 // RunModuleWithLogging runs a module with comprehensive logging of all I/O operations
-func (h *WasmHost) RunModuleWithLogging(ctx context.Context, name string, envVars ...string) (io.Reader, io.Writer, error) {
-	log.Printf("[WASM HOST] Starting module execution: %s", name)
+func (h *WasmHost) RunModuleWithLogging(ctx context.Context, name string, opts RunOptions) (*Instance, error) {
+	return h.run(ctx, name, opts, true)
+}
+
+// RunModule starts an instance of a loaded module.
+func (h *WasmHost) RunModule(ctx context.Context, name string, opts RunOptions) (*Instance, error) {
+	return h.run(ctx, name, opts, false)
+}
+
+func (h *WasmHost) run(ctx context.Context, name string, opts RunOptions, verbose bool) (*Instance, error) {
+	logf := func(format string, args ...any) {
+		if verbose {
+			log.Printf("[WASM HOST] "+format, args...)
+		}
+	}
+	logf("Starting module execution: %s", name)
 
 	h.mu.RLock()
-	compiledModule, exists := h.cache[name]
+	module, exists := h.modules[name]
 	h.mu.RUnlock()
 
 	if !exists {
-		return nil, nil, fmt.Errorf("module %s not found in cache", name)
+		return nil, fmt.Errorf("module %s not found in cache", name)
+	}
+
+	// The memory limit is enforced by the instance's allocator rather than
+	// the runtime, so a refused grow can be told apart from other failures.
+	// LoadModule already checked the module's declared memory against it.
+	runtime := newRuntime(ctx, module.cache, Limits{})
+	started := false
+	defer func() {
+		if !started {
+			runtime.Close(context.Background())
+		}
+	}()
+
+	compiledModule, err := runtime.CompileModule(ctx, module.wasm)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile wasm module %s: %w", name, err)
 	}
 
 	// Create pipes for stdio for this specific instance.
 	hostToWasmR, hostToWasmW, err := os.Pipe()
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to create stdin pipe: %w", err)
+		return nil, fmt.Errorf("failed to create stdin pipe: %w", err)
 	}
 	wasmToHostR, wasmToHostW, err := os.Pipe()
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to create stdout pipe: %w", err)
+		hostToWasmR.Close()
+		hostToWasmW.Close()
+		return nil, fmt.Errorf("failed to create stdout pipe: %w", err)
 	}
+	// The builder duplicates the guest's ends, so ours can be closed once it
+	// is done; the host then sees EOF when the guest exits.
+	defer hostToWasmR.Close()
+	defer wasmToHostW.Close()
+	defer func() {
+		if !started {
+			hostToWasmW.Close()
+			wasmToHostR.Close()
+		}
+	}()
 
-	log.Printf("[WASM HOST] Created pipes for module %s", name)
+	logf("Created pipes for module %s", name)
 
 	// Step 1: Configure the WASI system with all extensions
-	builder := imports.NewBuilder().
-		WithNonBlockingStdio(true).
-		WithSocketsExtension("wasmedgev2", compiledModule).
-		WithDirs("/").
-		WithStdio(int(hostToWasmR.Fd()), int(wasmToHostW.Fd()), int(os.Stderr.Fd())).
-		WithEnv(envVars...)
+	builder := opts.builder(compiledModule, hostToWasmR, wasmToHostW)
 
-	log.Printf("[WASM HOST] Configured WASI builder for module %s with %d env vars", name, len(envVars))
+	logf("Configured WASI builder for module %s with %d env vars", name, len(opts.Env))
+	if opts.Egress != nil {
+		logf("Egress for module %s restricted to: %s", name, strings.Join(opts.Egress.Hosts(), ", "))
+	}
+	for _, m := range opts.Mounts {
+		logf("Module %s mounts %s at %s (read-only: %v)", name, m.HostPath, m.GuestPath, m.ReadOnly)
+	}
 
 	// Step 2: Instantiate the WASI system
-	ctx, _, err = builder.Instantiate(ctx, h.runtime)
+	ctx, system, err := builder.Instantiate(ctx, runtime)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to instantiate WASI system: %w", err)
+		return nil, fmt.Errorf("failed to instantiate WASI system: %w", err)
+	}
+	if err := sealReadOnlyMounts(ctx, system, opts.Mounts); err != nil {
+		system.Close(context.Background())
+		return nil, err
 	}
 
-	log.Printf("[WASM HOST] Instantiated WASI system for module %s", name)
+	logf("Instantiated WASI system for module %s", name)
+	ctx = withEgressPolicy(ctx, opts.Egress)
 
-	// Step 3: Instantiate WASI HTTP v1 extension (only once per runtime)
-	h.mu.Lock()
-	if !h.wasiHTTPLoaded {
-		wasiHTTP := wasi_http.MakeWasiHTTP()
-		if err := wasiHTTP.Instantiate(ctx, h.runtime); err != nil {
-			h.mu.Unlock()
-			return nil, nil, fmt.Errorf("failed to instantiate WASI HTTP: %v", err)
-		}
-		h.wasiHTTPLoaded = true
-		log.Printf("[WASM HOST] Instantiated WASI HTTP for runtime (first module: %s)", name)
-	} else {
-		log.Printf("[WASM HOST] WASI HTTP already loaded, skipping for module %s", name)
+	// Step 3: Instantiate WASI HTTP v1 extension
+	if err := instantiateWasiHTTP(ctx, runtime); err != nil {
+		system.Close(context.Background())
+		return nil, fmt.Errorf("failed to instantiate WASI HTTP: %v", err)
 	}
-	h.mu.Unlock()
 
 	// Step 4: Instantiate and run the WASM module
+	var cancel context.CancelFunc
+	if module.limits.Lifetime > 0 {
+		ctx, cancel = context.WithTimeout(ctx, module.limits.Lifetime)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	inst := newInstance(name, wasmToHostR, hostToWasmW, cancel)
+	if verbose {
+		inst.reader = &loggingReader{wasmToHostR, name}
+		inst.writer = &loggingWriter{hostToWasmW, name}
+	}
+	started = true
+
 	go func() {
-		log.Printf("[WASM HOST] Starting WASM module execution in goroutine: %s", name)
-		wazeroConfig := wazero.NewModuleConfig()
-		if _, err = h.runtime.InstantiateModule(ctx, compiledModule, wazeroConfig); err != nil {
-			// Handle exit errors properly
-			if exitErr, ok := err.(*sys.ExitError); ok && exitErr.ExitCode() != 0 {
-				log.Printf("[WASM HOST] Module %s exited with code: %d", name, exitErr.ExitCode())
-			} else {
-				log.Printf("[WASM HOST] Failed to instantiate module %s: %v", name, err)
-			}
+		logf("Starting WASM module execution in goroutine: %s", name)
+		startCtx, memory := withMemoryLimit(ctx, module.limits.MaxMemoryPages)
+		_, err := runStart(startCtx, runtime, compiledModule, inst.setModule)
+		err = exitError(name, memory, module.limits, err)
+		cancel()
+		system.Close(context.Background())
+		runtime.Close(context.Background())
+		if err != nil {
+			log.Printf("[WASM HOST] Module %s stopped: %v", name, err)
 		} else {
-			log.Printf("[WASM HOST] Module %s executed successfully", name)
+			logf("Module %s executed successfully", name)
 		}
+		inst.finish(err)
 	}()
 
-	log.Printf("[WASM HOST] Module %s setup completed, returning pipes", name)
+	logf("Module %s setup completed, returning instance", name)
+	return inst, nil
+}
 
-	// Return wrapped pipes that log all I/O
-	return &loggingReader{wasmToHostR, name}, &loggingWriter{hostToWasmW, name}, nil
+// HasModule reports whether `name` has been loaded.
+func (h *WasmHost) HasModule(name string) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	_, ok := h.modules[name]
+	return ok
 }
 
-// LoadModule compiles a WASM module from its bytes and caches it for future runs.
-func (h *WasmHost) LoadModule(ctx context.Context, name string, wasmBytes []byte) error {
+// LoadModule compiles a WASM module from its bytes and caches it for future
+// runs. Every instance of the module is held to `limits`.
+func (h *WasmHost) LoadModule(ctx context.Context, name string, wasmBytes []byte, limits Limits) error {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
-	if _, exists := h.cache[name]; exists {
+	if _, exists := h.modules[name]; exists {
 		return fmt.Errorf("module %s is already loaded", name)
 	}
 
-	compiledModule, err := h.runtime.CompileModule(ctx, wasmBytes)
-	if err != nil {
+	// Compile once up front so invalid modules (or ones whose declared
+	// memory exceeds the limit) fail here, and later runs hit the cache.
+	cache := h.moduleCache(name, wasmBytes)
+	runtime := newRuntime(ctx, cache, limits)
+	defer runtime.Close(ctx)
+	if _, err := runtime.CompileModule(ctx, wasmBytes); err != nil {
+		if cache != h.compilationCache {
+			cache.Close(ctx)
+		}
 		return fmt.Errorf("failed to compile wasm module %s: %w", name, err)
 	}
 
-	h.cache[name] = compiledModule
+	h.modules[name] = &hostedModule{wasm: wasmBytes, limits: limits, cache: cache}
 	log.Printf("Compiled and cached WASM module: %s", name)
 	return nil
 }
 
-// RunModule instantiates a pre-compiled module from the cache and runs it.
-func (h *WasmHost) RunModule(ctx context.Context, name string) (io.Reader, io.Writer, error) {
-	h.mu.RLock()
-	compiledModule, exists := h.cache[name]
-	h.mu.RUnlock()
-
-	if !exists {
-		return nil, nil, fmt.Errorf("module %s not found in cache", name)
-	}
-
-	// Create pipes for stdio for this specific instance.
-	hostToWasmR, hostToWasmW, err := os.Pipe()
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to create stdin pipe: %w", err)
+// UnloadModule forgets `name` so it can be loaded again, e.g. with new
+// bytes. Stop its instances first.
+func (h *WasmHost) UnloadModule(ctx context.Context, name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	m, ok := h.modules[name]
+	if !ok {
+		return
 	}
-	wasmToHostR, wasmToHostW, err := os.Pipe()
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to create stdout pipe: %w", err)
+	if m.cache != h.compilationCache {
+		m.cache.Close(ctx)
 	}
+	delete(h.modules, name)
+}
 
-	// Step 1: Configure the WASI system with all extensions
-	builder := imports.NewBuilder().
-		WithNonBlockingStdio(true).
-		WithSocketsExtension("wasmedgev2", compiledModule).
-		WithDirs("/").
-		WithStdio(int(hostToWasmR.Fd()), int(wasmToHostW.Fd()), int(os.Stderr.Fd()))
-
-	// Step 2: Instantiate the WASI system
-	ctx, _, err = builder.Instantiate(ctx, h.runtime)
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to instantiate WASI system: %w", err)
-	}
-	// Step 3: Instantiate WASI HTTP v1 extension (only once per runtime)
+// Close releases the compilation caches. Running instances close their own
+// runtimes when they exit.
+func (h *WasmHost) Close(ctx context.Context) error {
 	h.mu.Lock()
-	if !h.wasiHTTPLoaded {
-		wasiHTTP := wasi_http.MakeWasiHTTP()
-		if err := wasiHTTP.Instantiate(ctx, h.runtime); err != nil {
-			h.mu.Unlock()
-			return nil, nil, fmt.Errorf("failed to instantiate WASI HTTP: %v", err)
+	defer h.mu.Unlock()
+	for _, m := range h.modules {
+		if m.cache != h.compilationCache {
+			m.cache.Close(ctx)
 		}
-		h.wasiHTTPLoaded = true
 	}
-	h.mu.Unlock()
-	// Step 4: Instantiate and run the WASM module
-	go func() {
-		wazeroConfig := wazero.NewModuleConfig()
-		if _, err = h.runtime.InstantiateModule(ctx, compiledModule, wazeroConfig); err != nil {
-			// Handle exit errors properly
-			if exitErr, ok := err.(*sys.ExitError); ok && exitErr.ExitCode() != 0 {
-				log.Printf("exit_code: %d\n", exitErr.ExitCode())
-			} else {
-				log.Printf("failed to instantiate wasm module: %v", err)
-			}
-		}
-	}()
-	// Return the communication pipe
-	return wasmToHostR, hostToWasmW, nil
-}
-
-// Close cleanly shuts down the wazero runtime.
-func (h *WasmHost) Close(ctx context.Context) error {
-	return h.runtime.Close(ctx)
+	return h.compilationCache.Close(ctx)
 }
 
 // wasmPipeComm wraps pipes for WASM communication
@@ -240,10 +353,10 @@ type loggingReader struct {
 func (l *loggingReader) Read(p []byte) (n int, err error) {
 	n, err = l.reader.Read(p)
 	if n > 0 {
-		log.Printf("[WASM I/O] Module %s read %d bytes: %q", l.module, n, string(p[:n]))
+		slog.Debug("WASM I/O read", "module", l.module, "bytes", n, "data", string(p[:n]))
 	}
 	if err != nil && err != io.EOF {
-		log.Printf("[WASM I/O] Module %s read error: %v", l.module, err)
+		slog.Debug("WASM I/O read failed", "module", l.module, "err", err)
 	}
 	return n, err
 }
@@ -255,10 +368,10 @@ type loggingWriter struct {
 }
 
 func (l *loggingWriter) Write(p []byte) (n int, err error) {
-	log.Printf("[WASM I/O] Writing %d bytes to module %s: %q", len(p), l.module, string(p))
+	slog.Debug("WASM I/O write", "module", l.module, "bytes", len(p), "data", string(p))
 	n, err = l.writer.Write(p)
 	if err != nil {
-		log.Printf("[WASM I/O] Module %s write error: %v", l.module, err)
+		slog.Debug("WASM I/O write failed", "module", l.module, "err", err)
 	}
 	return n, err
 }
@@ -289,7 +402,6 @@ func RunWasm(ctx context.Context, wasmBytes []byte) (io.Reader, io.Writer, error
 	builder := imports.NewBuilder().
 		WithNonBlockingStdio(true).
 		WithSocketsExtension("wasmedgev2", wasmModule).
-		WithDirs("/").
 		WithStdio(int(hostToWasmR.Fd()), int(wasmToHostW.Fd()), int(os.Stderr.Fd()))
 	// Step 3: Instantiate the WASI system
 
@@ -0,0 +1,251 @@
+package wasmhost
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/experimental"
+	"github.com/tetratelabs/wazero/sys"
+)
+
+// Limits bounds every instance of a module. Zero fields mean no limit.
+type Limits struct {
+	// MaxMemoryPages caps linear memory, in 64 KiB pages.
+	MaxMemoryPages uint32
+	// Lifetime is how long an instance may run before it is stopped.
+	Lifetime time.Duration
+}
+
+// Names of the limits a LimitError can report. They match the plugin
+// config keys so users can tell which setting to raise.
+const (
+	LimitMemoryPages = "max_memory_pages"
+	LimitCallTimeout = "call_timeout"
+	LimitLifetime    = "lifetime"
+)
+
+// LimitError reports that an instance was stopped for exceeding a limit.
+type LimitError struct {
+	Module string
+	Limit  string // one of the Limit* constants
+	Value  string // the configured value, e.g. "30s"
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("module %s exceeded its %s limit (%s)", e.Module, e.Limit, e.Value)
+}
+
+// memoryLimit enforces MaxMemoryPages while an instance runs, in place of
+// the runtime's own limit, and records whether it refused the guest a
+// memory.grow. Only a guest that failed after a refused grow is reported as
+// having hit the limit.
+type memoryLimit struct {
+	maxBytes uint64
+	refused  atomic.Bool
+}
+
+// withMemoryLimit returns ctx with an allocator holding the guest to
+// `pages`, or ctx and nil when there is no limit.
+func withMemoryLimit(ctx context.Context, pages uint32) (context.Context, *memoryLimit) {
+	if pages == 0 {
+		return ctx, nil
+	}
+	limit := &memoryLimit{maxBytes: uint64(pages) * 65536}
+	return experimental.WithMemoryAllocator(ctx, limit), limit
+}
+
+// Allocate implements experimental.MemoryAllocator.
+func (l *memoryLimit) Allocate(capacity, _ uint64) experimental.LinearMemory {
+	return &limitedMemory{limit: l, buf: make([]byte, 0, min(capacity, l.maxBytes))}
+}
+
+// refusedGrow reports whether the guest asked for more memory than the
+// limit allows.
+func (l *memoryLimit) refusedGrow() bool {
+	return l != nil && l.refused.Load()
+}
+
+// limitedMemory is a guest's linear memory, grown up to its limit.
+type limitedMemory struct {
+	limit *memoryLimit
+	buf   []byte
+}
+
+// Reallocate implements experimental.LinearMemory. Linear memory never
+// shrinks, so the bytes past len(buf) have never been written.
+func (m *limitedMemory) Reallocate(size uint64) []byte {
+	if size > m.limit.maxBytes {
+		m.limit.refused.Store(true)
+		return nil
+	}
+	if size > uint64(cap(m.buf)) {
+		buf := make([]byte, size, min(max(size, 2*uint64(cap(m.buf))), m.limit.maxBytes))
+		copy(buf, m.buf)
+		m.buf = buf
+	}
+	m.buf = m.buf[:size]
+	return m.buf
+}
+
+// Free implements experimental.LinearMemory.
+func (m *limitedMemory) Free() {
+	m.buf = nil
+}
+
+// Instance is a running module. Reads return the guest's stdout and writes
+// go to its stdin.
+type Instance struct {
+	name   string
+	reader io.Reader
+	writer io.Writer
+	stdout *os.File // host end of the guest's stdout
+	stdin  *os.File // host end of the guest's stdin
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu    sync.Mutex
+	mod   api.Module // set once instantiated
+	cause error      // set by Kill, reported instead of the guest's exit
+	err   error
+}
+
+func newInstance(name string, stdout, stdin *os.File, cancel context.CancelFunc) *Instance {
+	return &Instance{
+		name:   name,
+		reader: stdout,
+		writer: stdin,
+		stdout: stdout,
+		stdin:  stdin,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+}
+
+func (i *Instance) Read(p []byte) (int, error) {
+	return i.reader.Read(p)
+}
+
+func (i *Instance) Write(p []byte) (int, error) {
+	return i.writer.Write(p)
+}
+
+// Close stops the instance and releases the host's ends of its pipes.
+func (i *Instance) Close() error {
+	i.Kill(nil)
+	return i.stdout.Close()
+}
+
+// Name returns the module name the instance was started from.
+func (i *Instance) Name() string {
+	return i.name
+}
+
+// Done is closed once the guest has exited.
+func (i *Instance) Done() <-chan struct{} {
+	return i.done
+}
+
+// Err reports why the instance stopped: a *LimitError when a limit was hit,
+// the guest's exit error otherwise, or nil after a clean exit. It is only
+// meaningful once Done is closed.
+func (i *Instance) Err() error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.err
+}
+
+// MemoryBytes returns the size of the guest's linear memory, or 0 if it
+// isn't running.
+func (i *Instance) MemoryBytes() uint64 {
+	select {
+	case <-i.done:
+		return 0
+	default:
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.mod == nil || i.mod.Memory() == nil {
+		return 0
+	}
+	return uint64(i.mod.Memory().Size())
+}
+
+func (i *Instance) setModule(mod api.Module) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.mod = mod
+}
+
+// Kill stops the instance. A non-nil cause is what Err reports afterwards,
+// e.g. a *LimitError for a tool call that overran its timeout.
+func (i *Instance) Kill(cause error) {
+	i.mu.Lock()
+	if i.cause == nil {
+		i.cause = cause
+	}
+	i.mu.Unlock()
+	i.cancel()
+	// A guest idle in poll_oneoff only notices cancellation once it wakes;
+	// closing its stdin wakes it.
+	i.stdin.Close()
+}
+
+func (i *Instance) finish(err error) {
+	i.mu.Lock()
+	if i.cause != nil {
+		err = i.cause
+	}
+	i.err = err
+	i.mu.Unlock()
+	close(i.done)
+}
+
+// runStart instantiates the module without its start function, hands it
+// to `instantiated` and then calls _start, so the module stays inspectable
+// while the guest runs and after it exits.
+func runStart(ctx context.Context, runtime wazero.Runtime, compiled wazero.CompiledModule, instantiated func(api.Module)) (api.Module, error) {
+	mod, err := runtime.InstantiateModule(ctx, compiled, wazero.NewModuleConfig().WithStartFunctions())
+	if err != nil {
+		return nil, err
+	}
+	instantiated(mod)
+	start := mod.ExportedFunction("_start")
+	if start == nil {
+		return mod, fmt.Errorf("module does not export _start")
+	}
+	_, err = start.Call(ctx)
+	return mod, err
+}
+
+// exitError interprets the error _start returned, attributing it to a limit
+// where possible. `memory` is the instance's memory limit, if any.
+func exitError(name string, memory *memoryLimit, limits Limits, err error) error {
+	if err == nil {
+		return nil
+	}
+	var exitErr *sys.ExitError
+	if errors.As(err, &exitErr) {
+		switch exitErr.ExitCode() {
+		case 0:
+			return nil
+		case sys.ExitCodeDeadlineExceeded:
+			if limits.Lifetime > 0 {
+				return &LimitError{Module: name, Limit: LimitLifetime, Value: limits.Lifetime.String()}
+			}
+		case sys.ExitCodeContextCanceled:
+			return context.Canceled
+		}
+	}
+	if memory.refusedGrow() {
+		return &LimitError{Module: name, Limit: LimitMemoryPages, Value: fmt.Sprint(limits.MaxMemoryPages)}
+	}
+	return err
+}
@@ -0,0 +1,194 @@
+package wasmhost
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/tetratelabs/wazero/sys"
+)
+
+func TestExitError(t *testing.T) {
+	limits := Limits{MaxMemoryPages: 256, Lifetime: time.Hour}
+	trap := errors.New("wasm error: unreachable")
+	refused := &memoryLimit{}
+	refused.refused.Store(true)
+
+	tests := []struct {
+		name      string
+		memory    *memoryLimit
+		limits    Limits
+		err       error
+		wantLimit string
+		wantErr   error
+	}{
+		{name: "clean", memory: &memoryLimit{}, limits: limits},
+		{name: "exit 0", memory: &memoryLimit{}, limits: limits, err: sys.NewExitError(0)},
+		{name: "lifetime", memory: &memoryLimit{}, limits: limits, err: sys.NewExitError(sys.ExitCodeDeadlineExceeded), wantLimit: LimitLifetime},
+		{name: "killed", memory: &memoryLimit{}, limits: limits, err: sys.NewExitError(sys.ExitCodeContextCanceled), wantErr: context.Canceled},
+		{name: "out of memory", memory: refused, limits: limits, err: sys.NewExitError(2), wantLimit: LimitMemoryPages},
+		{name: "trap without refused grow", memory: &memoryLimit{}, limits: limits, err: trap, wantErr: trap},
+		{name: "no memory limit", err: trap, wantErr: trap},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := exitError("plugin-0", tt.memory, tt.limits, tt.err)
+			var limitErr *LimitError
+			switch {
+			case tt.wantLimit != "":
+				if !errors.As(got, &limitErr) || limitErr.Limit != tt.wantLimit {
+					t.Errorf("exitError = %v, want %s limit", got, tt.wantLimit)
+				}
+			case got != tt.wantErr && !errors.Is(got, tt.wantErr):
+				t.Errorf("exitError = %v, want %v", got, tt.wantErr)
+			}
+		})
+	}
+}
+
+// testModule assembles a module that exports `memory`, starting at
+// `pages` pages, and a _start function with the given body (locals and
+// instructions, without the final end).
+func testModule(pages byte, body ...byte) []byte {
+	body = append(body, 0x0b) // end
+	wasm := []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00}
+	wasm = append(wasm, 0x01, 0x04, 0x01, 0x60, 0x00, 0x00) // type 0: func()
+	wasm = append(wasm, 0x03, 0x02, 0x01, 0x00)             // func 0: type 0
+	wasm = append(wasm, 0x05, 0x03, 0x01, 0x00, pages)      // memory 0: min pages, no max
+	wasm = append(wasm, 0x07, 0x13, 0x02,                   // exports
+		0x06, '_', 's', 't', 'a', 'r', 't', 0x00, 0x00,
+		0x06, 'm', 'e', 'm', 'o', 'r', 'y', 0x02, 0x00)
+	wasm = append(wasm, 0x0a, byte(len(body)+2), 0x01, byte(len(body)))
+	return append(wasm, body...)
+}
+
+var (
+	// growForever grows memory a page at a time, trapping once refused.
+	growForever = testModule(1,
+		0x00,       // no locals
+		0x03, 0x40, // loop
+		0x41, 0x01, // i32.const 1
+		0x40, 0x00, // memory.grow
+		0x41, 0x7f, // i32.const -1
+		0x47,       // i32.ne
+		0x0d, 0x00, // br_if 0
+		0x0b, // end
+		0x00, // unreachable
+	)
+	// spinForever never returns.
+	spinForever = testModule(1,
+		0x00,       // no locals
+		0x03, 0x40, // loop
+		0x0c, 0x00, // br 0
+		0x0b, // end
+	)
+	// trapWithMemory traps while holding most of its memory limit.
+	trapWithMemory = testModule(60,
+		0x00, // no locals
+		0x00, // unreachable
+	)
+)
+
+// runToExit runs `wasm` under `limits`, lets `running` act on the instance
+// and returns the instance's error once it stops.
+func runToExit(t *testing.T, wasm []byte, limits Limits, running func(*Instance)) error {
+	t.Helper()
+	ctx := context.Background()
+	host := NewWasmHost(ctx)
+	defer host.Close(ctx)
+	if err := host.LoadModule(ctx, "plugin-0", wasm, limits); err != nil {
+		t.Fatal(err)
+	}
+	inst, err := host.RunModule(ctx, "plugin-0", RunOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inst.Close()
+	if running != nil {
+		running(inst)
+	}
+	select {
+	case <-inst.Done():
+	case <-time.After(10 * time.Second):
+		t.Fatal("instance did not stop")
+	}
+	return inst.Err()
+}
+
+func TestLimitsEnforced(t *testing.T) {
+	callTimeout := &LimitError{Module: "plugin-0", Limit: LimitCallTimeout, Value: "1s"}
+
+	tests := []struct {
+		name      string
+		wasm      []byte
+		limits    Limits
+		running   func(*Instance)
+		wantLimit string
+	}{
+		{name: "memory", wasm: growForever, limits: Limits{MaxMemoryPages: 4}, wantLimit: LimitMemoryPages},
+		{name: "lifetime", wasm: spinForever, limits: Limits{Lifetime: 100 * time.Millisecond}, wantLimit: LimitLifetime},
+		{
+			// What a forwarded tool call does when it overruns call_timeout.
+			name:      "call timeout",
+			wasm:      spinForever,
+			running:   func(inst *Instance) { inst.Kill(callTimeout) },
+			wantLimit: LimitCallTimeout,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := runToExit(t, tt.wasm, tt.limits, tt.running)
+			var limitErr *LimitError
+			if !errors.As(err, &limitErr) || limitErr.Limit != tt.wantLimit {
+				t.Errorf("Err() = %v, want %s limit", err, tt.wantLimit)
+			}
+		})
+	}
+}
+
+func TestMemoryLimitOnlyBlamedForRefusedGrow(t *testing.T) {
+	// The guest is within a few pages of the limit, but fails for another
+	// reason.
+	err := runToExit(t, trapWithMemory, Limits{MaxMemoryPages: 64}, nil)
+	var limitErr *LimitError
+	if err == nil || errors.As(err, &limitErr) {
+		t.Errorf("Err() = %v, want the guest's trap", err)
+	}
+}
+
+func TestInstance_KillCause(t *testing.T) {
+	stdoutR, stdoutW, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdoutW.Close()
+	stdinR, stdinW, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdinR.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	inst := newInstance("plugin-0", stdoutR, stdinW, cancel)
+	cause := &LimitError{Module: "plugin-0", Limit: LimitCallTimeout, Value: "1s"}
+	inst.Kill(cause)
+	if ctx.Err() == nil {
+		t.Errorf("Kill did not cancel the instance context")
+	}
+	inst.finish(context.Canceled)
+
+	select {
+	case <-inst.Done():
+	default:
+		t.Fatal("Done not closed after finish")
+	}
+	if inst.Err() != cause {
+		t.Errorf("Err() = %v, want kill cause", inst.Err())
+	}
+	if _, err := inst.Write([]byte("x")); err == nil {
+		t.Errorf("write after Kill succeeded, want closed stdin")
+	}
+	inst.Close()
+}